@@ -0,0 +1,101 @@
+// Package airport infers where flights took off and landed. The airports and
+// runways come from the OurAirports data that dataloader puts in the airport
+// and runway tables.
+//
+// A flight departed from an airport if its track starts low and close to it,
+// and arrived at one if its track ends low and close to it; see Departure and
+// Arrival. Refresh looks for the departure and arrival of every finished
+// flight that hasn't been checked yet and stores them on the flight table,
+// and web calls it periodically.
+package airport
+
+import (
+	"math"
+	"sort"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// Heliport is the type of an airport for helicopters only. Only rotorcraft
+// are matched to heliports.
+const Heliport = "heliport"
+
+// Airport is an airport and the ends of its runways.
+type Airport struct {
+	Ident     string
+	Type      string
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Elevation int // feet, or 0 if unknown
+	Runways   []RunwayEnd
+}
+
+// RunwayEnd is one end of a runway, named for the direction aircraft take
+// off and land towards from it (the "16L" of 16L/34R).
+type RunwayEnd struct {
+	Ident string
+
+	// Located is whether the threshold position is known. Without it a
+	// runway can only be matched by heading.
+	Located   bool
+	Latitude  float64
+	Longitude float64
+
+	// Heading is the true heading of the runway from this end.
+	Heading float64
+}
+
+// Index finds airports by position.
+type Index struct {
+	cells map[[2]int][]*Airport
+	n     int
+}
+
+// NewIndex returns an index of airports.
+func NewIndex(airports []*Airport) *Index {
+	idx := &Index{cells: make(map[[2]int][]*Airport)}
+	for _, a := range airports {
+		c := cell(a.Latitude, a.Longitude)
+		idx.cells[c] = append(idx.cells[c], a)
+	}
+	idx.n = len(airports)
+	return idx
+}
+
+// Len returns the number of airports in the index.
+func (idx *Index) Len() int {
+	return idx.n
+}
+
+// Near returns the airports within maxNM nautical miles of a position,
+// nearest first. The index is divided into one-degree cells and only the
+// cells around the position are searched, so maxNM should be well under 60.
+func (idx *Index) Near(lat, lon, maxNM float64) []*Airport {
+	type near struct {
+		a *Airport
+		d float64
+	}
+	var found []near
+	c := cell(lat, lon)
+	for dlat := -1; dlat <= 1; dlat++ {
+		for dlon := -1; dlon <= 1; dlon++ {
+			lonCell := (c[1]+dlon+180+360)%360 - 180
+			for _, a := range idx.cells[[2]int{c[0] + dlat, lonCell}] {
+				if d := geo.Distance(lat, lon, a.Latitude, a.Longitude); d <= maxNM {
+					found = append(found, near{a, d})
+				}
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].d < found[j].d })
+	airports := make([]*Airport, len(found))
+	for i := range found {
+		airports[i] = found[i].a
+	}
+	return airports
+}
+
+func cell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
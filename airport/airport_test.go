@@ -0,0 +1,162 @@
+package airport
+
+import (
+	"testing"
+)
+
+// testAirports are a big airport with parallel runways, a small one with an
+// unlocated runway, and a heliport next to the big one.
+func testAirports() []*Airport {
+	return []*Airport{
+		{
+			Ident: "KSEA", Type: "large_airport", Name: "Seattle Tacoma International Airport",
+			Latitude: 47.449, Longitude: -122.309, Elevation: 433,
+			Runways: []RunwayEnd{
+				{Ident: "16L", Located: true, Latitude: 47.4638, Longitude: -122.3079, Heading: 180},
+				{Ident: "34R", Located: true, Latitude: 47.4310, Longitude: -122.3080, Heading: 0},
+				{Ident: "16R", Located: true, Latitude: 47.4638, Longitude: -122.3179, Heading: 180},
+				{Ident: "34L", Located: true, Latitude: 47.4310, Longitude: -122.3180, Heading: 0},
+			},
+		},
+		{
+			Ident: "S50", Type: "small_airport", Name: "Auburn Municipal Airport",
+			Latitude: 47.3277, Longitude: -122.2267, Elevation: 63,
+			Runways: []RunwayEnd{
+				{Ident: "16", Heading: 163},
+				{Ident: "34", Heading: 343},
+			},
+		},
+		{
+			Ident: "WA99", Type: Heliport, Name: "Test Heliport",
+			Latitude: 47.452, Longitude: -122.300, Elevation: 400,
+		},
+	}
+}
+
+// line returns n points from lat, lon, moving north (or south if step is
+// negative) by step degrees of latitude each time and climbing by climb feet.
+func line(lat, lon, step float64, alt, climb, heading, n int) []Point {
+	var points []Point
+	for i := 0; i < n; i++ {
+		points = append(points, Point{
+			Latitude: lat + float64(i)*step, Longitude: lon,
+			Altitude: alt + i*climb, AltitudeValid: true,
+			Heading: heading, HeadingValid: true,
+		})
+	}
+	return points
+}
+
+func TestNear(t *testing.T) {
+	idx := NewIndex(testAirports())
+	if idx.Len() != 3 {
+		t.Errorf("Len() = %d; want 3", idx.Len())
+	}
+	near := idx.Near(47.45, -122.30, 5)
+	if len(near) != 2 || near[0].Ident != "WA99" || near[1].Ident != "KSEA" {
+		t.Errorf("Near(KSEA, 5) = %v; want WA99 then KSEA", near)
+	}
+	if near := idx.Near(47.45, -122.30, 20); len(near) != 3 {
+		t.Errorf("Near(KSEA, 20) found %d airports; want 3", len(near))
+	}
+	if near := idx.Near(48.0, -120.0, 20); len(near) != 0 {
+		t.Errorf("Near(somewhere else) = %v; want nothing", near)
+	}
+
+	// Airports in the next cell over are found.
+	idx = NewIndex([]*Airport{{Ident: "EDGE", Latitude: 47.001, Longitude: -121.999}})
+	if near := idx.Near(46.999, -122.001, 1); len(near) != 1 {
+		t.Errorf("Near across cells found %d airports; want 1", len(near))
+	}
+	idx = NewIndex([]*Airport{{Ident: "DATELINE", Latitude: 0, Longitude: 179.999}})
+	if near := idx.Near(0, -179.999, 1); len(near) != 1 {
+		t.Errorf("Near across the date line found %d airports; want 1", len(near))
+	}
+}
+
+func TestDeparture(t *testing.T) {
+	idx := NewIndex(testAirports())
+
+	// Taking off south from 16R and climbing out.
+	track := line(47.46, -122.3178, -0.005, 450, 150, 180, 40)
+	m, ok := Departure(idx, track, false)
+	if !ok || m.Airport.Ident != "KSEA" || m.Runway != "16R" {
+		t.Errorf("Departure(16R) = %+v, %v; want KSEA 16R", m, ok)
+	}
+
+	// Taking off north from 34R; the heliport is closer to the start of the
+	// track but isn't for airplanes.
+	track = line(47.432, -122.3081, 0.005, 450, 150, 2, 40)
+	m, ok = Departure(idx, track, false)
+	if !ok || m.Airport.Ident != "KSEA" || m.Runway != "34R" {
+		t.Errorf("Departure(34R) = %+v, %v; want KSEA 34R", m, ok)
+	}
+
+	// First seen already high: no departure.
+	track = line(47.45, -122.30, 0.005, 5000, 0, 0, 10)
+	if m, ok = Departure(idx, track, false); ok {
+		t.Errorf("Departure(overflight) = %+v; want none", m)
+	}
+
+	// A helicopter lifting off straight up from the heliport.
+	track = []Point{
+		{Latitude: 47.452, Longitude: -122.300},
+		{Latitude: 47.452, Longitude: -122.300, Altitude: 800, AltitudeValid: true},
+		{Latitude: 47.46, Longitude: -122.300, Altitude: 1500, AltitudeValid: true, Heading: 90, HeadingValid: true},
+	}
+	m, ok = Departure(idx, track, true)
+	if !ok || m.Airport.Ident != "WA99" || m.Runway != "" {
+		t.Errorf("Departure(helicopter) = %+v, %v; want WA99", m, ok)
+	}
+
+	// No heading matches a runway: the nearest airport, with no runway.
+	track = line(47.449, -122.309, 0.001, 600, 100, 90, 5)
+	m, ok = Departure(idx, track, false)
+	if !ok || m.Airport.Ident != "KSEA" || m.Runway != "" {
+		t.Errorf("Departure(crosswind) = %+v, %v; want KSEA and no runway", m, ok)
+	}
+
+	if _, ok = Departure(idx, nil, false); ok {
+		t.Error("Departure(no track) found a departure")
+	}
+}
+
+func TestArrival(t *testing.T) {
+	idx := NewIndex(testAirports())
+
+	// Descending from the south onto 34L; the receiver loses the aircraft
+	// just short of the runway.
+	track := line(47.30, -122.3181, 0.003, 3000, -60, 358, 40)
+	m, ok := Arrival(idx, track, false)
+	if !ok || m.Airport.Ident != "KSEA" || m.Runway != "34L" {
+		t.Errorf("Arrival(34L) = %+v, %v; want KSEA 34L", m, ok)
+	}
+
+	// Landing on 16 at Auburn, whose runway thresholds aren't known.
+	track = line(47.40, -122.2267, -0.006, 2000, -150, 163, 12)
+	m, ok = Arrival(idx, track, false)
+	if !ok || m.Airport.Ident != "S50" || m.Runway != "16" {
+		t.Errorf("Arrival(S50 16) = %+v, %v; want S50 16", m, ok)
+	}
+
+	// Still climbing when last seen: no arrival.
+	track = line(47.46, -122.3178, -0.005, 450, 150, 180, 40)
+	if m, ok = Arrival(idx, track, false); ok {
+		t.Errorf("Arrival(departure) = %+v; want none", m)
+	}
+}
+
+func TestAngleBetween(t *testing.T) {
+	tests := []struct{ a, b, want float64 }{
+		{10, 350, 20},
+		{350, 10, 20},
+		{0, 180, 180},
+		{90, 90, 0},
+		{720, 10, 10},
+	}
+	for _, tt := range tests {
+		if got := angleBetween(tt.a, tt.b); got != tt.want {
+			t.Errorf("angleBetween(%v, %v) = %v; want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
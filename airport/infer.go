@@ -0,0 +1,167 @@
+package airport
+
+import (
+	"math"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// MaxDistance is how close, in nautical miles, to an airport's reference
+// point a track must start to have departed from it, or end to have arrived
+// at it. It allows for big airports and for tracks that start or end a few
+// miles out because the receiver can't hear aircraft on the ground.
+const MaxDistance = 6
+
+// LowAltitude is how high, in feet above the airport, the first or last
+// point of a track can be for the flight to have departed from or arrived at
+// it. Points up to this height are used to match the runway.
+const LowAltitude = 2000
+
+// runwayDistance is how far, in nautical miles, from the runway threshold
+// points are used to match the runway.
+const runwayDistance = 10
+
+// runwayTolerance is how far, in degrees, a point's track can be from the
+// runway heading.
+const runwayTolerance = 15
+
+// centerlineWidth is how far, in nautical miles, a point can be to either
+// side of the extended runway centerline.
+const centerlineWidth = 0.3
+
+// Point is a position in a flight's track log.
+type Point struct {
+	Latitude      float64
+	Longitude     float64
+	Altitude      int
+	AltitudeValid bool
+	Heading       int
+	HeadingValid  bool
+}
+
+// Movement is a departure from or arrival at an airport.
+type Movement struct {
+	Airport *Airport
+	Runway  string // the runway end, or "" if it couldn't be told
+}
+
+// Departure returns the airport, and if possible the runway, that a flight
+// departed from, given its track in time order. ok is false if the track
+// doesn't start low and close to an airport. Heliports are only considered
+// for rotorcraft.
+func Departure(idx *Index, track []Point, rotorcraft bool) (m Movement, ok bool) {
+	return infer(idx, track, rotorcraft)
+}
+
+// Arrival is like Departure, but for the airport and runway that a flight
+// arrived at.
+func Arrival(idx *Index, track []Point, rotorcraft bool) (m Movement, ok bool) {
+	reversed := make([]Point, len(track))
+	for i, p := range track {
+		reversed[len(track)-1-i] = p
+	}
+	return infer(idx, reversed, rotorcraft)
+}
+
+// infer finds the movement at the start of track. Of the airports close to
+// the first point, the nearest one where a runway matches is chosen, or the
+// nearest one if no runway matches anywhere.
+func infer(idx *Index, track []Point, rotorcraft bool) (m Movement, ok bool) {
+	if len(track) == 0 {
+		return Movement{}, false
+	}
+	first := track[0]
+	for _, a := range idx.Near(first.Latitude, first.Longitude, MaxDistance) {
+		if a.Type == Heliport && !rotorcraft {
+			continue
+		}
+		ceiling := a.Elevation + LowAltitude
+		if first.AltitudeValid && first.Altitude > ceiling {
+			continue
+		}
+		var low []Point
+		for _, p := range track {
+			if p.AltitudeValid && p.Altitude > ceiling {
+				break
+			}
+			if geo.Distance(a.Latitude, a.Longitude, p.Latitude, p.Longitude) > runwayDistance {
+				break
+			}
+			low = append(low, p)
+		}
+		if runway := matchRunway(a, low); runway != "" {
+			return Movement{a, runway}, true
+		}
+		if !ok {
+			m, ok = Movement{Airport: a}, true
+		}
+	}
+	return m, ok
+}
+
+// matchRunway returns the runway end that the most points are lined up
+// with, or "" if none are. Each point with a track counts for the end with
+// the nearest centerline among those with a heading close to the track.
+// Runway ends without a known threshold only count points close to the
+// airport, as if they were on the edge of the centerline.
+func matchRunway(a *Airport, points []Point) string {
+	votes := make(map[string]int)
+	for _, p := range points {
+		if !p.HeadingValid {
+			continue
+		}
+		best := ""
+		bestCross := math.Inf(1)
+		for _, r := range a.Runways {
+			if angleBetween(float64(p.Heading), r.Heading) > runwayTolerance {
+				continue
+			}
+			cross := centerlineWidth
+			if r.Located {
+				var along float64
+				along, cross = offset(r, p)
+				if math.Abs(along) > runwayDistance || math.Abs(cross) > centerlineWidth {
+					continue
+				}
+				cross = math.Abs(cross)
+			} else if geo.Distance(a.Latitude, a.Longitude, p.Latitude, p.Longitude) > MaxDistance/2 {
+				continue
+			}
+			if cross < bestCross {
+				best, bestCross = r.Ident, cross
+			}
+		}
+		if best != "" {
+			votes[best]++
+		}
+	}
+
+	best := ""
+	for _, r := range a.Runways {
+		if votes[r.Ident] > votes[best] {
+			best = r.Ident
+		}
+	}
+	return best
+}
+
+// offset returns how far, in nautical miles, a point is along the runway's
+// extended centerline from the threshold (negative before it) and to the
+// right of it. The Earth is taken to be flat around the runway.
+func offset(r RunwayEnd, p Point) (along, cross float64) {
+	dx := (p.Longitude - r.Longitude) * 60 * math.Cos(r.Latitude*(math.Pi/180))
+	dy := (p.Latitude - r.Latitude) * 60
+	h := r.Heading * (math.Pi / 180)
+	sin, cos := math.Sincos(h)
+	return dx*sin + dy*cos, dx*cos - dy*sin
+}
+
+// angleBetween returns the difference between two headings, from 0 to 180
+// degrees.
+func angleBetween(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
@@ -0,0 +1,183 @@
+package airport
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+// flightBatch is the number of flights checked in each transaction.
+const flightBatch = 200
+
+// LoadIndex reads the airports and runways from the database. Runway ends
+// without a heading are left out, since they can't be matched.
+func LoadIndex(db *sql.DB) (*Index, error) {
+	rows, err := db.Query(`SELECT ident, type, name, latitude, longitude, coalesce(elevation, 0) FROM airport`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byIdent := make(map[string]*Airport)
+	var airports []*Airport
+	for rows.Next() {
+		a := &Airport{}
+		if err = rows.Scan(&a.Ident, &a.Type, &a.Name, &a.Latitude, &a.Longitude, &a.Elevation); err != nil {
+			return nil, err
+		}
+		byIdent[a.Ident] = a
+		airports = append(airports, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT airport, ident, latitude, longitude, heading FROM runway
+		WHERE heading IS NOT NULL ORDER BY airport, ident`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ident string
+		var r RunwayEnd
+		var lat, lon sql.NullFloat64
+		if err = rows.Scan(&ident, &r.Ident, &lat, &lon, &r.Heading); err != nil {
+			return nil, err
+		}
+		a, ok := byIdent[ident]
+		if !ok {
+			continue
+		}
+		if lat.Valid && lon.Valid {
+			r.Located, r.Latitude, r.Longitude = true, lat.Float64, lon.Float64
+		}
+		a.Runways = append(a.Runways, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewIndex(airports), nil
+}
+
+// Refresh looks for the departure and arrival of each finished flight that
+// hasn't been checked yet, and records them on the flight table. Nothing is
+// done while the airport table is empty, so that flights aren't marked as
+// checked before there is anything to check them against.
+//
+// Flights are claimed with SKIP LOCKED, so refreshes running at once share
+// the work.
+func Refresh(db *sql.DB) error {
+	idx, err := LoadIndex(db)
+	if err != nil {
+		return fmt.Errorf("airport: loading airports: %v", err)
+	}
+	if idx.Len() == 0 {
+		log.Warn().Msg("No airports loaded (see dataloader); not looking for flight departures and arrivals")
+		return nil
+	}
+	for {
+		n, err := refreshFlights(db, idx)
+		if err != nil {
+			return fmt.Errorf("airport: %v", err)
+		}
+		if n < flightBatch {
+			return nil
+		}
+	}
+}
+
+// refreshFlights checks the next batch of flights and returns the number of
+// flights checked.
+func refreshFlights(db *sql.DB, idx *Index) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, category FROM flight
+		WHERE NOT airports_checked AND last_seen IS NOT NULL
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, flightBatch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []int64
+	rotorcraft := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		var category sql.NullInt64
+		if err = rows.Scan(&id, &category); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+		rotorcraft[id] = category.Valid && decoder.AircraftType(category.Int64) == decoder.ACTypeRotocraft
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	rows, err = tx.Query(`SELECT flight_id, latitude, longitude, altitude, heading FROM tracklog
+		WHERE flight_id = ANY($1) AND latitude IS NOT NULL AND longitude IS NOT NULL
+		ORDER BY flight_id, time, id`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	tracks := make(map[int64][]Point)
+	for rows.Next() {
+		var id int64
+		var p Point
+		var alt, heading sql.NullInt64
+		if err = rows.Scan(&id, &p.Latitude, &p.Longitude, &alt, &heading); err != nil {
+			return 0, err
+		}
+		p.Altitude, p.AltitudeValid = int(alt.Int64), alt.Valid
+		p.Heading, p.HeadingValid = int(heading.Int64), heading.Valid
+		tracks[id] = append(tracks[id], p)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	stmt, err := tx.Prepare(`UPDATE flight SET departure_airport=$2, departure_runway=$3,
+		arrival_airport=$4, arrival_runway=$5, airports_checked=true WHERE id=$1`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, id := range ids {
+		var depAirport, depRunway, arrAirport, arrRunway *string
+		if m, ok := Departure(idx, tracks[id], rotorcraft[id]); ok {
+			depAirport, depRunway = movementColumns(m)
+		}
+		if m, ok := Arrival(idx, tracks[id], rotorcraft[id]); ok {
+			arrAirport, arrRunway = movementColumns(m)
+		}
+		if _, err = stmt.Exec(id, depAirport, depRunway, arrAirport, arrRunway); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
+
+func movementColumns(m Movement) (airport, runway *string) {
+	airport = &m.Airport.Ident
+	if m.Runway != "" {
+		runway = &m.Runway
+	}
+	return airport, runway
+}
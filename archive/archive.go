@@ -0,0 +1,123 @@
+// Package archive manages the monthly partitions of the raw_message table
+// and reads and writes the files that old partitions are exported to.
+//
+// An archive file is a Parquet file, with gzip compressed pages, holding one
+// row per raw message with the raw_message columns:
+//
+//	id         int64
+//	created_at timestamp (microseconds, UTC)
+//	signal     int32, missing if the receiver didn't report it
+//	timestamp  binary, the beast timestamp bytes
+//	message    binary, the Mode S message bytes
+//
+// so the archives can be opened by any Parquet tool as well as restored with
+// `dbloader -import`.
+package archive
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/racingmars/flighttrack/parquet"
+)
+
+var columns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "created_at", Type: parquet.Timestamp},
+	{Name: "signal", Type: parquet.Int32, Optional: true},
+	{Name: "timestamp", Type: parquet.Binary, Optional: true},
+	{Name: "message", Type: parquet.Binary},
+}
+
+// Record is a single row of the raw_message table. A Signal of 0 means the
+// signal level isn't known.
+type Record struct {
+	ID        int64
+	Time      time.Time
+	Signal    uint8
+	Timestamp []byte
+	Message   []byte
+}
+
+// Writer writes Records to an archive file.
+type Writer struct {
+	pw *parquet.Writer
+}
+
+// NewWriter starts a new archive on w. Close must be called to finish the
+// file; it does not close w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw, err := parquet.NewWriter(w, columns, 0)
+	if err != nil {
+		return nil, err
+	}
+	pw.SetCodec(parquet.Gzip)
+	return &Writer{pw: pw}, nil
+}
+
+// Write appends a record to the archive.
+func (w *Writer) Write(r Record) error {
+	var signal, timestamp interface{}
+	if r.Signal != 0 {
+		signal = int32(r.Signal)
+	}
+	if r.Timestamp != nil {
+		timestamp = r.Timestamp
+	}
+	message := r.Message
+	if message == nil {
+		message = []byte{}
+	}
+	return w.pw.Write([]interface{}{r.ID, r.Time, signal, timestamp, message})
+}
+
+// Close writes the end of the archive.
+func (w *Writer) Close() error {
+	return w.pw.Close()
+}
+
+// Reader reads Records from an archive file.
+type Reader struct {
+	pr *parquet.Reader
+}
+
+// NewReader opens the size byte archive file in r and checks that it has the
+// archive's columns.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	pr, err := parquet.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(pr.Columns(), columns) {
+		return nil, fmt.Errorf("not a raw message archive")
+	}
+	return &Reader{pr: pr}, nil
+}
+
+// Len returns the number of records in the archive.
+func (r *Reader) Len() int64 {
+	return r.pr.NumRows()
+}
+
+// Read returns the next record in the archive, or io.EOF when there are no
+// more records.
+func (r *Reader) Read() (*Record, error) {
+	row, err := r.pr.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec := &Record{
+		ID:      row[0].(int64),
+		Time:    row[1].(time.Time),
+		Message: row[4].([]byte),
+	}
+	if signal, ok := row[2].(int32); ok {
+		rec.Signal = uint8(signal)
+	}
+	if timestamp, ok := row[3].([]byte); ok {
+		rec.Timestamp = timestamp
+	}
+	return rec, nil
+}
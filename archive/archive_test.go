@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/racingmars/flighttrack/parquet"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := []Record{
+		{ID: 1, Time: time.Date(2019, 3, 9, 12, 0, 0, 123000, time.UTC), Signal: 200,
+			Timestamp: []byte{1, 2, 3, 4, 5, 6}, Message: []byte{0x8d, 0x48, 0x40, 0xd6}},
+		{ID: 2, Time: time.Date(2019, 3, 9, 12, 0, 1, 0, time.UTC), Signal: 0},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != int64(len(records)) {
+		t.Errorf("Len() = %d, want %d", r.Len(), len(records))
+	}
+	for _, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != want.ID || !got.Time.Equal(want.Time) || got.Signal != want.Signal ||
+			!bytes.Equal(got.Timestamp, want.Timestamp) || !bytes.Equal(got.Message, want.Message) {
+			t.Errorf("Read %+v, expected %+v", got, want)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Expected io.EOF after last record, got %v", err)
+	}
+}
+
+func TestNotAnArchive(t *testing.T) {
+	// A Parquet file, but not with the archive's columns
+	var buf bytes.Buffer
+	w, err := parquet.NewWriter(&buf, []parquet.Column{{Name: "id", Type: parquet.Int64}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]interface{}{int64(1)})
+	w.Close()
+
+	if _, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Errorf("Expected error for a Parquet file that isn't an archive")
+	}
+	garbage := []byte("not an archive")
+	if _, err := NewReader(bytes.NewReader(garbage), int64(len(garbage))); err == nil {
+		t.Errorf("Expected error for a file that isn't Parquet")
+	}
+}
@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Execer is satisfied by *sql.DB, *sql.Tx, and the sqlx equivalents.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Queryer is satisfied by *sql.DB, *sql.Tx, and the sqlx equivalents.
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Partition is one monthly partition of the raw_message table.
+type Partition struct {
+	Name  string
+	Month time.Time
+}
+
+var partitionName = regexp.MustCompile(`^raw_message_([0-9]{6})$`)
+
+// MonthStart returns midnight UTC on the first day of t's month.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsurePartition creates the raw_message partition for the month containing
+// t if it doesn't already exist.
+func EnsurePartition(db Execer, t time.Time) error {
+	_, err := db.Exec(`SELECT raw_message_create_partition($1)`, MonthStart(t))
+	return err
+}
+
+// EnsurePartitions creates the raw_message partitions for the month
+// containing t and for the following month, so inserts never fail at a month
+// boundary.
+func EnsurePartitions(db Execer, t time.Time) error {
+	if err := EnsurePartition(db, t); err != nil {
+		return err
+	}
+	return EnsurePartition(db, MonthStart(t).AddDate(0, 1, 0))
+}
+
+// ListPartitions returns the attached partitions of raw_message, oldest first.
+func ListPartitions(db Queryer) ([]Partition, error) {
+	rows, err := db.Query(
+		`SELECT c.relname
+		 FROM pg_inherits i
+		 INNER JOIN pg_class c ON c.oid=i.inhrelid
+		 WHERE i.inhparent='raw_message'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []Partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		match := partitionName.FindStringSubmatch(name)
+		if match == nil {
+			// Not one of ours; leave it alone.
+			continue
+		}
+		month, err := time.Parse("200601", match[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected partition name %s: %v", name, err)
+		}
+		partitions = append(partitions, Partition{Name: name, Month: month})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Month.Before(partitions[j].Month)
+	})
+	return partitions, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+// archiver enforces the retention policy for the raw_message table. Monthly
+// partitions older than the retention period are exported to Parquet archive
+// files, then detached and dropped. The archives can be loaded back into
+// raw_message with `dbloader -import`.
+//
+// Partitions holding messages that dbloader (or web -realtime) hasn't decoded
+// yet are left alone, as are all newer ones, unless -force is given.
+//
+// Run with the connection string to Postgres in env variable "DBURL", e.g.
+// $ DBURL="user=flights dbname=flights sslmode=disable" \
+//   ./archiver -keep 6 -dir /srv/flighttrack/archive
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/archive"
+)
+
+var keepMonths = flag.Int("keep", 3, "Number of months of raw messages to keep in the database, including the current month")
+var archiveDir = flag.String("dir", ".", "Write archive files to `directory`")
+var dryRun = flag.Bool("dryrun", false, "Only report which partitions would be archived")
+var noDrop = flag.Bool("nodrop", false, "Detach archived partitions but leave them in the database as stand-alone raw_message_YYYYMM_archived tables")
+var force = flag.Bool("force", false, "Archive partitions even if they hold messages that haven't been decoded yet")
+
+func main() {
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+
+	if *keepMonths < 1 {
+		log.Fatal().Msg("-keep must be at least 1")
+	}
+
+	db, err := getConnection()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't connect to DB")
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	if err = archive.EnsurePartitions(db, now); err != nil {
+		log.Fatal().Err(err).Msg("couldn't create upcoming raw_message partitions")
+	}
+
+	partitions, err := archive.ListPartitions(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't list raw_message partitions")
+	}
+
+	cutoff := archive.MonthStart(now).AddDate(0, -(*keepMonths - 1), 0)
+	log.Info().Msgf("Archiving raw_message partitions before %s", cutoff.Format("2006-01"))
+
+	for _, p := range partitions {
+		if !p.Month.Before(cutoff) {
+			break
+		}
+		if !*force {
+			if err := checkDecoded(db, p); err != nil {
+				log.Error().Err(err).Msgf("Not archiving %s or any later partitions", p.Name)
+				return
+			}
+		}
+		if *dryRun {
+			log.Info().Msgf("Would archive %s", p.Name)
+			continue
+		}
+		if err := archivePartition(db, p); err != nil {
+			log.Error().Err(err).Msgf("Couldn't archive %s", p.Name)
+			return
+		}
+	}
+}
+
+func getConnection() (*sqlx.DB, error) {
+	connStr, ok := os.LookupEnv("DBURL")
+	if !ok {
+		return nil, fmt.Errorf("DBURL environment variable not set")
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	return db, err
+}
+
+// checkDecoded returns an error if the partition holds messages past the
+// last one decoded, which would only be decoded again after a -reset once
+// archived. Messages not yet counted in the coverage statistics only get a
+// warning, since coverage may be turned off.
+func checkDecoded(db *sqlx.DB, p archive.Partition) error {
+	// p.Name is validated by archive.ListPartitions, so it's safe to use
+	// directly in the query.
+	var maxID sql.NullInt64
+	if err := db.Get(&maxID, fmt.Sprintf(`SELECT max(id) FROM %s`, p.Name)); err != nil {
+		return err
+	}
+	if !maxID.Valid {
+		return nil
+	}
+
+	var lastmsgid int64
+	err := db.Get(&lastmsgid, `SELECT value_int FROM parameters WHERE name='lastmsgid'`)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if maxID.Int64 > lastmsgid {
+		return fmt.Errorf("%s has messages up to ID %d, but only messages up to ID %d have been decoded (use -force to archive anyway)",
+			p.Name, maxID.Int64, lastmsgid)
+	}
+
+	var counted int64
+	err = db.Get(&counted, `SELECT raw_message_id FROM coverage_progress`)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if maxID.Int64 > counted {
+		log.Warn().Msgf("%s has messages up to ID %d that aren't in the coverage message counts yet (counted up to ID %d)",
+			p.Name, maxID.Int64, counted)
+	}
+	return nil
+}
+
+func archivePartition(db *sqlx.DB, p archive.Partition) error {
+	filename := filepath.Join(*archiveDir, p.Name+".parquet")
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("archive file %s already exists", filename)
+	}
+
+	count, err := exportPartition(db, p, filename)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Exported %d messages from %s to %s", count, p.Name, filename)
+
+	// Only remove the data once the archive is safely on disk.
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`ALTER TABLE raw_message DETACH PARTITION %s`, p.Name)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if *noDrop {
+		// Renamed, so that the month's partition can be created again to
+		// import the archive into
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, p.Name, archivedName(p)))
+	} else {
+		_, err = tx.Exec(fmt.Sprintf(`DROP TABLE %s`, p.Name))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if *noDrop {
+		log.Info().Msgf("Detached %s as %s", p.Name, archivedName(p))
+	} else {
+		log.Info().Msgf("Detached and dropped %s", p.Name)
+	}
+	return nil
+}
+
+// archivedName is the name a partition kept with -nodrop is given once it is
+// detached.
+func archivedName(p archive.Partition) string {
+	return p.Name + "_archived"
+}
+
+// exportPartition writes every row of the partition to a new archive file.
+// The file is written under a temporary name and renamed into place only
+// after it has been completely written and synced.
+func exportPartition(db *sqlx.DB, p archive.Partition, filename string) (int, error) {
+	tmpname := filename + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpname)
+	defer f.Close()
+
+	w, err := archive.NewWriter(f)
+	if err != nil {
+		return 0, err
+	}
+
+	// p.Name is validated by archive.ListPartitions, so it's safe to use
+	// directly in the query.
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT id, created_at, signal, timestamp, message FROM %s ORDER BY id`, p.Name))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var rec archive.Record
+		var signal sql.NullInt64
+		if err := rows.Scan(&rec.ID, &rec.Time, &signal, &rec.Timestamp, &rec.Message); err != nil {
+			return count, err
+		}
+		rec.Signal = uint8(signal.Int64)
+		if err := w.Write(rec); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	if err := w.Close(); err != nil {
+		return count, err
+	}
+	if err := f.Sync(); err != nil {
+		return count, err
+	}
+	if err := f.Close(); err != nil {
+		return count, err
+	}
+	return count, os.Rename(tmpname, filename)
+}
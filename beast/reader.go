@@ -7,6 +7,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+
+	"github.com/racingmars/flighttrack/metrics"
 )
 
 // Type describes the transponder message format.
@@ -23,6 +25,18 @@ const (
 	ModeSlong Type = 3
 )
 
+var (
+	framesRead = metrics.NewCounterVec("flighttrack_beast_frames_total",
+		"Beast frames read, by message type.", "type")
+	modeACFrames     = framesRead.With("mode_ac")
+	modeSShortFrames = framesRead.With("mode_s_short")
+	modeSLongFrames  = framesRead.With("mode_s_long")
+	unknownFrames    = metrics.NewCounter("flighttrack_beast_unknown_frames_total",
+		"Beast frames of an unknown type, which were skipped.")
+	bytesRead = metrics.NewCounter("flighttrack_beast_bytes_total",
+		"Bytes read from beast streams, including escapes.")
+)
+
 // Reader converts the binary messages in an io.Reader to Message structs.
 type Reader struct {
 	bufrdr *bufio.Reader
@@ -48,6 +62,7 @@ func New(rdr io.Reader) *Reader {
 // Read will return the next message from the beast stream.
 func (r *Reader) Read() (*Message, uint64, error) {
 	startoffset := r.offset
+	defer func() { bytesRead.Add(r.offset - startoffset) }()
 	// Advance until escape character
 	var charBuf byte
 	var err error
@@ -78,6 +93,7 @@ func (r *Reader) Read() (*Message, uint64, error) {
 		msg.Type = ModeSlong
 		data, err = r.getBytes(21)
 	default:
+		unknownFrames.Inc()
 		return nil, startoffset,
 			UnknownFormatError(fmt.Errorf("unexpected message type %s",
 				hex.EncodeToString([]byte{charBuf})))
@@ -86,6 +102,15 @@ func (r *Reader) Read() (*Message, uint64, error) {
 		return nil, startoffset, err
 	}
 
+	switch msg.Type {
+	case ModeAC:
+		modeACFrames.Inc()
+	case ModeSshort:
+		modeSShortFrames.Inc()
+	case ModeSlong:
+		modeSLongFrames.Inc()
+	}
+
 	msg.Timestamp = data[0:6]
 	msg.SignalLevel = data[6]
 	msg.Message = data[7:]
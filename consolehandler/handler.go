@@ -12,7 +12,11 @@ type ConsoleHandler struct {
 	callsigns map[string]string
 }
 
-func (h *ConsoleHandler) NewFlight(icaoID string, firstSeen time.Time) {
+func (h *ConsoleHandler) NewFlight(icaoID string, firstSeen time.Time, reason tracker.SplitReason) {
+	if reason != tracker.SplitNone {
+		fmt.Printf("%8s: New flight created (split: %s).\n", icaoID, reason)
+		return
+	}
 	fmt.Printf("%8s: New flight created.\n", icaoID)
 }
 
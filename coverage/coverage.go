@@ -0,0 +1,42 @@
+// Package coverage keeps the receiver coverage statistics shown on the web
+// site's stats page: the farthest position received in each bearing sector
+// and altitude band, and the messages, positions and aircraft received each
+// hour.
+//
+// The statistics are summaries of the tracklog, raw_message and flight
+// tables, which are too big to go through on each page view. Refresh brings
+// the coverage_range and coverage_hourly tables up to date, reading only the
+// rows added since the last refresh, and web calls it periodically.
+package coverage
+
+// Sectors is the number of bearing sectors around the receiver.
+const Sectors = 36
+
+// SectorWidth is the width of each sector in degrees.
+const SectorWidth = 360 / Sectors
+
+// Bands are the lower bounds, in feet, of the altitude bands. A position is
+// in the last band whose bound is at or below its altitude.
+var Bands = []int{0, 10000, 20000, 30000}
+
+// MaxRange is the farthest, in nautical miles, that a position is believed.
+// Anything farther is a bad decode or a bad CPR position.
+const MaxRange = 400
+
+// Sector returns the bearing sector, 0 to Sectors-1 clockwise from north,
+// that a bearing is in.
+func Sector(bearing float64) int {
+	return int(bearing/SectorWidth) % Sectors
+}
+
+// Band returns the altitude band, an index into Bands, of an altitude in
+// feet.
+func Band(altitude int) int {
+	band := 0
+	for i, bound := range Bands {
+		if altitude >= bound {
+			band = i
+		}
+	}
+	return band
+}
@@ -0,0 +1,43 @@
+package coverage
+
+import (
+	"testing"
+)
+
+func TestSector(t *testing.T) {
+	tests := []struct {
+		bearing float64
+		want    int
+	}{
+		{0, 0},
+		{9.99, 0},
+		{10, 1},
+		{185, 18},
+		{359.99, 35},
+	}
+	for _, tc := range tests {
+		if got := Sector(tc.bearing); got != tc.want {
+			t.Errorf("Sector(%v) = %d, want %d", tc.bearing, got, tc.want)
+		}
+	}
+}
+
+func TestBand(t *testing.T) {
+	tests := []struct {
+		altitude int
+		want     int
+	}{
+		{-500, 0},
+		{0, 0},
+		{9975, 0},
+		{10000, 1},
+		{25000, 2},
+		{30000, 3},
+		{45000, 3},
+	}
+	for _, tc := range tests {
+		if got := Band(tc.altitude); got != tc.want {
+			t.Errorf("Band(%d) = %d, want %d", tc.altitude, got, tc.want)
+		}
+	}
+}
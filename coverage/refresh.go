@@ -0,0 +1,242 @@
+package coverage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// positionBatch is the number of tracklog rows read in each transaction.
+const positionBatch = 50000
+
+// messageBatch is the range of raw_message IDs counted in each transaction.
+const messageBatch = 1000000
+
+// aircraftWindow is how far back the aircraft counts are recomputed on each
+// refresh. Flights still in progress only count in the hour they started, so
+// the hours they span are put right once they end.
+const aircraftWindow = 24 * time.Hour
+
+// position is the farthest position in a sector and band.
+type position struct {
+	rangeNM   float64
+	latitude  float64
+	longitude float64
+	altitude  int
+	flightID  int64
+	time      time.Time
+}
+
+// Refresh updates the coverage statistics with the rows added to tracklog,
+// raw_message and flight since the last refresh. Ranges are measured from the
+// receiver at lat, lon.
+//
+// Each step commits as it goes, along with the progress recorded in
+// coverage_progress, so a refresh that fails part way loses nothing, and two
+// refreshes running at once wait for each other rather than counting twice.
+func Refresh(db *sql.DB, lat, lon float64) error {
+	for {
+		n, err := refreshPositions(db, lat, lon)
+		if err != nil {
+			return fmt.Errorf("coverage: positions: %v", err)
+		}
+		if n < positionBatch {
+			break
+		}
+	}
+	for {
+		more, err := refreshMessages(db)
+		if err != nil {
+			return fmt.Errorf("coverage: messages: %v", err)
+		}
+		if !more {
+			break
+		}
+	}
+	if err := refreshAircraft(db); err != nil {
+		return fmt.Errorf("coverage: aircraft: %v", err)
+	}
+	return nil
+}
+
+// refreshPositions folds the next batch of tracklog rows into the range and
+// hourly position statistics, and returns the number of rows read.
+func refreshPositions(db *sql.DB, lat, lon float64) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRow(`SELECT tracklog_id FROM coverage_progress FOR UPDATE`).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`SELECT id, flight_id, time, latitude, longitude, altitude
+		FROM tracklog WHERE id > $1 ORDER BY id LIMIT $2`, last, positionBatch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	farthest := make(map[[2]int]position)
+	positions := make(map[time.Time]int64)
+	n := 0
+	for rows.Next() {
+		var id, flightID int64
+		var t time.Time
+		var plat, plon sql.NullFloat64
+		var alt sql.NullInt64
+		if err = rows.Scan(&id, &flightID, &t, &plat, &plon, &alt); err != nil {
+			return 0, err
+		}
+		n++
+		last = id
+		if !plat.Valid || !plon.Valid {
+			continue
+		}
+		positions[t.Truncate(time.Hour)]++
+		if !alt.Valid {
+			continue
+		}
+		r := geo.Distance(lat, lon, plat.Float64, plon.Float64)
+		if r > MaxRange {
+			continue
+		}
+		key := [2]int{Sector(geo.Bearing(lat, lon, plat.Float64, plon.Float64)), Band(int(alt.Int64))}
+		if p, ok := farthest[key]; !ok || r > p.rangeNM {
+			farthest[key] = position{r, plat.Float64, plon.Float64, int(alt.Int64), flightID, t}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if n == 0 {
+		return 0, nil
+	}
+
+	for key, p := range farthest {
+		_, err = tx.Exec(`INSERT INTO coverage_range
+				(sector, band, range_nm, latitude, longitude, altitude, flight_id, time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (sector, band) DO UPDATE SET
+				range_nm = EXCLUDED.range_nm, latitude = EXCLUDED.latitude,
+				longitude = EXCLUDED.longitude, altitude = EXCLUDED.altitude,
+				flight_id = EXCLUDED.flight_id, time = EXCLUDED.time
+			WHERE coverage_range.range_nm < EXCLUDED.range_nm`,
+			key[0], key[1], p.rangeNM, p.latitude, p.longitude, p.altitude, p.flightID, p.time)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(positions) > 0 {
+		var values []string
+		var args []interface{}
+		for hour, count := range positions {
+			values = append(values, fmt.Sprintf("($%d::timestamp, $%d::bigint)", len(args)+1, len(args)+2))
+			args = append(args, hour, count)
+		}
+		_, err = tx.Exec(`INSERT INTO coverage_hourly (hour, positions)
+			VALUES `+strings.Join(values, ", ")+`
+			ON CONFLICT (hour) DO UPDATE SET positions = coverage_hourly.positions + EXCLUDED.positions`,
+			args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE coverage_progress SET tracklog_id = $1,
+		updated_at = CURRENT_TIMESTAMP AT TIME ZONE 'UTC'`, last)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// refreshMessages counts the next range of raw_message rows into the hourly
+// statistics, and reports whether there are more to count.
+func refreshMessages(db *sql.DB) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRow(`SELECT raw_message_id FROM coverage_progress FOR UPDATE`).Scan(&last)
+	if err != nil {
+		return false, err
+	}
+	var newest sql.NullInt64
+	if err = tx.QueryRow(`SELECT max(id) FROM raw_message`).Scan(&newest); err != nil {
+		return false, err
+	}
+	if !newest.Valid || newest.Int64 <= last {
+		return false, nil
+	}
+	upto := newest.Int64
+	if upto-last > messageBatch {
+		upto = last + messageBatch
+	}
+
+	_, err = tx.Exec(`INSERT INTO coverage_hourly (hour, messages)
+		SELECT date_trunc('hour', created_at), count(*) FROM raw_message
+		WHERE id > $1 AND id <= $2
+		GROUP BY 1
+		ON CONFLICT (hour) DO UPDATE SET messages = coverage_hourly.messages + EXCLUDED.messages`,
+		last, upto)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE coverage_progress SET raw_message_id = $1,
+		updated_at = CURRENT_TIMESTAMP AT TIME ZONE 'UTC'`, upto)
+	if err != nil {
+		return false, err
+	}
+	return upto < newest.Int64, tx.Commit()
+}
+
+// refreshAircraft recounts the distinct aircraft seen in each hour since
+// shortly before the last refresh. The first refresh counts every hour.
+func refreshAircraft(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var last sql.NullTime
+	err = tx.QueryRow(`SELECT aircraft_hour FROM coverage_progress FOR UPDATE`).Scan(&last)
+	if err != nil {
+		return err
+	}
+	since := time.Time{}
+	if last.Valid {
+		since = last.Time.Add(-aircraftWindow)
+	}
+
+	_, err = tx.Exec(`INSERT INTO coverage_hourly (hour, aircraft)
+		SELECT h, count(DISTINCT f.icao)
+		FROM flight f,
+			generate_series(date_trunc('hour', f.first_seen),
+				date_trunc('hour', coalesce(f.last_seen, f.first_seen)), interval '1 hour') h
+		WHERE coalesce(f.last_seen, f.first_seen) >= $1 AND h >= date_trunc('hour', $1::timestamp)
+		GROUP BY h
+		ON CONFLICT (hour) DO UPDATE SET aircraft = EXCLUDED.aircraft`, since)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE coverage_progress
+		SET aircraft_hour = date_trunc('hour', CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+			updated_at = CURRENT_TIMESTAMP AT TIME ZONE 'UTC'`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"compress/bzip2"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// The OurAirports files, which aren't in the repository yet; see
+// data/ourairports/README.txt for where to get them.
+const (
+	airportsFile = "data/ourairports/airports.csv.bz2"
+	runwaysFile  = "data/ourairports/runways.csv.bz2"
+)
+
+// Airport types in the OurAirports data that aren't loaded.
+var skipAirportTypes = map[string]bool{
+	"closed":      true,
+	"balloonport": true,
+}
+
+// loadAirports replaces the airport and runway tables with the OurAirports
+// data, and marks every flight to have its departure and arrival looked for
+// again against the new airports. If the files are missing, the tables are
+// left alone with a warning.
+func loadAirports(db *sqlx.DB) error {
+	for _, filename := range []string{airportsFile, runwaysFile} {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			log.Warn().Msgf("%s not found, so airports are not loaded and web can't infer flights' departures and arrivals; see data/ourairports/README.txt", filename)
+			return nil
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't open transaction")
+		return err
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("TRUNCATE TABLE airport, runway")
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't truncate airport and runway tables")
+		return err
+	}
+
+	idents, err := loadAirportData(txn)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error loading airport data")
+		return err
+	}
+
+	if err = loadRunwayData(txn, idents); err != nil {
+		log.Error().Err(err).Msgf("Error loading runway data")
+		return err
+	}
+
+	result, err := txn.Exec("UPDATE flight SET airports_checked = false WHERE airports_checked")
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't reset flight airports")
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Info().Msgf("%d flights will have their airports looked for again", n)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error committing transaction")
+		return err
+	}
+	return nil
+}
+
+// openOurAirports opens one of the bzip2-compressed OurAirports CSV files
+// and reads its header, returning the reader and the index of each column.
+func openOurAirports(filename string) (*os.File, *csv.Reader, map[string]int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rdr := csv.NewReader(bzip2.NewReader(f))
+	header, err := rdr.Read()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("reading header of %s: %v", filename, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	return f, rdr, columns, nil
+}
+
+// loadAirportData copies the airports into the airport table and returns
+// the idents of the airports loaded.
+func loadAirportData(txn *sql.Tx) (map[string]bool, error) {
+	f, rdr, columns, err := openOurAirports(airportsFile)
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't open airports file")
+		return nil, err
+	}
+	defer f.Close()
+
+	for _, name := range []string{"ident", "type", "name", "latitude_deg", "longitude_deg", "elevation_ft",
+		"iso_country", "iso_region", "municipality", "iata_code"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("airports file has no %s column", name)
+		}
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn("airport", "ident", "type", "name", "latitude", "longitude", "elevation",
+		"country", "region", "municipality", "iata"))
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't prepare airport insert statement")
+		return nil, err
+	}
+	defer stmt.Close()
+
+	idents := make(map[string]bool)
+	rowCount := 0
+
+	for {
+		row, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Error reading airports file")
+			return nil, err
+		}
+
+		rowCount++
+
+		ident := row[columns["ident"]]
+		airportType := row[columns["type"]]
+		if skipAirportTypes[airportType] || ident == "" || idents[ident] {
+			continue
+		}
+
+		lat, laterr := strconv.ParseFloat(row[columns["latitude_deg"]], 64)
+		lon, lonerr := strconv.ParseFloat(row[columns["longitude_deg"]], 64)
+		if laterr != nil || lonerr != nil {
+			log.Warn().Msgf("Skipping airport `%s` with no position", ident)
+			continue
+		}
+
+		var elevation *int
+		if e, err := strconv.Atoi(row[columns["elevation_ft"]]); err == nil {
+			elevation = &e
+		}
+
+		_, err = stmt.Exec(ident, airportType, row[columns["name"]], lat, lon, elevation,
+			nullIfEmpty(row[columns["iso_country"]]), nullIfEmpty(row[columns["iso_region"]]),
+			nullIfEmpty(row[columns["municipality"]]), nullIfEmpty(row[columns["iata_code"]]))
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't insert %s into airport", ident)
+			return nil, err
+		}
+		idents[ident] = true
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error flushing statement")
+		return nil, err
+	}
+
+	log.Info().Msgf("Inserted %d (of %d) airports", len(idents), rowCount)
+
+	return idents, nil
+}
+
+// runwayEnd is one end of a runway as read from the runways file.
+type runwayEnd struct {
+	ident               string
+	latitude            *float64
+	longitude           *float64
+	heading             *float64
+	located, hasHeading bool
+}
+
+func readRunwayEnd(row []string, columns map[string]int, prefix string) runwayEnd {
+	e := runwayEnd{ident: row[columns[prefix+"_ident"]]}
+	lat, laterr := strconv.ParseFloat(row[columns[prefix+"_latitude_deg"]], 64)
+	lon, lonerr := strconv.ParseFloat(row[columns[prefix+"_longitude_deg"]], 64)
+	if laterr == nil && lonerr == nil {
+		e.latitude, e.longitude, e.located = &lat, &lon, true
+	}
+	if h, err := strconv.ParseFloat(row[columns[prefix+"_heading_degT"]], 64); err == nil {
+		e.heading, e.hasHeading = &h, true
+	}
+	return e
+}
+
+// fillHeadings works out the heading of a runway end that isn't in the data
+// from the other end's heading, or from the bearing between the thresholds.
+func fillHeadings(le, he *runwayEnd) {
+	reciprocal := func(h float64) *float64 {
+		r := math.Mod(h+180, 360)
+		return &r
+	}
+	switch {
+	case le.hasHeading && he.hasHeading:
+	case le.hasHeading:
+		he.heading, he.hasHeading = reciprocal(*le.heading), true
+	case he.hasHeading:
+		le.heading, le.hasHeading = reciprocal(*he.heading), true
+	case le.located && he.located:
+		h := geo.Bearing(*le.latitude, *le.longitude, *he.latitude, *he.longitude)
+		le.heading, le.hasHeading = &h, true
+		he.heading, he.hasHeading = reciprocal(h), true
+	}
+}
+
+// loadRunwayData copies the ends of the open runways at the loaded airports
+// into the runway table.
+func loadRunwayData(txn *sql.Tx, airports map[string]bool) error {
+	f, rdr, columns, err := openOurAirports(runwaysFile)
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't open runways file")
+		return err
+	}
+	defer f.Close()
+
+	for _, name := range []string{"airport_ident", "length_ft", "surface", "closed",
+		"le_ident", "le_latitude_deg", "le_longitude_deg", "le_heading_degT",
+		"he_ident", "he_latitude_deg", "he_longitude_deg", "he_heading_degT"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("runways file has no %s column", name)
+		}
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn("runway", "airport", "ident", "latitude", "longitude", "heading", "length", "surface"))
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't prepare runway insert statement")
+		return err
+	}
+	defer stmt.Close()
+
+	seen := make(map[[2]string]bool)
+	rowCount := 0
+	insertCount := 0
+
+	for {
+		row, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Error reading runways file")
+			return err
+		}
+
+		rowCount++
+
+		airport := row[columns["airport_ident"]]
+		if !airports[airport] || row[columns["closed"]] == "1" {
+			continue
+		}
+
+		var length *int
+		if l, err := strconv.Atoi(row[columns["length_ft"]]); err == nil {
+			length = &l
+		}
+		surface := nullIfEmpty(row[columns["surface"]])
+
+		le := readRunwayEnd(row, columns, "le")
+		he := readRunwayEnd(row, columns, "he")
+		fillHeadings(&le, &he)
+
+		for _, e := range []runwayEnd{le, he} {
+			key := [2]string{airport, e.ident}
+			if e.ident == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			_, err = stmt.Exec(airport, e.ident, e.latitude, e.longitude, e.heading, length, surface)
+			if err != nil {
+				log.Error().Err(err).Msgf("Couldn't insert %s %s into runway", airport, e.ident)
+				return err
+			}
+			insertCount++
+		}
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error flushing statement")
+		return err
+	}
+
+	log.Info().Msgf("Inserted %d runway ends (from %d runways)", insertCount, rowCount)
+
+	return nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
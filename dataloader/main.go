@@ -17,6 +17,7 @@ var skipCanada = flag.Bool("skipcanada", false, "Skip loading Canada data")
 var skipADB = flag.Bool("skipadb", false, "Skip loading aircraft_db data")
 var skipFA = flag.Bool("skipfa", false, "Skip loading Flightaware data")
 var skipAirlines = flag.Bool("skipairlines", false, "Skip loading airlines data")
+var skipAirports = flag.Bool("skipairports", false, "Skip loading airports and runways data")
 var noTruncate = flag.Bool("notruncate", false, "Do not truncate registration table before loading")
 
 func main() {
@@ -72,6 +73,13 @@ func main() {
 		}
 	}
 
+	if !*skipAirports {
+		err = loadAirports(db)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error loading airports data")
+		}
+	}
+
 }
 
 func getConnection() (*sqlx.DB, error) {
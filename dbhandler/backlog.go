@@ -0,0 +1,58 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/tracker"
+)
+
+// RawMessage is a row from the raw_message table.
+type RawMessage struct {
+	ID      int64         `db:"id"`
+	Message []byte        `db:"message"`
+	Time    time.Time     `db:"created_at"`
+	Signal  sql.NullInt64 `db:"signal"`
+}
+
+// ProcessBacklog gives every raw message after lastID to the tracker, in
+// order, and returns the ID of the last one and how many there were.
+func ProcessBacklog(db *sqlx.DB, track *tracker.Tracker, lastID int64) (int64, int, error) {
+	return ProcessBacklogContext(context.Background(), db, track, lastID)
+}
+
+// ProcessBacklogContext is ProcessBacklog, stopping early if ctx is done. The
+// returned ID is then the last message the tracker was given, so processing
+// can carry on from there.
+func ProcessBacklogContext(ctx context.Context, db *sqlx.DB, track *tracker.Tracker, lastID int64) (int64, int, error) {
+	rows, err := db.QueryxContext(ctx, "SELECT id, message, created_at, signal FROM raw_message WHERE id>$1 ORDER BY id", lastID)
+	if err != nil {
+		return lastID, 0, err
+	}
+	defer rows.Close()
+
+	msg := RawMessage{}
+	var total, batch int
+	for rows.Next() {
+		total++
+		batch++
+		if err = rows.StructScan(&msg); err != nil {
+			return lastID, total, err
+		}
+		icao, decoded := decoder.DecodeMessage(msg.Message, msg.Time)
+		if icao != "" && icao != "000000" {
+			track.MessageWithSignal(icao, msg.Time, uint8(msg.Signal.Int64), decoded)
+		}
+		if batch == 100000 {
+			log.Info().Msgf("processed %d messages", total)
+			batch = 0
+		}
+		lastID = msg.ID
+	}
+	return lastID, total, rows.Err()
+}
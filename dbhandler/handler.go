@@ -1,4 +1,8 @@
-package main
+// Package dbhandler stores the flights and track logs found by the tracker
+// in the database, along with the tracker state needed to carry on decoding
+// raw messages where a previous run stopped. It is used by dbloader, and by
+// web in realtime mode.
+package dbhandler
 
 import (
 	"encoding/json"
@@ -11,7 +15,9 @@ import (
 	"github.com/racingmars/flighttrack/tracker"
 )
 
-type handler struct {
+// Handler is a tracker.FlightHandler that writes to the flight and tracklog
+// tables. Writes are batched in transactions; call Flush to commit them.
+type Handler struct {
 	db         *sqlx.DB
 	idmap      map[string]int
 	currentTxn *sqlx.Tx
@@ -19,19 +25,22 @@ type handler struct {
 	batchCount int
 }
 
-func newHandler(db *sqlx.DB) *handler {
+// New returns a Handler with no flights in progress.
+func New(db *sqlx.DB) *Handler {
 	tx, err := db.Beginx()
 	if err != nil {
 		log.Panic().Err(err).Msgf("couldn't make new transaction in newHandler()")
 	}
-	return &handler{
+	return &Handler{
 		db:         db,
 		idmap:      make(map[string]int),
 		currentTxn: tx,
 	}
 }
 
-func newHandlerWithState(db *sqlx.DB, handlerstate []byte) (*handler, error) {
+// NewWithState returns a Handler that carries on from state returned by
+// GetState.
+func NewWithState(db *sqlx.DB, handlerstate []byte) (*Handler, error) {
 	tx, err := db.Beginx()
 	if err != nil {
 		return nil, err
@@ -41,14 +50,15 @@ func newHandlerWithState(db *sqlx.DB, handlerstate []byte) (*handler, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &handler{
+	return &Handler{
 		db:         db,
 		idmap:      idmap,
 		currentTxn: tx,
 	}, nil
 }
 
-func (h *handler) Close() {
+// Close commits any outstanding writes.
+func (h *Handler) Close() {
 	if h.logstmt != nil {
 		h.logstmt.Close()
 	}
@@ -60,8 +70,14 @@ func (h *handler) Close() {
 	}
 }
 
-func (h *handler) NewFlight(icaoID string, firstSeen time.Time) {
-	row := h.db.QueryRow("INSERT INTO flight (icao, first_seen) VALUES ($1, $2) RETURNING id", icaoID, firstSeen.UTC())
+func (h *Handler) NewFlight(icaoID string, firstSeen time.Time, reason tracker.SplitReason) {
+	var splitReason *string
+	if reason != tracker.SplitNone {
+		r := string(reason)
+		splitReason = &r
+	}
+	row := h.db.QueryRow("INSERT INTO flight (icao, first_seen, split_reason) VALUES ($1, $2, $3) RETURNING id",
+		icaoID, firstSeen.UTC(), splitReason)
 	var id int
 	err := row.Scan(&id)
 	if err != nil {
@@ -72,7 +88,7 @@ func (h *handler) NewFlight(icaoID string, firstSeen time.Time) {
 	h.batchCount++
 }
 
-func (h *handler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {
+func (h *Handler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {
 	id, ok := h.idmap[icaoID]
 	if !ok {
 		log.Error().Msgf("couldn't find id for flight %s", icaoID)
@@ -88,7 +104,7 @@ func (h *handler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {
 	h.batchCount++
 }
 
-func (h *handler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {
+func (h *Handler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {
 	var err error
 	id, ok := h.idmap[icaoID]
 	if !ok {
@@ -109,10 +125,10 @@ func (h *handler) SetIdentity(icaoID, callsign string, category decoder.Aircraft
 	h.batchCount++
 }
 
-func (h *handler) AddTrackPoint(icaoID string, t tracker.TrackLog) {
+func (h *Handler) AddTrackPoint(icaoID string, t tracker.TrackLog) {
 	if h.logstmt == nil {
-		stmt, err := h.currentTxn.Preparex(`INSERT INTO tracklog (flight_id, time, latitude, longitude, heading, speed, altitude, vs, callsign, category)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`)
+		stmt, err := h.currentTxn.Preparex(`INSERT INTO tracklog (flight_id, time, latitude, longitude, heading, speed, altitude, vs, callsign, category, phase)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`)
 		if err != nil {
 			log.Error().Err(err).Msgf("preparing tracklog statement")
 			return
@@ -130,6 +146,7 @@ func (h *handler) AddTrackPoint(icaoID string, t tracker.TrackLog) {
 	var latitude, longitude *float64
 	var callsign *string
 	var category *decoder.AircraftType
+	var phase *string
 
 	if t.HeadingValid {
 		heading = &t.Heading
@@ -151,8 +168,12 @@ func (h *handler) AddTrackPoint(icaoID string, t tracker.TrackLog) {
 		callsign = &t.Callsign
 		category = &t.Category
 	}
+	if t.Phase != tracker.PhaseUnknown {
+		p := string(t.Phase)
+		phase = &p
+	}
 
-	_, err := h.logstmt.Exec(id, t.Time.UTC(), latitude, longitude, heading, speed, altitude, vs, callsign, category)
+	_, err := h.logstmt.Exec(id, t.Time.UTC(), latitude, longitude, heading, speed, altitude, vs, callsign, category, phase)
 
 	if err != nil {
 		log.Error().Err(err).Msgf("adding track log for flight %s (%d)", icaoID, id)
@@ -164,7 +185,8 @@ func (h *handler) AddTrackPoint(icaoID string, t tracker.TrackLog) {
 	}
 }
 
-func (h *handler) Flush() {
+// Flush commits the writes made so far and starts a new transaction.
+func (h *Handler) Flush() {
 	//log.Debug().Msg("committing transaction")
 	if h.logstmt != nil {
 		h.logstmt.Close()
@@ -182,7 +204,9 @@ func (h *handler) Flush() {
 	h.batchCount = 0
 }
 
-func (h *handler) GetState() []byte {
+// GetState returns the handler's state, which maps aircraft to the IDs of
+// their flights in progress.
+func (h *Handler) GetState() []byte {
 	data, err := json.Marshal(h.idmap)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to marshal handler state")
@@ -191,7 +215,10 @@ func (h *handler) GetState() []byte {
 	return data
 }
 
-func (h *handler) saveState(trackerstate []byte, lastRawMessageID int64) {
+// SaveState saves the tracker and handler state along with the ID of the
+// last raw message given to the tracker, for LoadState to pick up. Writes
+// should be flushed first so the state matches what is in the database.
+func (h *Handler) SaveState(trackerstate []byte, format tracker.StateFormat, lastRawMessageID int64) {
 	handlerstate := h.GetState()
 
 	if !(trackerstate != nil && handlerstate != nil && lastRawMessageID > 0) {
@@ -205,11 +232,18 @@ func (h *handler) saveState(trackerstate []byte, lastRawMessageID int64) {
 		return
 	}
 
+	// Only one of the value columns is set, depending on the format
+	var txt, bin interface{}
+	if format == tracker.StateBinary {
+		bin = trackerstate
+	} else {
+		txt = string(trackerstate)
+	}
 	_, err = txn.Exec(
-		`INSERT INTO parameters (name, value_txt) VALUES ('trackerstate', $1)
+		`INSERT INTO parameters (name, value_txt, value_bin) VALUES ('trackerstate', $1, $2)
 		 ON CONFLICT (name)
-		 DO UPDATE SET value_txt = EXCLUDED.value_txt`,
-		string(trackerstate))
+		 DO UPDATE SET value_txt = EXCLUDED.value_txt, value_bin = EXCLUDED.value_bin`,
+		txt, bin)
 	if err != nil {
 		log.Error().Err(err).Msg("Couldn't insert tracker state")
 		txn.Rollback()
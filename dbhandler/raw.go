@@ -0,0 +1,16 @@
+package dbhandler
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/racingmars/flighttrack/beast"
+)
+
+// SaveRawMessage inserts a message from the beast feed into raw_message and
+// returns its ID and the receive time recorded for it.
+func SaveRawMessage(db *sql.DB, msg *beast.Message) (id int64, created time.Time, err error) {
+	err = db.QueryRow("INSERT INTO raw_message (message, timestamp, signal) VALUES ($1, $2, $3) RETURNING id, created_at",
+		msg.Message, msg.Timestamp, uint(msg.SignalLevel)).Scan(&id, &created)
+	return id, created, err
+}
@@ -0,0 +1,99 @@
+package dbhandler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// decoderLockID is the Postgres advisory lock held by whichever process is
+// decoding raw messages into flights, so dbloader and web in realtime mode
+// can't both do it at once.
+const decoderLockID = 0x666c7472 // "fltr"
+
+// ErrLocked is returned by Lock if another process is already decoding.
+var ErrLocked = errors.New("another process (dbloader or web -realtime) is already decoding raw messages")
+
+// Lock takes the decoder lock, which is held until the returned connection
+// is closed.
+func Lock(db *sqlx.DB) (*sql.Conn, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	err = conn.QueryRowContext(context.Background(), `SELECT pg_try_advisory_lock($1)`, decoderLockID).Scan(&ok)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, ErrLocked
+	}
+	return conn, nil
+}
+
+// LoadState returns the state saved by SaveState. err is sql.ErrNoRows if
+// there is no saved state.
+func LoadState(db *sqlx.DB) (trackerstate, handlerstate []byte, lastmsgid int64, err error) {
+	err = db.Get(&lastmsgid, `SELECT value_int FROM parameters WHERE name='lastmsgid'`)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	err = db.Get(&trackerstate, `SELECT COALESCE(value_bin, convert_to(value_txt, 'UTF8'))
+		FROM parameters WHERE name='trackerstate'`)
+	if err != nil {
+		return nil, nil, lastmsgid, err
+	}
+
+	err = db.Get(&handlerstate, `SELECT value_txt FROM parameters WHERE name='handlerstate'`)
+	if err != nil {
+		return nil, nil, lastmsgid, err
+	}
+
+	return trackerstate, handlerstate, lastmsgid, nil
+}
+
+// Reset deletes all flights and track logs and the saved state, so every raw
+// message will be decoded again. The zone events go with the flights, and
+// the zones and the coverage summaries built from the track log start again
+// with the new one; message counts, which come from the raw messages, stay.
+// It is all one transaction, so a failure part way leaves everything as it
+// was.
+func Reset(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the zones first, in the same order as the zone refresh does, so a
+	// refresh running in web can't deadlock with us or write zone events for
+	// a track log that is about to go
+	if _, err = tx.Exec(`SELECT id FROM zone ORDER BY id FOR UPDATE`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`TRUNCATE TABLE flight, tracklog, zone_event RESTART IDENTITY`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE zone SET tracklog_id = 0`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`TRUNCATE TABLE coverage_range`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE coverage_hourly SET positions = 0, aircraft = 0`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`UPDATE coverage_progress SET tracklog_id = 0, aircraft_hour = NULL`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM parameters WHERE name IN ('trackerstate', 'handlerstate', 'lastmsgid')`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
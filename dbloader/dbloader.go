@@ -11,7 +11,9 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/dbhandler"
+	"github.com/racingmars/flighttrack/metrics"
+	"github.com/racingmars/flighttrack/migrate"
 	"github.com/racingmars/flighttrack/tracker"
 
 	"github.com/jmoiron/sqlx"
@@ -26,9 +28,24 @@ var loglevel = flag.String("level", "info", "Log level: debug, info, warn, error
 var reset = flag.Bool("reset", false, "Reset the flights and track log databases, re-process all raw messages")
 var resetonly = flag.Bool("resetonly", false, "Reset the flights and track log databases and quit")
 var pretty = flag.Bool("pretty", false, "Use pretty log printing")
+var migrateSchema = flag.Bool("migrate", false, "Apply pending database schema migrations before starting")
+var stateformat = flag.String("stateformat", "json", "Save tracker state as `json` or binary")
+var importfile = flag.String("import", "", "Restore raw messages from archive `file` into the database before processing")
+var metricsAddr = flag.String("metrics", ":1326", "Serve Prometheus metrics on /metrics at `address`, or nowhere if empty")
+
+var trackerFlags = tracker.RegisterFlags(flag.CommandLine)
 
 var timeToQuit = false
 
+var (
+	messagesProcessed = metrics.NewCounter("flighttrack_dbloader_messages_processed_total",
+		"Raw messages read from the database and given to the tracker.")
+	lastMessageID = metrics.NewGauge("flighttrack_dbloader_last_message_id",
+		"ID of the last raw message processed.")
+	idlePolls = metrics.NewCounter("flighttrack_dbloader_idle_polls_total",
+		"Times the loader caught up with the raw messages and waited for more.")
+)
+
 func main() {
 	flag.Parse()
 
@@ -50,6 +67,22 @@ func main() {
 		log.Warn().Msgf("Unknown log level `%s`, setting to WARN", *loglevel)
 	}
 
+	trackerConfig, err := trackerFlags.Config()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't load tracker configuration")
+	}
+	stateFormat, err := tracker.ParseStateFormat(*stateformat)
+	if err != nil {
+		log.Fatal().Err(err).Msg("bad -stateformat")
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			err := metrics.ListenAndServe(*metricsAddr)
+			log.Error().Err(err).Msg("couldn't serve metrics")
+		}()
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -68,13 +101,36 @@ func main() {
 	}
 	defer db.Close()
 
+	if *migrateSchema {
+		if err := migrate.Up(db.DB); err != nil {
+			log.Fatal().Err(err).Msg("couldn't migrate database schema")
+		}
+	}
+	if err := migrate.Check(db.DB); err != nil {
+		log.Fatal().Err(err).Msg("database schema is not current")
+	}
+
+	lock, err := dbhandler.Lock(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't take the decoder lock")
+	}
+	defer lock.Close()
+
 	if *resetonly {
 		*reset = true
 	}
 
+	if *importfile != "" {
+		err := importArchive(db, *importfile)
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't import archive %s", *importfile)
+			return
+		}
+	}
+
 	if *reset {
 		log.Warn().Msg("Resetting database due to command line flag")
-		err := resetDatabase(db)
+		err := dbhandler.Reset(db)
 		if err != nil {
 			log.Error().Err(err).Msg("Couldn't reset database")
 			return
@@ -85,7 +141,7 @@ func main() {
 		return
 	}
 
-	trackerstate, handlerstate, lastmsgid, err := loadState(db)
+	trackerstate, handlerstate, lastmsgid, err := dbhandler.LoadState(db)
 	if lastmsgid > 0 && err == sql.ErrNoRows {
 		log.Error().Msg("Last message ID > 0, but unable to load all state rows")
 		return
@@ -96,7 +152,7 @@ func main() {
 		return
 	}
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -104,7 +160,7 @@ func main() {
 		timeToQuit = true
 	}()
 
-	loadRows(db, trackerstate, handlerstate, lastmsgid)
+	loadRows(db, trackerConfig, stateFormat, trackerstate, handlerstate, lastmsgid)
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -128,111 +184,55 @@ func getConnection() (*sqlx.DB, error) {
 	return db, err
 }
 
-type Message struct {
-	ID      int64     `db:"id"`
-	Message []byte    `db:"message"`
-	Time    time.Time `db:"created_at"`
-}
-
-func loadRows(db *sqlx.DB, trackerstate, handlerstate []byte, lastRawMessageID int64) {
-	var handler *handler
+func loadRows(db *sqlx.DB, config tracker.Config, stateFormat tracker.StateFormat, trackerstate, handlerstate []byte, lastRawMessageID int64) {
+	var handler *dbhandler.Handler
 	var track *tracker.Tracker
 	var err error
 
 	if lastRawMessageID > 0 {
 		// We should have valid state information from the last run
-		if handler, err = newHandlerWithState(db, handlerstate); err != nil {
+		if handler, err = dbhandler.NewWithState(db, handlerstate); err != nil {
 			log.Error().Err(err).Msg("Couldn't load handler with state")
 			return
 		}
-		if track, err = tracker.NewWithState(handler, false, trackerstate); err != nil {
+		if track, err = tracker.NewWithState(handler, config, trackerstate); err != nil {
 			log.Error().Err(err).Msg("Couldn't load tracker with state")
 			return
 		}
 	} else {
-		handler = newHandler(db)
-		track = tracker.New(handler, false)
+		handler = dbhandler.New(db)
+		track = tracker.New(handler, config)
 	}
 
 	defer handler.Close()
 
-	var rows *sqlx.Rows
-
 	for {
-		rows, err = db.Queryx("SELECT id, message, created_at FROM raw_message WHERE id>$1 ORDER BY id", lastRawMessageID)
+		var total int
+		lastRawMessageID, total, err = dbhandler.ProcessBacklog(db, track, lastRawMessageID)
 		if err != nil {
-			log.Error().Err(err).Msg("couldn't query raw messages")
+			log.Error().Err(err).Msg("couldn't process raw messages")
 			return
 		}
-
-		msg := Message{}
-		var total, batch int
-		hadResult := false
-
-		for rows.Next() {
-			hadResult = true
-			total++
-			batch++
-			err = rows.StructScan(&msg)
-			if err != nil {
-				log.Error().Err(err).Msg("couldn't scan raw messages")
-				return
-			}
-			icao, decoded := decoder.DecodeMessage(msg.Message, msg.Time)
-			if icao != "" && icao != "000000" {
-				track.Message(icao, msg.Time, decoded)
-			}
-			if batch == 100000 {
-				log.Info().Msgf("processed %d messages", total)
-				batch = 0
-			}
-			lastRawMessageID = msg.ID
-		}
+		messagesProcessed.Add(uint64(total))
+		lastMessageID.Set(float64(lastRawMessageID))
 
 		if total > 0 {
 			log.Info().Msgf("done: processed %d messages, last msgID %d", total, lastRawMessageID)
+			continue
 		}
-		if !hadResult {
-			handler.Flush()
-			trackerstate := track.GetState()
-			handler.saveState(trackerstate, lastRawMessageID)
-			if timeToQuit {
-				break
-			}
-			// if we exhausted the backlog, wait a bit for new messages.
-			time.Sleep(5 * time.Second)
-		}
-	}
-}
-
-func resetDatabase(db *sqlx.DB) error {
-	_, err := db.Exec(`TRUNCATE TABLE flight, tracklog RESTART IDENTITY`)
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`DELETE FROM parameters WHERE name IN ('trackerstate', 'handlerstate', 'lastmsgid')`)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func loadState(db *sqlx.DB) (trackerstate, handlerstate []byte, lastmsgid int64, err error) {
-	err = db.Get(&lastmsgid, `SELECT value_int FROM parameters WHERE name='lastmsgid'`)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-
-	err = db.Get(&trackerstate, `SELECT value_txt FROM parameters WHERE name='trackerstate'`)
-	if err != nil {
-		return nil, nil, lastmsgid, err
-	}
 
-	err = db.Get(&handlerstate, `SELECT value_txt FROM parameters WHERE name='handlerstate'`)
-	if err != nil {
-		return nil, nil, lastmsgid, err
+		handler.Flush()
+		trackerstate, err := track.EncodeState(stateFormat)
+		if err != nil {
+			log.Error().Err(err).Msg("Couldn't encode tracker state")
+		} else {
+			handler.SaveState(trackerstate, stateFormat, lastRawMessageID)
+		}
+		if timeToQuit {
+			break
+		}
+		// if we exhausted the backlog, wait a bit for new messages.
+		idlePolls.Inc()
+		time.Sleep(5 * time.Second)
 	}
-
-	return trackerstate, handlerstate, lastmsgid, nil
 }
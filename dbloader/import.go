@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/archive"
+)
+
+// importArchive restores the messages in an archive file (as written by the
+// archiver utility) into the raw_message table. Restored messages keep their
+// original IDs, so they will only be decoded if the flight and track log
+// tables are reset with -reset.
+func importArchive(db *sqlx.DB, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	rdr, err := archive.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if rdr.Len() == 0 {
+		return fmt.Errorf("%s: archive has no messages", filename)
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Partitions must exist before COPY starts, so read the first record to
+	// find out which month we're restoring. Archives hold a single month.
+	rec, err := rdr.Read()
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if err = archive.EnsurePartition(txn, rec.Time); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn("raw_message", "id", "created_at", "signal", "timestamp", "message"))
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	var minID, maxID int64 = rec.ID, rec.ID
+	count := 0
+	for rec != nil {
+		var signal interface{}
+		if rec.Signal != 0 {
+			signal = int(rec.Signal)
+		}
+		_, err = stmt.Exec(rec.ID, rec.Time, signal, rec.Timestamp, rec.Message)
+		if err != nil {
+			stmt.Close()
+			txn.Rollback()
+			return err
+		}
+		count++
+		if rec.ID < minID {
+			minID = rec.ID
+		}
+		if rec.ID > maxID {
+			maxID = rec.ID
+		}
+		if count%1000000 == 0 {
+			log.Info().Msgf("restored %d messages", count)
+		}
+
+		rec, err = rdr.Read()
+		if err != nil && err != io.EOF {
+			stmt.Close()
+			txn.Rollback()
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		txn.Rollback()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if err = txn.Commit(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Restored %d messages (IDs %d to %d) from %s", count, minID, maxID, filename)
+	if !*reset {
+		log.Warn().Msg("Restored messages are only decoded after a -reset")
+	}
+	return nil
+}
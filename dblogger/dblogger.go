@@ -2,7 +2,7 @@ package main
 
 // This is a temporary stand-alone utility to log the raw transponder packets
 // to a database before the rest of the application is ready to log more
-// interesting information.
+// interesting information. `web -realtime` does the same thing in-process.
 
 // Run with the connection string to Postgres in env variable "DBURL"
 // And the dump1090 beast host:port in "DUMP1090HOST"
@@ -10,20 +10,46 @@ package main
 // $ DBURL="user=flights dbname=flights sslmode=disable" \
 //   DUMP1090HOST="piaware:30005" \
 //   ./dblogger
+//
+// Prometheus metrics are served on /metrics at the -metrics address.
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/racingmars/flighttrack/archive"
 	"github.com/racingmars/flighttrack/beast"
+	"github.com/racingmars/flighttrack/dbhandler"
+	"github.com/racingmars/flighttrack/metrics"
+)
+
+var metricsAddr = flag.String("metrics", ":1325", "Serve Prometheus metrics on /metrics at `address`, or nowhere if empty")
+
+var (
+	messagesSaved = metrics.NewCounter("flighttrack_dblogger_messages_saved_total",
+		"Raw messages saved to the database.")
+	loggerErrors = metrics.NewCounterVec("flighttrack_dblogger_errors_total",
+		"Errors saving messages or creating partitions.", "op")
+	lastSaved = metrics.NewGauge("flighttrack_dblogger_last_saved_timestamp_seconds",
+		"When the most recent message was saved, since the Unix epoch.")
 )
 
 func main() {
+	flag.Parse()
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Print(metrics.ListenAndServe(*metricsAddr))
+		}()
+	}
+
 	db, err := getConnection()
 	if err != nil {
 		log.Fatal(err)
@@ -47,8 +73,28 @@ func main() {
 	}
 	defer feedconn.Close()
 
+	// raw_message is partitioned by month; make sure there's somewhere for
+	// this month's and next month's messages to go, and check again every
+	// time the month changes.
+	partitionMonth := archive.MonthStart(time.Now())
+	err = archive.EnsurePartitions(db, partitionMonth)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
 	rdr := beast.New(feedconn)
 	for {
+		if month := archive.MonthStart(time.Now()); !month.Equal(partitionMonth) {
+			err = archive.EnsurePartitions(db, month)
+			if err != nil {
+				log.Print(err)
+				loggerErrors.With("partition").Inc()
+			} else {
+				partitionMonth = month
+			}
+		}
+
 		msg, offset, err := rdr.Read()
 		if err == io.EOF {
 			break
@@ -61,10 +107,14 @@ func main() {
 			log.Print(offset, err)
 			return
 		}
-		err = saveMessage(db, msg)
+		_, created, err := dbhandler.SaveRawMessage(db, msg)
 		if err != nil {
 			log.Print(err)
+			loggerErrors.With("save").Inc()
+			continue
 		}
+		messagesSaved.Inc()
+		lastSaved.Set(float64(created.UnixNano()) / 1e9)
 	}
 }
 
@@ -79,9 +129,3 @@ func getConnection() (*sql.DB, error) {
 	}
 	return db, nil
 }
-
-func saveMessage(db *sql.DB, msg *beast.Message) error {
-	_, err := db.Exec("INSERT INTO raw_message (message, timestamp, signal) VALUES ($1, $2, $3)",
-		msg.Message, msg.Timestamp, uint(msg.SignalLevel))
-	return err
-}
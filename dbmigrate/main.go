@@ -0,0 +1,61 @@
+package main
+
+// dbmigrate brings the flighttrack database schema up to date. dbloader and
+// web refuse to start until it has been run against a new or older database.
+//
+// Run with the connection string to Postgres in env variable "DBURL", e.g.
+// $ DBURL="user=flights dbname=flights sslmode=disable" ./dbmigrate
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/migrate"
+)
+
+var statusOnly = flag.Bool("status", false, "Report the schema version and pending migrations without applying them")
+
+func main() {
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+
+	db, err := getConnection()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't connect to DB")
+	}
+	defer db.Close()
+
+	if *statusOnly {
+		current, latest, err := migrate.Status(db.DB)
+		if err != nil {
+			log.Fatal().Err(err).Msg("couldn't read schema version")
+		}
+		log.Info().Msgf("Database schema version %d, latest version %d", current, latest)
+		if err = migrate.Check(db.DB); err != nil {
+			log.Warn().Msg(err.Error())
+		}
+		return
+	}
+
+	if err = migrate.Up(db.DB); err != nil {
+		log.Fatal().Err(err).Msg("migration failed")
+	}
+	log.Info().Msg("Database schema is up to date")
+}
+
+func getConnection() (*sqlx.DB, error) {
+	connStr, ok := os.LookupEnv("DBURL")
+	if !ok {
+		return nil, fmt.Errorf("DBURL environment variable not set")
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	return db, err
+}
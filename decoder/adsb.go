@@ -217,6 +217,51 @@ func getAdsbPosition(msg []byte, tm time.Time) AdsbPosition {
 	return result
 }
 
+func getAdsbSurfacePosition(msg []byte, tm time.Time) AdsbSurfacePosition {
+	result := AdsbSurfacePosition{Timestamp: tm}
+	result.TC = int(msg[0] & 0xF8 >> 3)
+
+	movement := int(msg[0])&0x07<<4 | int(msg[1])&0xf0>>4
+	result.Speed, result.MovementValid = surfaceMovement(movement)
+
+	if msg[1]&0x08 > 0 {
+		result.TrackValid = true
+		trk := int(msg[1])&0x07<<4 | int(msg[2])&0xf0>>4
+		result.Track = int(math.Round(float64(trk) * 360.0 / 128.0))
+	}
+
+	result.Frame = int(msg[2]) & 0x04 >> 2
+	result.LatCPR = (int(msg[2]) & 0x03 << 15) | (int(msg[3]) << 7) | (int(msg[4]) & 0xfe >> 1)
+	result.LonCPR = (int(msg[4]) & 0x01 << 16) | (int(msg[5]) << 8) | (int(msg[6]))
+
+	return result
+}
+
+// surfaceMovement decodes the quantized groundspeed of a surface position
+// message. See https://mode-s.org/decode/adsb/surface-position.html
+func surfaceMovement(mov int) (float64, bool) {
+	switch {
+	case mov == 1:
+		return 0, true
+	case mov >= 2 && mov <= 8:
+		return 0.125 + float64(mov-2)*0.125, true
+	case mov >= 9 && mov <= 12:
+		return 1 + float64(mov-9)*0.25, true
+	case mov >= 13 && mov <= 38:
+		return 2 + float64(mov-13)*0.5, true
+	case mov >= 39 && mov <= 93:
+		return 15 + float64(mov-39), true
+	case mov >= 94 && mov <= 108:
+		return 70 + float64(mov-94)*2, true
+	case mov >= 109 && mov <= 123:
+		return 100 + float64(mov-109)*5, true
+	case mov == 124:
+		return 175, true
+	}
+	// 0 is "no information", 125-127 are reserved
+	return 0, false
+}
+
 const dLatEven float64 = 360.0 / 60.0
 const dLatOdd float64 = 360.0 / 59.0
 
@@ -3,13 +3,14 @@ package decoder
 import (
 	"encoding/hex"
 	"testing"
+	"time"
 )
 
 func TestIdentification(t *testing.T) {
 	msg, _ := hex.DecodeString("8D4840D6202CC371C32CE0576098")
 	result := getAdsbIdentification(msg[4:])
-	if result.Callsign != "KLM1023 " {
-		t.Errorf("Unexpected callsign: \"%s\" (should be \"KLM1023 \")", result.Callsign)
+	if result.Callsign != "KLM1023" {
+		t.Errorf("Unexpected callsign: \"%s\" (should be \"KLM1023\")", result.Callsign)
 	}
 }
 
@@ -40,7 +41,7 @@ func TestAirspeed(t *testing.T) {
 
 func TestAltitude(t *testing.T) {
 	msg, _ := hex.DecodeString("8D40621D58C382D690C8AC2863A7")
-	result := getAdsbPosition(msg[4:])
+	result := getAdsbPosition(msg[4:], time.Now())
 	if result.Altitude != 38000 {
 		t.Errorf("Bad altitude: %d should be 38000", result.Altitude)
 	}
@@ -48,7 +49,7 @@ func TestAltitude(t *testing.T) {
 
 func TestAltitudeQZero(t *testing.T) {
 	msg, _ := hex.DecodeString("59a6a5b819fde2e7cfb1")
-	result := getAdsbPosition(msg)
+	result := getAdsbPosition(msg, time.Now())
 	if result.Altitude != 6100 {
 		t.Errorf("Bad altitude: %d should be 6100", result.Altitude)
 	}
@@ -56,7 +57,7 @@ func TestAltitudeQZero(t *testing.T) {
 
 func TestPosition(t *testing.T) {
 	msg, _ := hex.DecodeString("8D40621D58C382D690C8AC2863A7")
-	resultEven := getAdsbPosition(msg[4:])
+	resultEven := getAdsbPosition(msg[4:], time.Now())
 	if resultEven.Frame != 0 {
 		t.Errorf("Even frame didn't report even.")
 	}
@@ -68,7 +69,7 @@ func TestPosition(t *testing.T) {
 	}
 
 	msg, _ = hex.DecodeString("8D40621D58C386435CC412692AD6")
-	resultOdd := getAdsbPosition(msg[4:])
+	resultOdd := getAdsbPosition(msg[4:], time.Now())
 	if resultOdd.Frame != 1 {
 		t.Errorf("Odd frame didn't report odd.")
 	}
@@ -81,3 +82,37 @@ func TestPosition(t *testing.T) {
 
 	CalcPosition(resultOdd, resultEven)
 }
+
+func TestSurfacePosition(t *testing.T) {
+	msg, _ := hex.DecodeString("8C4841753A9A153237AEF0F275BE")
+	result := getAdsbSurfacePosition(msg[4:], time.Now())
+	if !result.MovementValid || result.Speed != 17 {
+		t.Errorf("Bad speed: %f should be 17", result.Speed)
+	}
+	if !result.TrackValid || result.Track != 93 {
+		t.Errorf("Bad track: %d should be 93", result.Track)
+	}
+	if result.LatCPR != 39195 || result.LonCPR != 110320 {
+		t.Errorf("Bad CPR: %d/%d should be 39195/110320", result.LatCPR, result.LonCPR)
+	}
+}
+
+func TestDecodeMetrics(t *testing.T) {
+	ident := decodedMessages.With("17", "4", resultDecoded)
+	crcError := decodedMessages.With("17", "", resultCRCError)
+	ident0, crc0 := ident.Value(), crcError.Value()
+
+	msg, _ := hex.DecodeString("8D4840D6202CC371C32CE0576098")
+	if _, decoded := DecodeMessage(msg, time.Now()); decoded == nil {
+		t.Fatal("identification message wasn't decoded")
+	}
+	msg[5] ^= 0x01 // break the parity
+	DecodeMessage(msg, time.Now())
+
+	if n := ident.Value() - ident0; n != 1 {
+		t.Errorf("counted %d decoded identifications, want 1", n)
+	}
+	if n := crcError.Value() - crc0; n != 1 {
+		t.Errorf("counted %d parity failures, want 1", n)
+	}
+}
@@ -1,6 +1,9 @@
 package decoder
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type AircraftType int
 
@@ -27,6 +30,76 @@ const (
 	ACTypeUnknown
 )
 
+var aircraftTypeNames = []string{
+	"noinfo",
+	"light",
+	"small",
+	"large",
+	"highvortexlarge",
+	"heavy",
+	"highperformance",
+	"rotorcraft",
+	"glider",
+	"lighterthanair",
+	"parachutist",
+	"ultralight",
+	"uav",
+	"spacevehicle",
+	"surfaceemergency",
+	"surfaceservice",
+	"obstruction",
+	"clusterobstacle",
+	"lineobstacle",
+	"unknown",
+}
+
+// String returns a short lowercase name for the aircraft type, e.g.
+// "rotorcraft".
+func (t AircraftType) String() string {
+	if t < 0 || int(t) >= len(aircraftTypeNames) {
+		return fmt.Sprintf("AircraftType(%d)", int(t))
+	}
+	return aircraftTypeNames[t]
+}
+
+// ParseAircraftType is the inverse of AircraftType.String.
+func ParseAircraftType(name string) (AircraftType, error) {
+	for i, n := range aircraftTypeNames {
+		if n == name {
+			return AircraftType(i), nil
+		}
+	}
+	return ACTypeUnknown, fmt.Errorf("unknown aircraft type `%s`", name)
+}
+
+var emitterCategories = map[AircraftType]string{
+	ACTypeLight:            "A1",
+	ACTypeSmall:            "A2",
+	ACTypeLarge:            "A3",
+	ACTypeHighVortexLarge:  "A4",
+	ACTypeHeavy:            "A5",
+	ACTypeHighPerformance:  "A6",
+	ACTypeRotocraft:        "A7",
+	ACTypeGlider:           "B1",
+	ACTypeLighterThanAir:   "B2",
+	ACTypeParachutist:      "B3",
+	ACTypeUltralight:       "B4",
+	ACTypeUAV:              "B6",
+	ACTypeSpaceVehicle:     "B7",
+	ACTypeSurfaceEmergency: "C1",
+	ACTypeSurfaceService:   "C2",
+	ACTypeObstruction:      "C3",
+	ACTypeClusterObstacle:  "C4",
+	ACTypeLineObstacle:     "C5",
+}
+
+// EmitterCategory returns the ADS-B emitter category code for the aircraft
+// type, as used by dump1090 (e.g. "A3" for large aircraft), or "" if the
+// type doesn't say.
+func (t AircraftType) EmitterCategory() string {
+	return emitterCategories[t]
+}
+
 type AdsbIdentification struct {
 	Callsign string
 	Type     AircraftType
@@ -68,6 +141,20 @@ type AdsbPosition struct {
 	LonCPR    int
 }
 
+// AdsbSurfacePosition is a surface position message. Only aircraft that
+// believe they are on the ground send these.
+type AdsbSurfacePosition struct {
+	Timestamp     time.Time
+	TC            int
+	MovementValid bool
+	Speed         float64 // groundspeed in knots
+	TrackValid    bool
+	Track         int
+	Frame         int
+	LatCPR        int
+	LonCPR        int
+}
+
 type adsbMessageType string
 
 const (
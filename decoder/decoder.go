@@ -18,6 +18,7 @@ func DecodeMessage(msg []byte, tm time.Time) (string, interface{}) {
 
 		if !CheckCRC(msg) {
 			log.Warn().Msgf("parity failed for message from %s", hex.EncodeToString(icaoid))
+			countDecode(df, -1, resultCRCError)
 			return hex.EncodeToString(icaoid), nil
 		}
 		// if CheckCRC(msg) {
@@ -26,12 +27,13 @@ func DecodeMessage(msg []byte, tm time.Time) (string, interface{}) {
 		// 	fmt.Printf(", parity FAILED")
 		// }
 
-		_, typeStr := getADSBType(msg[4])
+		tc, typeStr := getADSBType(msg[4])
 		//fmt.Printf(". ADS-B: %d - %s", typeCode, typeStr)
 
 		if typeStr == msgAircraftID {
 			id := getAdsbIdentification(msg[4:])
 			//fmt.Printf(" | IDENT: %s (%d)", id.Callsign, id.EC)
+			countDecode(df, tc, resultDecoded)
 			return hex.EncodeToString(icaoid), &id
 		}
 
@@ -47,15 +49,25 @@ func DecodeMessage(msg []byte, tm time.Time) (string, interface{}) {
 			// 	speedtype = "true airspeed"
 			// }
 			//fmt.Printf(" | HDG: %03d ; VS: %5dfpm ; SPEED: %3dkt (%s)", vel.Heading, vel.VerticalRate, vel.Speed, speedtype)
+			countDecode(df, tc, resultDecoded)
 			return hex.EncodeToString(icaoid), &vel
 		}
 
+		if typeStr == msgSurfacePosition {
+			pos := getAdsbSurfacePosition(msg[4:], tm)
+			countDecode(df, tc, resultDecoded)
+			return hex.EncodeToString(icaoid), &pos
+		}
+
 		if typeStr == msgAirbornPosWithBaroAlt {
 			pos := getAdsbPosition(msg[4:], tm)
 			//fmt.Printf(" | ALT: %dft", pos.Altitude)
 			//fmt.Printf(" | LatCPR: %6d | LonCPR: %6d | Frame: %d", pos.LatCPR, pos.LonCPR, pos.Frame)
+			countDecode(df, tc, resultDecoded)
 			return hex.EncodeToString(icaoid), &pos
 		}
+		countDecode(df, tc, resultIgnored)
+		return "", nil
 	} else if df == 20 || df == 21 {
 		crc := CalcCRC(msg)
 		origcrc := msg[len(msg)-3:]
@@ -63,15 +75,18 @@ func DecodeMessage(msg []byte, tm time.Time) (string, interface{}) {
 		if msg[4] == 0x20 {
 			ident := getAdsbIdentification(msg[4:])
 			ident.Type = ACTypeUnknown
+			countDecode(df, -1, resultDecoded)
 			return hex.EncodeToString(icaoid), &ident
 		}
 
 		//tryTypeDecode(icaoid, msg[4:])
 		//fmt.Printf(", ICAO ID: %s", hex.EncodeToString(icaoid))
+		countDecode(df, -1, resultIgnored)
 		return hex.EncodeToString(icaoid), nil
 	}
 
 	//fmt.Printf("\n")
+	countDecode(df, -1, resultIgnored)
 	return "", nil
 }
 
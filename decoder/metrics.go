@@ -0,0 +1,28 @@
+package decoder
+
+import (
+	"strconv"
+
+	"github.com/racingmars/flighttrack/metrics"
+)
+
+// The outcomes of DecodeMessage, for the decode metrics.
+const (
+	resultDecoded  = "decoded"   // returned a message struct
+	resultCRCError = "crc_error" // failed the parity check
+	resultIgnored  = "ignored"   // a format or type code we don't decode
+)
+
+var decodedMessages = metrics.NewCounterVec("flighttrack_decoder_messages_total",
+	"Mode S messages decoded, by downlink format, ADS-B type code (for DF 17 and 18) and result.",
+	"df", "tc", "result")
+
+// countDecode records the outcome of decoding a message. tc is -1 for
+// messages that aren't ADS-B.
+func countDecode(df byte, tc int, result string) {
+	tcLabel := ""
+	if tc >= 0 {
+		tcLabel = strconv.Itoa(tc)
+	}
+	decodedMessages.With(strconv.Itoa(int(df)), tcLabel, result).Inc()
+}
@@ -0,0 +1,294 @@
+// Package export writes the flight and tracklog tables as CSV or Parquet
+// files for analysis. Flights include the same registration and airline
+// details as the web site. Rows are streamed from the database to the file,
+// so exports can be as large as the database.
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/parquet"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// Format is a file format to export to.
+type Format int
+
+const (
+	CSV Format = iota
+	Parquet
+)
+
+var formatNames = map[Format]string{
+	CSV:     "csv",
+	Parquet: "parquet",
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the Format with the given name, as returned by String.
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(name, n) {
+			return f, nil
+		}
+	}
+	return CSV, fmt.Errorf("unknown export format %q (want csv or parquet)", name)
+}
+
+// Extension is the file name extension for the format, without the dot.
+func (f Format) Extension() string {
+	return f.String()
+}
+
+// ContentType is the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == Parquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ParseDates parses a range of dates, each YYYY-MM-DD in local time or
+// YYYY-MM for a whole month, into the times from the start of the first to
+// the end of the last. If end is empty, the range is just start.
+func ParseDates(start, end string) (from, to time.Time, err error) {
+	if end == "" {
+		end = start
+	}
+	if from, _, err = parseDate(start); err != nil {
+		return from, to, err
+	}
+	_, to, err = parseDate(end)
+	return from, to, err
+}
+
+func parseDate(s string) (start, end time.Time, err error) {
+	if start, err = time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return start, start.AddDate(0, 0, 1), nil
+	}
+	if start, err = time.ParseInLocation("2006-01", s, time.Local); err == nil {
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return start, end, fmt.Errorf("invalid date %q (must be YYYY-MM-DD or YYYY-MM)", s)
+}
+
+// Tables are the tables that can be exported.
+var Tables = []string{"flights", "tracklog"}
+
+// Write exports the rows of table for the flights matching q to w.
+func Write(dao *data.DAO, table string, q data.FlightQuery, format Format, w io.Writer) error {
+	switch table {
+	case "flights":
+		t, err := newTableWriter(w, format, flightColumns)
+		if err != nil {
+			return err
+		}
+		err = dao.ExportFlights(q, func(f data.Flight) error {
+			return t.write(flightRow(f))
+		})
+		if err != nil {
+			return err
+		}
+		return t.close()
+	case "tracklog":
+		t, err := newTableWriter(w, format, trackLogColumns)
+		if err != nil {
+			return err
+		}
+		err = dao.ExportTrackLogs(q, func(l data.TrackLog) error {
+			return t.write(trackLogRow(l))
+		})
+		if err != nil {
+			return err
+		}
+		return t.close()
+	}
+	return fmt.Errorf("unknown table %q (want %s)", table, strings.Join(Tables, " or "))
+}
+
+var flightColumns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "icao", Type: parquet.String},
+	{Name: "callsign", Type: parquet.String, Optional: true},
+	{Name: "first_seen", Type: parquet.Timestamp},
+	{Name: "last_seen", Type: parquet.Timestamp, Optional: true},
+	{Name: "msg_count", Type: parquet.Int64, Optional: true},
+	{Name: "category", Type: parquet.Int64, Optional: true},
+	{Name: "category_name", Type: parquet.String, Optional: true},
+	{Name: "split_reason", Type: parquet.String, Optional: true},
+	{Name: "departure_airport", Type: parquet.String, Optional: true},
+	{Name: "departure_runway", Type: parquet.String, Optional: true},
+	{Name: "arrival_airport", Type: parquet.String, Optional: true},
+	{Name: "arrival_runway", Type: parquet.String, Optional: true},
+	{Name: "registration", Type: parquet.String, Optional: true},
+	{Name: "owner", Type: parquet.String, Optional: true},
+	{Name: "airline", Type: parquet.String, Optional: true},
+	{Name: "typecode", Type: parquet.String, Optional: true},
+	{Name: "mfg", Type: parquet.String, Optional: true},
+	{Name: "model", Type: parquet.String, Optional: true},
+	{Name: "year", Type: parquet.Int64, Optional: true},
+}
+
+func flightRow(f data.Flight) []interface{} {
+	var categoryName interface{}
+	if f.Category.Valid {
+		categoryName = decoder.AircraftType(f.Category.Int64).String()
+	}
+	return []interface{}{
+		int64(f.ID),
+		f.Icao,
+		nullString(f.Callsign),
+		f.FirstSeen,
+		nullTime(f.LastSeen),
+		nullInt(f.MsgCount),
+		nullInt(f.Category),
+		categoryName,
+		nullString(f.SplitReason),
+		nullString(f.Departure),
+		nullString(f.DepartureRunway),
+		nullString(f.Arrival),
+		nullString(f.ArrivalRunway),
+		nullString(f.Registration),
+		nullString(f.Owner),
+		nullString(f.Airline),
+		nullString(f.TypeCode),
+		nullString(f.Mfg),
+		nullString(f.Model),
+		nullInt(f.MfgYear),
+	}
+}
+
+var trackLogColumns = []parquet.Column{
+	{Name: "id", Type: parquet.Int64},
+	{Name: "flight_id", Type: parquet.Int64},
+	{Name: "time", Type: parquet.Timestamp},
+	{Name: "latitude", Type: parquet.Double, Optional: true},
+	{Name: "longitude", Type: parquet.Double, Optional: true},
+	{Name: "heading", Type: parquet.Int64, Optional: true},
+	{Name: "speed", Type: parquet.Int64, Optional: true},
+	{Name: "altitude", Type: parquet.Int64, Optional: true},
+	{Name: "vs", Type: parquet.Int64, Optional: true},
+	{Name: "callsign", Type: parquet.String, Optional: true},
+	{Name: "phase", Type: parquet.String, Optional: true},
+}
+
+func trackLogRow(t data.TrackLog) []interface{} {
+	return []interface{}{
+		int64(t.ID),
+		int64(t.FlightID),
+		t.Time,
+		nullFloat(t.Latitude),
+		nullFloat(t.Longitude),
+		nullInt(t.Heading),
+		nullInt(t.Speed),
+		nullInt(t.Altitude),
+		nullInt(t.Vs),
+		nullString(t.Callsign),
+		nullString(t.Phase),
+	}
+}
+
+// The null* functions return nil for NULL, for the table writers.
+
+func nullString(s sql.NullString) interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
+
+func nullInt(i sql.NullInt64) interface{} {
+	if !i.Valid {
+		return nil
+	}
+	return i.Int64
+}
+
+func nullFloat(f sql.NullFloat64) interface{} {
+	if !f.Valid {
+		return nil
+	}
+	return f.Float64
+}
+
+func nullTime(t pq.NullTime) interface{} {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+type tableWriter interface {
+	write(row []interface{}) error
+	close() error
+}
+
+func newTableWriter(w io.Writer, format Format, columns []parquet.Column) (tableWriter, error) {
+	if format == Parquet {
+		pw, err := parquet.NewWriter(w, columns, 0)
+		return parquetWriter{pw}, err
+	}
+	return newCSVWriter(w, columns)
+}
+
+type parquetWriter struct {
+	w *parquet.Writer
+}
+
+func (p parquetWriter) write(row []interface{}) error { return p.w.Write(row) }
+func (p parquetWriter) close() error                  { return p.w.Close() }
+
+// csvWriter writes a header line with the column names, then the rows. NULL
+// is an empty field and times are RFC 3339 in UTC.
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []parquet.Column) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	for i, col := range columns {
+		c.record[i] = col.Name
+	}
+	return c, c.w.Write(c.record)
+}
+
+func (c *csvWriter) write(row []interface{}) error {
+	for i, v := range row {
+		switch v := v.(type) {
+		case nil:
+			c.record[i] = ""
+		case string:
+			c.record[i] = v
+		case int64:
+			c.record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			c.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			c.record[i] = v.UTC().Format(time.RFC3339Nano)
+		default:
+			c.record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
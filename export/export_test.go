@@ -0,0 +1,57 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseDates(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		start, end string
+		from, to   time.Time
+	}{
+		{"2019-03-09", "", day(2019, 3, 9), day(2019, 3, 10)},
+		{"2019-03-09", "2019-03-11", day(2019, 3, 9), day(2019, 3, 12)},
+		{"2019-03", "", day(2019, 3, 1), day(2019, 4, 1)},
+		{"2019-12", "2020-01-15", day(2019, 12, 1), day(2020, 1, 16)},
+	}
+	for _, tt := range tests {
+		from, to, err := ParseDates(tt.start, tt.end)
+		if err != nil {
+			t.Errorf("ParseDates(%q, %q): %v", tt.start, tt.end, err)
+			continue
+		}
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("ParseDates(%q, %q) = %v, %v; want %v, %v", tt.start, tt.end, from, to, tt.from, tt.to)
+		}
+	}
+
+	if _, _, err := ParseDates("2019-3-9", ""); err == nil {
+		t.Error("ParseDates accepted 2019-3-9")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newTableWriter(&buf, CSV, trackLogColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []interface{}{int64(1), int64(2), time.Date(2019, 3, 9, 20, 0, 0, 500000000, time.FixedZone("PST", -8*3600)),
+		45.5, -122.25, nil, int64(250), int64(3500), int64(-600), "ASA12, X", nil}
+	if err = w.write(row); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,flight_id,time,latitude,longitude,heading,speed,altitude,vs,callsign,phase\n" +
+		"1,2,2019-03-10T04:00:00.5Z,45.5,-122.25,,250,3500,-600,\"ASA12, X\",\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
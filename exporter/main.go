@@ -0,0 +1,95 @@
+package main
+
+// exporter writes flights, with their registration and airline details, or
+// their track logs to a CSV or Parquet file for analysis. Rows are streamed
+// from the database, so a month or more of data can be exported at once. The
+// web server offers the same files at /export/:table/:format.
+//
+// Run with the connection string to Postgres in env variable "DBURL", e.g.
+// $ DBURL="user=flights dbname=flights sslmode=disable" \
+//   ./exporter -table tracklog -start 2019-03 -format parquet -o tracklog-2019-03.parquet
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/export"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+var table = flag.String("table", "flights", "Table to export: flights or tracklog")
+var formatName = flag.String("format", "", "File format, csv or parquet (default from the -o file name, or csv)")
+var start = flag.String("start", "", "Export flights first seen from this `date` (YYYY-MM-DD, or YYYY-MM for a month)")
+var end = flag.String("end", "", "Export flights first seen up to and including this `date` (default: -start)")
+var icao = flag.String("icao", "", "Export only the flights of this airframe")
+var output = flag.String("o", "", "Write to `file` instead of standard output")
+
+func main() {
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+
+	name := *formatName
+	if name == "" {
+		name = strings.TrimPrefix(filepath.Ext(*output), ".")
+		if _, err := export.ParseFormat(name); err != nil {
+			name = "csv"
+		}
+	}
+	format, err := export.ParseFormat(name)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	var q data.FlightQuery
+	if *start != "" {
+		if q.Start, q.End, err = export.ParseDates(*start, *end); err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+	} else if *end != "" {
+		log.Fatal().Msg("-end needs -start")
+	}
+	q.Icao = strings.ToLower(*icao)
+
+	db, err := getConnection()
+	if err != nil {
+		log.Fatal().Err(err).Msg("couldn't connect to DB")
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+	}
+
+	err = export.Write(data.New(db), *table, q, format, out)
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		if out != os.Stdout {
+			os.Remove(*output)
+		}
+		log.Fatal().Err(err).Msg("export failed")
+	}
+}
+
+func getConnection() (*sqlx.DB, error) {
+	connStr, ok := os.LookupEnv("DBURL")
+	if !ok {
+		return nil, fmt.Errorf("DBURL environment variable not set")
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	return db, err
+}
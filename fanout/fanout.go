@@ -0,0 +1,278 @@
+// Package fanout delivers tracker events to several FlightHandlers.
+//
+// Each handler added to a Handler gets its own queue and goroutine, so a slow
+// handler (a database on a bad day, a stalled network client) holds up only
+// its own queue rather than the tracker. What happens when a queue is full is
+// chosen per handler with a Policy. A handler that panics is logged and
+// skipped for that event; the others carry on.
+package fanout
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/tracker"
+	"github.com/rs/zerolog/log"
+)
+
+// Policy decides what happens to an event when a handler's queue is full.
+type Policy int
+
+const (
+	// Block waits for room in the queue, holding up the tracker. Use it for
+	// handlers that must see every event, like the database.
+	Block Policy = iota
+
+	// DropNewest discards the event that didn't fit.
+	DropNewest
+
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest
+)
+
+var policyNames = map[Policy]string{
+	Block:      "block",
+	DropNewest: "dropnewest",
+	DropOldest: "dropoldest",
+}
+
+func (p Policy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy returns the Policy with the given name, as returned by String.
+func ParsePolicy(name string) (Policy, error) {
+	for p, n := range policyNames {
+		if strings.EqualFold(name, n) {
+			return p, nil
+		}
+	}
+	return Block, fmt.Errorf("unknown queue policy %q (want block, dropnewest or dropoldest)", name)
+}
+
+// Options configure one handler's queue.
+type Options struct {
+	// QueueSize is the number of events that may wait for the handler. Zero
+	// calls the handler directly from the tracker's goroutine, without a
+	// queue; Policy is then ignored.
+	QueueSize int
+	Policy    Policy
+}
+
+// Stats counts what happened to a handler's events.
+type Stats struct {
+	Name    string
+	Queued  int    // events waiting now
+	Handled uint64 // events passed to the handler
+	Dropped uint64 // events discarded by the queue policy
+	Panics  uint64 // events the handler panicked on
+}
+
+type eventKind int
+
+const (
+	newFlight eventKind = iota
+	closeFlight
+	setIdentity
+	addTrackPoint
+	barrier
+)
+
+type event struct {
+	kind     eventKind
+	icaoID   string
+	tm       time.Time
+	reason   tracker.SplitReason
+	messages int
+	callsign string
+	category decoder.AircraftType
+	change   bool
+	point    tracker.TrackLog
+	done     chan struct{} // closed when a barrier is reached
+}
+
+type output struct {
+	name    string
+	handler tracker.FlightHandler
+	opts    Options
+	queue   chan event
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	handled uint64
+	dropped uint64
+	panics  uint64
+}
+
+// Handler is a tracker.FlightHandler that passes every event on to each of
+// the handlers added to it.
+type Handler struct {
+	outputs []*output
+}
+
+// New returns a Handler with no outputs.
+func New() *Handler {
+	return new(Handler)
+}
+
+// Add adds a handler. The name is used in logs and Stats. Handlers must all
+// be added before the first event is sent.
+func (h *Handler) Add(name string, handler tracker.FlightHandler, opts Options) {
+	o := &output{name: name, handler: handler, opts: opts}
+	if opts.QueueSize > 0 {
+		o.queue = make(chan event, opts.QueueSize)
+		o.wg.Add(1)
+		go o.run()
+	}
+	h.outputs = append(h.outputs, o)
+}
+
+func (h *Handler) NewFlight(icaoID string, firstSeen time.Time, reason tracker.SplitReason) {
+	h.send(event{kind: newFlight, icaoID: icaoID, tm: firstSeen, reason: reason})
+}
+
+func (h *Handler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {
+	h.send(event{kind: closeFlight, icaoID: icaoID, tm: lastSeen, messages: messages})
+}
+
+func (h *Handler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {
+	h.send(event{kind: setIdentity, icaoID: icaoID, callsign: callsign, category: category, change: change})
+}
+
+func (h *Handler) AddTrackPoint(icaoID string, trackPoint tracker.TrackLog) {
+	h.send(event{kind: addTrackPoint, icaoID: icaoID, point: trackPoint})
+}
+
+func (h *Handler) send(e event) {
+	for _, o := range h.outputs {
+		o.send(e)
+	}
+}
+
+// Wait blocks until every event sent so far has been handled or dropped.
+func (h *Handler) Wait() {
+	for _, o := range h.outputs {
+		if o.queue == nil {
+			continue
+		}
+		done := make(chan struct{})
+		o.queue <- event{kind: barrier, done: done}
+		<-done
+	}
+}
+
+// Close handles the events still queued and stops the queues. No events may
+// be sent after Close.
+func (h *Handler) Close() {
+	for _, o := range h.outputs {
+		if o.queue != nil {
+			close(o.queue)
+		}
+	}
+	for _, o := range h.outputs {
+		o.wg.Wait()
+		if o.dropped > 0 || o.panics > 0 {
+			log.Warn().Msgf("Handler %s dropped %d events and panicked on %d", o.name, o.dropped, o.panics)
+		}
+	}
+}
+
+// Stats returns the counters for each handler, in the order they were added.
+func (h *Handler) Stats() []Stats {
+	stats := make([]Stats, len(h.outputs))
+	for i, o := range h.outputs {
+		o.mu.Lock()
+		stats[i] = Stats{
+			Name:    o.name,
+			Queued:  len(o.queue),
+			Handled: o.handled,
+			Dropped: o.dropped,
+			Panics:  o.panics,
+		}
+		o.mu.Unlock()
+	}
+	return stats
+}
+
+func (o *output) send(e event) {
+	if o.queue == nil {
+		o.deliver(e)
+		return
+	}
+
+	switch o.opts.Policy {
+	case DropNewest:
+		select {
+		case o.queue <- e:
+		default:
+			o.count(&o.dropped)
+		}
+	case DropOldest:
+		for {
+			select {
+			case o.queue <- e:
+				return
+			default:
+			}
+			select {
+			case old := <-o.queue:
+				if old.kind == barrier {
+					// Someone is waiting for this one; let them go
+					close(old.done)
+					continue
+				}
+				o.count(&o.dropped)
+			default:
+				// The handler made room in the meantime
+			}
+		}
+	default:
+		o.queue <- e
+	}
+}
+
+func (o *output) run() {
+	defer o.wg.Done()
+	for e := range o.queue {
+		if e.kind == barrier {
+			close(e.done)
+			continue
+		}
+		o.deliver(e)
+	}
+}
+
+func (o *output) count(counter *uint64) {
+	o.mu.Lock()
+	*counter++
+	o.mu.Unlock()
+}
+
+// deliver calls the handler, recovering if it panics so the other handlers
+// and the tracker aren't taken down with it.
+func (o *output) deliver(e event) {
+	defer func() {
+		if r := recover(); r != nil {
+			o.count(&o.panics)
+			log.Error().Msgf("Handler %s panicked on event for %s: %v", o.name, e.icaoID, r)
+		}
+	}()
+
+	switch e.kind {
+	case newFlight:
+		o.handler.NewFlight(e.icaoID, e.tm, e.reason)
+	case closeFlight:
+		o.handler.CloseFlight(e.icaoID, e.tm, e.messages)
+	case setIdentity:
+		o.handler.SetIdentity(e.icaoID, e.callsign, e.category, e.change)
+	case addTrackPoint:
+		o.handler.AddTrackPoint(e.icaoID, e.point)
+	}
+	o.count(&o.handled)
+}
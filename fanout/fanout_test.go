@@ -0,0 +1,127 @@
+package fanout
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/tracker"
+)
+
+type countingHandler struct {
+	mu     sync.Mutex
+	points []int
+	gate   chan struct{} // if not nil, each event waits for a value
+	panic  bool
+}
+
+func (h *countingHandler) NewFlight(icaoID string, firstSeen time.Time, reason tracker.SplitReason) {
+}
+func (h *countingHandler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {}
+func (h *countingHandler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {
+}
+func (h *countingHandler) AddTrackPoint(icaoID string, trackPoint tracker.TrackLog) {
+	if h.gate != nil {
+		<-h.gate
+	}
+	if h.panic {
+		panic("handler failure")
+	}
+	h.mu.Lock()
+	h.points = append(h.points, trackPoint.Altitude)
+	h.mu.Unlock()
+}
+
+func sendPoints(h *Handler, n int) {
+	for i := 0; i < n; i++ {
+		h.AddTrackPoint("abcdef", tracker.TrackLog{Altitude: i})
+	}
+}
+
+func TestFanout(t *testing.T) {
+	sync1, queued := new(countingHandler), new(countingHandler)
+	h := New()
+	h.Add("sync", sync1, Options{})
+	h.Add("queued", queued, Options{QueueSize: 2})
+
+	sendPoints(h, 10)
+	if len(sync1.points) != 10 {
+		t.Errorf("Synchronous handler got %d points, should be 10", len(sync1.points))
+	}
+	h.Wait()
+	if len(queued.points) != 10 {
+		t.Errorf("Queued handler got %d points after Wait, should be 10", len(queued.points))
+	}
+	h.Close()
+}
+
+func TestDropPolicies(t *testing.T) {
+	newest := &countingHandler{gate: make(chan struct{})}
+	oldest := &countingHandler{gate: make(chan struct{})}
+	h := New()
+	h.Add("newest", newest, Options{QueueSize: 3, Policy: DropNewest})
+	h.Add("oldest", oldest, Options{QueueSize: 3, Policy: DropOldest})
+
+	// The first point is taken off each queue and then blocks the handler
+	// until the gate opens; the queues fill with the next three.
+	sendPoints(h, 1)
+	for h.Stats()[0].Queued > 0 || h.Stats()[1].Queued > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < 10; i++ {
+		h.AddTrackPoint("abcdef", tracker.TrackLog{Altitude: i})
+	}
+	close(newest.gate)
+	close(oldest.gate)
+	h.Close()
+
+	if want := []int{0, 1, 2, 3}; !equal(newest.points, want) {
+		t.Errorf("DropNewest handled %v, should be %v", newest.points, want)
+	}
+	if want := []int{0, 7, 8, 9}; !equal(oldest.points, want) {
+		t.Errorf("DropOldest handled %v, should be %v", oldest.points, want)
+	}
+	if s := h.Stats(); s[0].Dropped != 6 || s[1].Dropped != 6 {
+		t.Errorf("Dropped %d and %d, should be 6 each", s[0].Dropped, s[1].Dropped)
+	}
+}
+
+func TestPanicIsolation(t *testing.T) {
+	bad, good := &countingHandler{panic: true}, new(countingHandler)
+	h := New()
+	h.Add("bad", bad, Options{QueueSize: 10})
+	h.Add("good", good, Options{})
+	sendPoints(h, 5)
+	h.Close()
+
+	if len(good.points) != 5 {
+		t.Errorf("Good handler got %d points, should be 5", len(good.points))
+	}
+	if s := h.Stats(); s[0].Panics != 5 || s[0].Handled != 0 {
+		t.Errorf("Bad handler stats %+v", s[0])
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{Block, DropNewest, DropOldest} {
+		if got, err := ParsePolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParsePolicy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Errorf("ParsePolicy accepted a bad name")
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
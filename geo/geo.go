@@ -0,0 +1,41 @@
+// Package geo has the great-circle geometry shared by the tracker, the
+// coverage statistics, and airport and zone inference.
+package geo
+
+import "math"
+
+// EarthRadiusM is the Earth's mean radius in meters.
+const EarthRadiusM = 6372800
+
+// MetersPerNM is the length of a nautical mile in meters.
+const MetersPerNM = 1852
+
+// Distance returns the haversine distance in nautical miles between two
+// points.
+// https://janakiev.com/blog/gps-points-distance-python/
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * (math.Pi / 180)
+	phi2 := lat2 * (math.Pi / 180)
+	dphi := (lat2 - lat1) * (math.Pi / 180)
+	dlambda := (lon2 - lon1) * (math.Pi / 180)
+
+	a := math.Pow(math.Sin(dphi/2), 2) + math.Cos(phi1)*math.Cos(phi2)*math.Pow(math.Sin(dlambda/2), 2)
+	meters := 2 * EarthRadiusM * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return meters / MetersPerNM
+}
+
+// Bearing returns the initial true bearing in degrees, from 0 up to 360, from
+// the first point to the second.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * (math.Pi / 180)
+	phi2 := lat2 * (math.Pi / 180)
+	dlambda := (lon2 - lon1) * (math.Pi / 180)
+
+	y := math.Sin(dlambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dlambda)
+	b := math.Mod(math.Atan2(y, x)*(180/math.Pi)+360, 360)
+	if b >= 360 {
+		b = 0
+	}
+	return b
+}
@@ -0,0 +1,49 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	// PDX to SEA is about 113 nm
+	d := Distance(45.5887, -122.5975, 47.4502, -122.3088)
+	if math.Abs(d-112.5) > 1 {
+		t.Errorf("PDX to SEA: got %.1f nm", d)
+	}
+	// A degree of latitude is 60 nm
+	d = Distance(45, -122, 46, -122)
+	if math.Abs(d-60) > 0.5 {
+		t.Errorf("one degree of latitude: got %.1f nm", d)
+	}
+	if d = Distance(45, -122, 45, -122); d != 0 {
+		t.Errorf("same point: got %f nm", d)
+	}
+	// London to Berlin
+	if d = Distance(51.5073219, -0.1276474, 52.5170365, 13.3888599); !(d > 502 && d < 503) {
+		t.Errorf("London to Berlin: got %f nm, should be 502.55nm", d)
+	}
+}
+
+func TestBearing(t *testing.T) {
+	tests := []struct {
+		name       string
+		lat2, lon2 float64
+		want       float64
+	}{
+		{"north", 46, -122, 0},
+		{"east", 45, -121, 89.6},
+		{"south", 44, -122, 180},
+		{"west", 45, -123, 270.4},
+		{"northwest", 46, -123, 325.3},
+	}
+	for _, tc := range tests {
+		got := Bearing(45, -122, tc.lat2, tc.lon2)
+		if math.Abs(got-tc.want) > 0.5 {
+			t.Errorf("%s: got %.1f, want %.1f", tc.name, got, tc.want)
+		}
+		if got < 0 || got >= 360 {
+			t.Errorf("%s: %f out of range", tc.name, got)
+		}
+	}
+}
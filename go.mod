@@ -1,6 +1,6 @@
 module github.com/racingmars/flighttrack
 
-go 1.12
+go 1.16
 
 require (
 	github.com/jmoiron/sqlx v1.3.5
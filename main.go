@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"io"
 	"log"
 	"os"
@@ -9,12 +10,39 @@ import (
 	"github.com/racingmars/flighttrack/beast"
 	"github.com/racingmars/flighttrack/consolehandler"
 	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/fanout"
+	"github.com/racingmars/flighttrack/metrics"
 	"github.com/racingmars/flighttrack/tracker"
 )
 
+var consoleQueue = flag.Int("consolequeue", 1000, "Events to queue for console output (0 to print synchronously)")
+var consolePolicy = flag.String("consolepolicy", "block", "When the console queue is full: block, dropnewest or dropoldest")
+var metricsAddr = flag.String("metrics", ":1327", "Serve Prometheus metrics on /metrics at `address`, or nowhere if empty")
+
 func main() {
+	trackerFlags := tracker.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	config, err := trackerFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy, err := fanout.ParsePolicy(*consolePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Print(metrics.ListenAndServe(*metricsAddr))
+		}()
+	}
+
+	handlers := fanout.New()
+	handlers.Add("console", new(consolehandler.ConsoleHandler), fanout.Options{QueueSize: *consoleQueue, Policy: policy})
+	defer handlers.Close()
+
 	rdr := beast.New(os.Stdin)
-	tracker := tracker.New(new(consolehandler.ConsoleHandler), false)
+	tracker := tracker.New(handlers, config)
 	for {
 		msg, startoffset, err := rdr.Read()
 		if err == io.EOF {
@@ -30,7 +58,7 @@ func main() {
 		//fmt.Println(hex.EncodeToString(msg.Message))
 		icao, decoded := decoder.DecodeMessage(msg.Message, time.Now().UTC())
 		if icao != "" && icao != "000000" {
-			tracker.Message(icao, time.Now(), decoded)
+			tracker.MessageWithSignal(icao, time.Now(), msg.SignalLevel, decoded)
 		}
 	}
 }
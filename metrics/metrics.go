@@ -0,0 +1,333 @@
+// Package metrics keeps counters and gauges for the health of the receiver
+// and decoder, and serves them in the Prometheus text exposition format.
+//
+// Packages declare their metrics as package variables with NewCounter,
+// NewGauge and so on, which register them with the Default registry. Each
+// binary then serves Default on /metrics, with Handler or ListenAndServe.
+// Rates, such as messages per second, are left to Prometheus: take the rate()
+// of the corresponding counter.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is anything a Registry can write out.
+type metric interface {
+	// kind is the Prometheus TYPE: counter or gauge.
+	kind() string
+	// samples calls fn with the label values and value of each sample.
+	samples(fn func(labels []string, value float64))
+}
+
+type registered struct {
+	name   string
+	help   string
+	labels []string
+	metric metric
+}
+
+// Registry is a set of metrics with unique names.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]registered
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]registered)}
+}
+
+// Default is the registry that NewCounter and the other package functions
+// register with.
+var Default = NewRegistry()
+
+// validName reports whether name is a valid metric or label name.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// register adds a metric. It panics if the name is invalid or already taken,
+// since that's a programming error found as soon as the package loads.
+func (r *Registry) register(name, help string, labels []string, m metric) {
+	if !validName(name) {
+		panic(fmt.Sprintf("metrics: invalid name %q", name))
+	}
+	for _, l := range labels {
+		if !validName(l) || strings.Contains(l, ":") {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", l, name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.metrics[name] = registered{name, help, labels, m}
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	all := make([]registered, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		all = append(all, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range all {
+		fmt.Fprintf(cw, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", m.name, m.metric.kind())
+		m.metric.samples(func(values []string, value float64) {
+			cw.WriteString(m.name)
+			if len(m.labels) > 0 {
+				cw.WriteString("{")
+				for i, l := range m.labels {
+					if i > 0 {
+						cw.WriteString(",")
+					}
+					fmt.Fprintf(cw, "%s=\"%s\"", l, escapeLabel(values[i]))
+				}
+				cw.WriteString("}")
+			}
+			cw.WriteString(" ")
+			cw.WriteString(formatValue(value))
+			cw.WriteString("\n")
+		})
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) {
+	c.Write([]byte(s))
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ContentType is the MIME type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the metrics in r.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+// Handler serves the Default metrics.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// ListenAndServe serves the Default metrics on /metrics at addr, such as
+// ":1325". It only returns on error, so it is usually started in its own
+// goroutine.
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	n uint64 // first, for 64-bit alignment of atomic access
+}
+
+// NewCounter registers a counter with the Default registry.
+func NewCounter(name, help string) *Counter {
+	c := new(Counter)
+	Default.register(name, help, nil, c)
+	return c
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() { atomic.AddUint64(&c.n, 1) }
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.n, n) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.n) }
+
+func (c *Counter) kind() string { return "counter" }
+func (c *Counter) samples(fn func([]string, float64)) {
+	fn(nil, float64(c.Value()))
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits uint64 // math.Float64bits of the value
+}
+
+// NewGauge registers a gauge with the Default registry.
+func NewGauge(name, help string) *Gauge {
+	g := new(Gauge)
+	Default.register(name, help, nil, g)
+	return g
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+
+// Add adds v, which may be negative, to the gauge.
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+func (g *Gauge) kind() string { return "gauge" }
+func (g *Gauge) samples(fn func([]string, float64)) {
+	fn(nil, g.Value())
+}
+
+// gaugeFunc is a gauge whose value is computed when the metrics are written.
+type gaugeFunc func() float64
+
+// NewGaugeFunc registers a gauge with the Default registry whose value is
+// the result of fn at the time of each scrape. fn must be safe to call from
+// any goroutine.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(name, help, nil, gaugeFunc(fn))
+}
+
+func (g gaugeFunc) kind() string { return "gauge" }
+func (g gaugeFunc) samples(fn func([]string, float64)) {
+	fn(nil, g())
+}
+
+// CounterVec is a set of counters with the same name, told apart by the
+// values of their labels.
+type CounterVec struct {
+	labels   int
+	mu       sync.RWMutex
+	counters map[string]*Counter
+	values   map[string][]string
+}
+
+// NewCounterVec registers a set of counters with the given label names with
+// the Default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		labels:   len(labels),
+		counters: make(map[string]*Counter),
+		values:   make(map[string][]string),
+	}
+	Default.register(name, help, labels, v)
+	return v
+}
+
+// With returns the counter for the given label values, one for each label
+// name, creating it at zero if need be. Callers on a hot path can hold on to
+// the result rather than looking it up each time.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != v.labels {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(values), v.labels))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.counters[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.counters[key]; !ok {
+		c = new(Counter)
+		v.counters[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func (v *CounterVec) kind() string { return "counter" }
+func (v *CounterVec) samples(fn func([]string, float64)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.counters))
+	for k := range v.counters {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.mu.RLock()
+		c, values := v.counters[k], v.values[k]
+		v.mu.RUnlock()
+		fn(values, float64(c.Value()))
+	}
+}
+
+// Process metrics, which every binary has.
+var startTime = time.Now()
+
+func init() {
+	NewGaugeFunc("process_start_time_seconds", "Start time of the process since the Unix epoch in seconds.",
+		func() float64 { return float64(startTime.UnixNano()) / 1e9 })
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+}
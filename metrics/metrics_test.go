@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := new(Counter)
+	r.register("test_messages_total", "Messages read.", nil, c)
+	g := new(Gauge)
+	r.register("test_active", "Active things,\nwith a \\ in the help.", nil, g)
+	v := &CounterVec{labels: 2, counters: make(map[string]*Counter), values: make(map[string][]string)}
+	r.register("test_decoded_total", "Decoded messages.", []string{"df", "result"}, v)
+
+	c.Add(41)
+	c.Inc()
+	g.Set(2.5)
+	g.Add(-1)
+	v.With("17", "ok").Inc()
+	v.With("17", "ok").Inc()
+	v.With("11", `a "quoted"`+"\nvalue").Add(3)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d for %d bytes", n, buf.Len())
+	}
+	want := `# HELP test_active Active things,\nwith a \\ in the help.
+# TYPE test_active gauge
+test_active 1.5
+# HELP test_decoded_total Decoded messages.
+# TYPE test_decoded_total counter
+test_decoded_total{df="11",result="a \"quoted\"\nvalue"} 3
+test_decoded_total{df="17",result="ok"} 2
+# HELP test_messages_total Messages read.
+# TYPE test_messages_total counter
+test_messages_total 42
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.register("test_total", "", nil, new(Counter))
+	defer func() {
+		if recover() == nil {
+			t.Error("registering the same name twice didn't panic")
+		}
+	}()
+	r.register("test_total", "", nil, new(Counter))
+}
+
+func TestInvalidName(t *testing.T) {
+	for _, name := range []string{"", "1abc", "has-dash", "has space"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("name %q didn't panic", name)
+				}
+			}()
+			NewRegistry().register(name, "", nil, new(Counter))
+		}()
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	v := &CounterVec{labels: 1, counters: make(map[string]*Counter), values: make(map[string][]string)}
+	g := new(Gauge)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				v.With("x").Inc()
+				g.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := v.With("x").Value(); n != 8000 {
+		t.Errorf("counter is %d, want 8000", n)
+	}
+	if n := g.Value(); n != 8000 {
+		t.Errorf("gauge is %v, want 8000", n)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "# TYPE go_goroutines gauge\n") {
+		t.Errorf("default registry is missing go_goroutines:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,335 @@
+// Package migrate applies the flighttrack database schema migrations.
+//
+// Migrations are the numbered .sql files in the migrations directory, which
+// are embedded in every binary that imports this package. Each file is named
+// NNNN_description.sql and is applied in version order. Applied migrations
+// are recorded in the schema_version table along with a checksum of the file,
+// so an edited migration is detected rather than silently ignored.
+//
+// A migration normally runs inside a single transaction. Statements that
+// PostgreSQL refuses to run in a transaction block (CREATE INDEX
+// CONCURRENTLY, VACUUM, ...) go in a file whose first line is
+//
+//	-- migrate:notransaction
+//
+// The statements in such a file are run one at a time, so they should be
+// written to be safely re-run (e.g. with IF NOT EXISTS) in case a later
+// statement fails.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const noTransactionDirective = "-- migrate:notransaction"
+
+var migrationName = regexp.MustCompile(`^([0-9]{4})_([a-z0-9_]+)\.sql$`)
+
+// Migration is a single schema change.
+type Migration struct {
+	Version       int
+	Name          string
+	SQL           string
+	Transactional bool
+	Checksum      string
+}
+
+// BehindError indicates that the database has migrations that have not been
+// applied yet.
+type BehindError struct {
+	Current int
+	Latest  int
+}
+
+func (e BehindError) Error() string {
+	return fmt.Sprintf("database schema is at version %d but version %d is required; run dbmigrate",
+		e.Current, e.Latest)
+}
+
+// ChecksumError indicates that an applied migration's file has changed since
+// it was applied.
+type ChecksumError struct {
+	Version int
+	Name    string
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("migration %04d_%s has been modified since it was applied", e.Version, e.Name)
+}
+
+// Migrations returns all of the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("badly named migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration version %d used by both %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		text := string(data)
+
+		migrations = append(migrations, Migration{
+			Version:       version,
+			Name:          match[2],
+			SQL:           text,
+			Transactional: !strings.HasPrefix(text, noTransactionDirective),
+			Checksum:      hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the highest migration version known to this binary.
+func Latest() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+type appliedMigration struct {
+	version  int
+	checksum sql.NullString
+}
+
+// bootstrap creates the schema_version table if this is an empty database,
+// and adds the checksum columns if the database predates this package.
+func bootstrap(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE schema_version
+		ADD COLUMN IF NOT EXISTS name TEXT,
+		ADD COLUMN IF NOT EXISTS checksum TEXT`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO schema_version (version, name) VALUES (0, 'schema_version')
+		ON CONFLICT (version) DO NOTHING`)
+	return err
+}
+
+func loadApplied(db *sql.DB) (map[int]appliedMigration, error) {
+	rows, err := db.Query(`SELECT version, checksum FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.checksum); err != nil {
+			return nil, err
+		}
+		applied[a.version] = a
+	}
+	return applied, rows.Err()
+}
+
+// Check verifies that every migration has been applied and that none of the
+// applied migrations have changed. It returns a BehindError if the database
+// needs to be migrated, or a ChecksumError if a migration was modified.
+// Check does not modify the database.
+func Check(db *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT * FROM information_schema.columns
+		WHERE table_schema = CURRENT_SCHEMA
+		AND table_name = 'schema_version'
+		AND column_name = 'checksum')`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// Empty database, or one only ever set up by the old schema.sql
+		current, latest, err := Status(db)
+		if err != nil {
+			return err
+		}
+		return BehindError{Current: current, Latest: latest}
+	}
+
+	applied, err := loadApplied(db)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	for _, m := range migrations {
+		a, ok := applied[m.Version]
+		if !ok {
+			return BehindError{Current: current, Latest: migrations[len(migrations)-1].Version}
+		}
+		if a.checksum.Valid && a.checksum.String != m.Checksum {
+			return ChecksumError{Version: m.Version, Name: m.Name}
+		}
+		current = m.Version
+	}
+	return nil
+}
+
+// lockID is the Postgres advisory lock held while migrations are applied,
+// so that programs started with -migrate at the same time take turns.
+const lockID = 0x666c746d // "fltm"
+
+// Up applies every pending migration in order. Migrations that were applied
+// by the old schema.sql script have no recorded checksum; they are adopted
+// by recording the checksum of the embedded file.
+//
+// Up waits for any other Up to finish first, so the second of two finds the
+// migrations already applied.
+func Up(db *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	// The lock belongs to a session, so it is taken and released on a
+	// connection of its own for the whole run
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("waiting for the migration lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	if err = bootstrap(db); err != nil {
+		return fmt.Errorf("creating schema_version table: %v", err)
+	}
+	applied, err := loadApplied(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		a, ok := applied[m.Version]
+		if ok && a.checksum.Valid {
+			if a.checksum.String != m.Checksum {
+				return ChecksumError{Version: m.Version, Name: m.Name}
+			}
+			continue
+		}
+		if ok {
+			_, err = db.Exec(`UPDATE schema_version SET name=$1, checksum=$2 WHERE version=$3`,
+				m.Name, m.Checksum, m.Version)
+			if err != nil {
+				return err
+			}
+			log.Info().Msgf("Recorded checksum for previously applied migration %04d_%s", m.Version, m.Name)
+			continue
+		}
+
+		log.Info().Msgf("Applying migration %04d_%s", m.Version, m.Name)
+		if m.Transactional {
+			err = applyTransactional(db, m)
+		} else {
+			err = applyNonTransactional(db, m)
+		}
+		if err != nil {
+			return fmt.Errorf("applying migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+const recordMigration = `INSERT INTO schema_version (version, name, checksum) VALUES ($1, $2, $3)`
+
+func applyTransactional(db *sql.DB, m Migration) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range SplitStatements(m.SQL) {
+		if _, err = txn.Exec(stmt); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	if _, err = txn.Exec(recordMigration, m.Version, m.Name, m.Checksum); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+func applyNonTransactional(db *sql.DB, m Migration) error {
+	for _, stmt := range SplitStatements(m.SQL) {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(recordMigration, m.Version, m.Name, m.Checksum)
+	return err
+}
+
+// Status reports the highest applied migration and the highest known
+// migration. current is -1 if the database has no schema_version table.
+func Status(db *sql.DB) (current, latest int, err error) {
+	latest, err = Latest()
+	if err != nil {
+		return 0, 0, err
+	}
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT * FROM information_schema.tables
+		WHERE table_schema = CURRENT_SCHEMA
+		AND table_name = 'schema_version')`).Scan(&exists)
+	if err != nil {
+		return 0, latest, err
+	}
+	if !exists {
+		return -1, latest, nil
+	}
+	err = db.QueryRow(`SELECT COALESCE(max(version), -1) FROM schema_version`).Scan(&current)
+	return current, latest, err
+}
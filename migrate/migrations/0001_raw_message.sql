@@ -0,0 +1,8 @@
+-- Raw message logging table
+CREATE TABLE raw_message (
+  id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  message    BYTEA,
+  timestamp  BYTEA,
+  signal     SMALLINT,
+  created_at TIMESTAMP DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+);
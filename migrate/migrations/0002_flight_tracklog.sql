@@ -0,0 +1,32 @@
+-- Flight and track log tables
+CREATE TABLE flight (
+  id            INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  icao          CHAR(6) NOT NULL,
+  callsign      VARCHAR(8),
+  category      INT,
+  first_seen    TIMESTAMP NOT NULL,
+  last_seen     TIMESTAMP,
+  multicall     BOOLEAN DEFAULT FALSE,
+  msg_count     INTEGER
+);
+
+CREATE TABLE tracklog (
+  id        INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  flight_id INTEGER NOT NULL, --REFERENCES flight(id),
+  time      TIMESTAMP NOT NULL,
+  latitude  DOUBLE PRECISION,
+  longitude DOUBLE PRECISION,
+  heading   SMALLINT,
+  speed     SMALLINT,
+  altitude  INTEGER,
+  vs        SMALLINT,
+  squawk    VARCHAR(4),
+  callsign  VARCHAR(8),
+  category  INT
+);
+
+CREATE TABLE parameters (
+  name      TEXT NOT NULL PRIMARY KEY,
+  value_txt TEXT,
+  value_int INTEGER
+);
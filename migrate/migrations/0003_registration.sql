@@ -0,0 +1,14 @@
+-- Aircraft registration data
+CREATE TABLE registration (
+  icao         CHAR(6) PRIMARY KEY,
+  registration VARCHAR(10) NOT NULL,
+  typecode     VARCHAR(10),
+  mfg          TEXT,
+  model        TEXT,
+  year         INTEGER,
+  owner        TEXT,
+  city         TEXT,
+  state        TEXT,
+  country      TEXT,
+  source       VARCHAR(10) NOT NULL
+);
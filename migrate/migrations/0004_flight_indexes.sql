@@ -0,0 +1,3 @@
+-- Flight indexes
+CREATE INDEX idx_flight_start ON flight(first_seen);
+CREATE INDEX idx_flight_end ON flight(last_seen);
@@ -0,0 +1,7 @@
+-- Airline data
+CREATE TABLE airline (
+  icao     CHAR(3) PRIMARY KEY,
+  name     TEXT NOT NULL,
+  callsign TEXT,
+  country  TEXT
+);
@@ -0,0 +1,4 @@
+-- Change raw_message.id to bigint
+--
+-- This was never released; the data type was changed in version 1 instead.
+-- The version number is kept so existing databases line up.
@@ -0,0 +1,88 @@
+-- Monthly partitions for raw_message
+--
+-- raw_message becomes a table partitioned by month on created_at. The
+-- identity column is replaced with a plain sequence, and the primary key
+-- must include the partition key. Existing rows are copied into the new
+-- monthly partitions. This can take a long time on a large table.
+--
+-- created_at was nullable, and the partition key can't be, so a message
+-- without one is given the time of the message logged before it (or after
+-- it, if there is none before) rather than being lost.
+CREATE FUNCTION raw_message_create_partition(month TIMESTAMP) RETURNS TEXT AS
+$fn$
+DECLARE
+  start    TIMESTAMP := date_trunc('month', month);
+  partname TEXT := 'raw_message_' || to_char(start, 'YYYYMM');
+BEGIN
+  EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF raw_message FOR VALUES FROM (%L) TO (%L)',
+    partname, start, start + INTERVAL '1 month');
+  RETURN partname;
+END;
+$fn$ LANGUAGE plpgsql;
+
+ALTER TABLE raw_message RENAME TO raw_message_old;
+ALTER INDEX raw_message_pkey RENAME TO raw_message_old_pkey;
+ALTER TABLE raw_message_old ALTER COLUMN id DROP IDENTITY;
+
+UPDATE raw_message_old o SET created_at = COALESCE(
+    (SELECT p.created_at FROM raw_message_old p
+     WHERE p.id < o.id AND p.created_at IS NOT NULL ORDER BY p.id DESC LIMIT 1),
+    (SELECT n.created_at FROM raw_message_old n
+     WHERE n.id > o.id AND n.created_at IS NOT NULL ORDER BY n.id LIMIT 1),
+    CURRENT_TIMESTAMP AT TIME ZONE 'UTC')
+  WHERE created_at IS NULL;
+
+CREATE SEQUENCE raw_message_id_seq AS BIGINT;
+CREATE TABLE raw_message (
+  id         BIGINT NOT NULL DEFAULT nextval('raw_message_id_seq'),
+  message    BYTEA,
+  timestamp  BYTEA,
+  signal     SMALLINT,
+  created_at TIMESTAMP NOT NULL DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
+  PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+ALTER SEQUENCE raw_message_id_seq OWNED BY raw_message.id;
+SELECT setval('raw_message_id_seq', COALESCE((SELECT max(id) FROM raw_message_old), 0) + 1, false);
+
+SELECT raw_message_create_partition(month)
+FROM (
+  SELECT generate_series(
+    date_trunc('month', COALESCE(min(created_at), CURRENT_TIMESTAMP AT TIME ZONE 'UTC')),
+    date_trunc('month', CURRENT_TIMESTAMP AT TIME ZONE 'UTC') + INTERVAL '1 month',
+    INTERVAL '1 month') AS month
+  FROM raw_message_old
+) months;
+
+INSERT INTO raw_message (id, message, timestamp, signal, created_at)
+  SELECT id, message, timestamp, signal, created_at
+  FROM raw_message_old;
+DROP TABLE raw_message_old;
+
+-- Roll partitions up into their parent table so the about page shows a
+-- single raw_message line.
+DROP VIEW dbsize;
+CREATE VIEW dbsize AS
+WITH tables AS (
+  SELECT pgc.oid, pgc.relname
+  FROM pg_class pgc
+  INNER JOIN pg_namespace pgn ON pgc.relnamespace=pgn.oid
+  WHERE pgn.nspname='public' AND pgc.relkind IN ('r', 'p') AND NOT pgc.relispartition
+), sizes AS (
+  SELECT tables.relname,
+        sum(pg_table_size(tree.relid)) AS table_size,
+        sum(pg_indexes_size(tree.relid)) AS indexes_size,
+        sum(pg_total_relation_size(tree.relid)) AS total_size,
+        sum(GREATEST(leaf.reltuples, 0)) AS estimate
+  FROM tables
+  CROSS JOIN LATERAL pg_partition_tree(tables.oid) tree
+  INNER JOIN pg_class leaf ON leaf.oid=tree.relid
+  WHERE tree.isleaf
+  GROUP BY tables.relname
+)
+SELECT relname AS table_name,
+      pg_size_pretty(table_size) AS table_size,
+      pg_size_pretty(indexes_size) AS indexes_size,
+      pg_size_pretty(total_size) AS total_size,
+      total_size::bigint AS raw_size,
+      estimate::bigint AS rowcount
+FROM sizes;
@@ -0,0 +1,4 @@
+-- migrate:notransaction
+-- Index track logs by flight. The track log table is large, so build the
+-- index without locking out dbloader.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_tracklog_flight ON tracklog(flight_id);
@@ -0,0 +1,3 @@
+-- Why a flight was split from the same aircraft's previous flight (gap,
+-- landing, takeoff, callsign). NULL for a flight that wasn't split.
+ALTER TABLE flight ADD COLUMN split_reason TEXT;
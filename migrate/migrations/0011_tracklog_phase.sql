@@ -0,0 +1,3 @@
+-- Phase of flight (taxi, takeoff, climb, cruise, descent, approach, landing)
+-- at each track log point.
+ALTER TABLE tracklog ADD COLUMN phase TEXT;
@@ -0,0 +1,3 @@
+-- Binary parameter values, for tracker state saved in the compact binary
+-- format.
+ALTER TABLE parameters ADD COLUMN value_bin BYTEA;
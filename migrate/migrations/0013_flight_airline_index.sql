@@ -0,0 +1,4 @@
+-- migrate:notransaction
+-- Index flights by the airline designator at the start of the callsign, for
+-- the airline pages.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_flight_airline ON flight(substring(callsign from 1 for 3));
@@ -0,0 +1,2 @@
+-- Index registrations by type, for the aircraft type pages.
+CREATE INDEX IF NOT EXISTS idx_registration_typecode ON registration(typecode);
@@ -0,0 +1,33 @@
+-- Receiver coverage summaries, kept up to date from tracklog and raw_message
+-- by the coverage package.
+
+-- The farthest position seen in each bearing sector and altitude band.
+CREATE TABLE coverage_range (
+  sector    SMALLINT NOT NULL,
+  band      SMALLINT NOT NULL,
+  range_nm  DOUBLE PRECISION NOT NULL,
+  latitude  DOUBLE PRECISION NOT NULL,
+  longitude DOUBLE PRECISION NOT NULL,
+  altitude  INTEGER NOT NULL,
+  flight_id INTEGER NOT NULL,
+  time      TIMESTAMP NOT NULL,
+  PRIMARY KEY (sector, band)
+);
+
+-- Messages, positions and aircraft in each hour (UTC).
+CREATE TABLE coverage_hourly (
+  hour      TIMESTAMP PRIMARY KEY,
+  messages  BIGINT NOT NULL DEFAULT 0,
+  positions BIGINT NOT NULL DEFAULT 0,
+  aircraft  INTEGER NOT NULL DEFAULT 0
+);
+
+-- How far through tracklog and raw_message the summaries are. There is
+-- exactly one row.
+CREATE TABLE coverage_progress (
+  tracklog_id    BIGINT NOT NULL,
+  raw_message_id BIGINT NOT NULL,
+  aircraft_hour  TIMESTAMP,
+  updated_at     TIMESTAMP
+);
+INSERT INTO coverage_progress (tracklog_id, raw_message_id) VALUES (0, 0);
@@ -0,0 +1,37 @@
+-- Airports and runway ends, loaded by dataloader from OurAirports, and the
+-- departure and arrival of each flight as inferred from its track by the
+-- airport package.
+CREATE TABLE airport (
+  ident        TEXT PRIMARY KEY,
+  type         TEXT NOT NULL,
+  name         TEXT NOT NULL,
+  latitude     DOUBLE PRECISION NOT NULL,
+  longitude    DOUBLE PRECISION NOT NULL,
+  elevation    INTEGER,
+  country      TEXT,
+  region       TEXT,
+  municipality TEXT,
+  iata         TEXT
+);
+
+-- One row for each end of a runway. The position is the threshold and the
+-- heading is true, either of which may be unknown.
+CREATE TABLE runway (
+  airport   TEXT NOT NULL,
+  ident     TEXT NOT NULL,
+  latitude  DOUBLE PRECISION,
+  longitude DOUBLE PRECISION,
+  heading   DOUBLE PRECISION,
+  length    INTEGER,
+  surface   TEXT,
+  PRIMARY KEY (airport, ident)
+);
+
+-- airports_checked is set once a finished flight's departure and arrival
+-- have been looked for, whether or not they were found.
+ALTER TABLE flight
+  ADD COLUMN departure_airport TEXT,
+  ADD COLUMN departure_runway  TEXT,
+  ADD COLUMN arrival_airport   TEXT,
+  ADD COLUMN arrival_runway    TEXT,
+  ADD COLUMN airports_checked  BOOLEAN NOT NULL DEFAULT FALSE;
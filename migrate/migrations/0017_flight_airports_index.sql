@@ -0,0 +1,3 @@
+-- migrate:notransaction
+-- Index the flights whose airports haven't been looked for yet.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_flight_airports_unchecked ON flight(id) WHERE NOT airports_checked;
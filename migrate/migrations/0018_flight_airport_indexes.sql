@@ -0,0 +1,4 @@
+-- migrate:notransaction
+-- Index flights by departure and arrival airport, for the airport pages.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_flight_departure ON flight(departure_airport, first_seen);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_flight_arrival ON flight(arrival_airport, last_seen);
@@ -0,0 +1,37 @@
+-- Geofence zones, and the flights' entries to and exits from them, found by
+-- the zone package.
+
+-- A zone is a circle (latitude, longitude and radius_nm) or a polygon (a JSON
+-- array of [latitude, longitude] vertices), optionally between floor and
+-- ceiling altitudes in feet. tracklog_id is how far through the track log the
+-- zone has been checked.
+CREATE TABLE zone (
+  id          INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  name        TEXT NOT NULL UNIQUE,
+  description TEXT,
+  latitude    DOUBLE PRECISION,
+  longitude   DOUBLE PRECISION,
+  radius_nm   DOUBLE PRECISION,
+  polygon     JSONB,
+  floor       INTEGER,
+  ceiling     INTEGER,
+  tracklog_id BIGINT NOT NULL DEFAULT 0,
+  CHECK ((polygon IS NULL) = (latitude IS NOT NULL AND longitude IS NOT NULL AND radius_nm IS NOT NULL))
+);
+
+-- event is entry or exit. The position is the track log point where the
+-- flight was first seen inside or outside the zone.
+CREATE TABLE zone_event (
+  id          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  zone_id     INTEGER NOT NULL REFERENCES zone(id) ON DELETE CASCADE,
+  flight_id   INTEGER NOT NULL,
+  event       TEXT NOT NULL,
+  time        TIMESTAMP NOT NULL,
+  latitude    DOUBLE PRECISION NOT NULL,
+  longitude   DOUBLE PRECISION NOT NULL,
+  altitude    INTEGER,
+  tracklog_id BIGINT NOT NULL
+);
+CREATE INDEX idx_zone_event_zone ON zone_event(zone_id, time);
+CREATE INDEX idx_zone_event_flight ON zone_event(flight_id);
+CREATE INDEX idx_zone_event_time ON zone_event(time);
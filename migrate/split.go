@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitStatements splits a SQL script into individual statements on
+// semicolons, ignoring semicolons inside quoted strings, quoted identifiers,
+// dollar-quoted bodies, and comments. Statements that contain nothing but
+// comments and whitespace are dropped.
+func SplitStatements(script string) []string {
+	var statements []string
+	start := 0
+	hasCode := false
+
+	flush := func(end int) {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(script[start:end]))
+		}
+		start = end + 1
+		hasCode = false
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case c == '\'' || c == '"':
+			hasCode = true
+			i = skipQuoted(script, i, c)
+		case c == '$':
+			hasCode = true
+			if tag := dollarTag.FindString(script[i:]); tag != "" {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end < 0 {
+					i = len(script)
+				} else {
+					i += len(tag) + end + len(tag) - 1
+				}
+			}
+		case c == ';':
+			flush(i)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			hasCode = true
+		}
+	}
+	if start < len(script) {
+		flush(len(script))
+	}
+	return statements
+}
+
+// skipQuoted returns the index of the quote character that closes the
+// string or identifier opened at script[open]. A doubled quote character is
+// an escaped quote, not the end of the string.
+func skipQuoted(script string, open int, quote byte) int {
+	for i := open + 1; i < len(script); i++ {
+		if script[i] == quote {
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(script)
+}
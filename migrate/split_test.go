@@ -0,0 +1,57 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- leading comment
+CREATE TABLE a (x TEXT DEFAULT 'semi;colon', "odd;name" INT);
+/* block; comment */
+CREATE FUNCTION f() RETURNS INT AS
+$fn$
+BEGIN
+  RETURN 1;
+END;
+$fn$ LANGUAGE plpgsql;
+SELECT 'it''s; fine', $$a;b$$
+-- trailing comment only
+`
+	expected := []string{
+		`-- leading comment
+CREATE TABLE a (x TEXT DEFAULT 'semi;colon', "odd;name" INT)`,
+		`/* block; comment */
+CREATE FUNCTION f() RETURNS INT AS
+$fn$
+BEGIN
+  RETURN 1;
+END;
+$fn$ LANGUAGE plpgsql`,
+		`SELECT 'it''s; fine', $$a;b$$
+-- trailing comment only`,
+	}
+
+	result := SplitStatements(script)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Unexpected statements:\n%q\nshould be\n%q", result, expected)
+	}
+}
+
+func TestSplitCommentsOnly(t *testing.T) {
+	if result := SplitStatements("-- nothing here\n/* or here; */\n"); len(result) != 0 {
+		t.Errorf("Expected no statements, got %q", result)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration %s has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+// Package parquet writes tables as Apache Parquet files, for loading into
+// notebooks and analysis tools, and reads them back.
+//
+// It writes the simplest files that every reader understands: a flat schema,
+// PLAIN encoding, no compression unless gzip is asked for, and one data page
+// per column per row group. Rows are buffered a row group at a time, so a
+// table of any size can be streamed out, or read back, with bounded memory.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const magic = "PAR1"
+
+// DefaultRowGroupSize is the number of rows in each row group unless the
+// Writer is told otherwise.
+const DefaultRowGroupSize = 65536
+
+// Type is a column's type. Values written to a column must be of the
+// corresponding Go type, or nil if the column is Optional.
+type Type int
+
+const (
+	Boolean   Type = iota // bool
+	Int32                 // int32
+	Int64                 // int64
+	Double                // float64
+	String                // string
+	Timestamp             // time.Time, stored in microseconds UTC
+	Binary                // []byte
+)
+
+// Codec is the compression applied to each page.
+type Codec int32
+
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+)
+
+// Physical types
+const (
+	physBoolean   = 0
+	physInt32     = 1
+	physInt64     = 2
+	physDouble    = 5
+	physByteArray = 6
+)
+
+// Converted types
+const (
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+)
+
+// Encodings
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+func (t Type) physical() int32 {
+	switch t {
+	case Boolean:
+		return physBoolean
+	case Int32:
+		return physInt32
+	case Int64, Timestamp:
+		return physInt64
+	case Double:
+		return physDouble
+	}
+	return physByteArray
+}
+
+// Column describes a column of the table.
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool // whether values may be nil
+}
+
+type columnChunk struct {
+	offset           int64
+	size             int64
+	numValues        int64
+	uncompressedSize int64
+	codec            Codec
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	rows    int64
+	size    int64 // uncompressed, as total_byte_size wants
+}
+
+// Writer writes rows to a Parquet file.
+type Writer struct {
+	w            io.Writer
+	columns      []Column
+	rowGroupSize int
+	codec        Codec
+	offset       int64
+	err          error
+
+	gzbuf bytes.Buffer
+	gz    *gzip.Writer
+
+	// The current row group
+	rows    int
+	values  [][]byte  // PLAIN-encoded non-null values, per column
+	defined [][]bool  // whether each value was present, per optional column
+	bits    []boolBuf // Boolean columns are bit-packed
+
+	rowGroups []rowGroup
+}
+
+// boolBuf packs booleans LSB first, as PLAIN encoding wants.
+type boolBuf struct {
+	bytes []byte
+	n     int
+}
+
+func (b *boolBuf) add(v bool) {
+	if b.n%8 == 0 {
+		b.bytes = append(b.bytes, 0)
+	}
+	if v {
+		b.bytes[len(b.bytes)-1] |= 1 << uint(b.n%8)
+	}
+	b.n++
+}
+
+// NewWriter starts a Parquet file with the given columns on w. Rows are
+// written in groups of rowGroupSize, or DefaultRowGroupSize if it is 0.
+// Close must be called to finish the file.
+func NewWriter(w io.Writer, columns []Column, rowGroupSize int) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet: no columns")
+	}
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+	pw := &Writer{
+		w:            w,
+		columns:      columns,
+		rowGroupSize: rowGroupSize,
+		values:       make([][]byte, len(columns)),
+		defined:      make([][]bool, len(columns)),
+		bits:         make([]boolBuf, len(columns)),
+	}
+	pw.write([]byte(magic))
+	return pw, pw.err
+}
+
+// SetCodec sets the compression of the pages written from now on.
+func (pw *Writer) SetCodec(c Codec) {
+	pw.codec = c
+}
+
+func (pw *Writer) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	var n int
+	n, pw.err = pw.w.Write(b)
+	pw.offset += int64(n)
+}
+
+// Write adds a row, with one value per column. After an error, the Writer
+// can't be used.
+func (pw *Writer) Write(row []interface{}) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("parquet: row has %d values for %d columns", len(row), len(pw.columns))
+	}
+	for i, c := range pw.columns {
+		if err := pw.add(i, c, row[i]); err != nil {
+			// The row is half written; the file can't be finished
+			pw.err = err
+			return err
+		}
+	}
+	pw.rows++
+	if pw.rows >= pw.rowGroupSize {
+		pw.flush()
+	}
+	return pw.err
+}
+
+func (pw *Writer) add(i int, c Column, v interface{}) error {
+	if v == nil {
+		if !c.Optional {
+			return fmt.Errorf("parquet: nil value for required column %s", c.Name)
+		}
+		pw.defined[i] = append(pw.defined[i], false)
+		return nil
+	}
+
+	buf := pw.values[i]
+	var ok bool
+	switch c.Type {
+	case Boolean:
+		var b bool
+		if b, ok = v.(bool); ok {
+			pw.bits[i].add(b)
+		}
+	case Int32:
+		var n int32
+		if n, ok = v.(int32); ok {
+			buf = appendUint32(buf, uint32(n))
+		}
+	case Int64:
+		var n int64
+		if n, ok = v.(int64); ok {
+			buf = appendUint64(buf, uint64(n))
+		}
+	case Double:
+		var f float64
+		if f, ok = v.(float64); ok {
+			buf = appendUint64(buf, math.Float64bits(f))
+		}
+	case String:
+		var s string
+		if s, ok = v.(string); ok {
+			buf = appendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		}
+	case Timestamp:
+		var t time.Time
+		if t, ok = v.(time.Time); ok {
+			buf = appendUint64(buf, uint64(t.UnixNano()/int64(time.Microsecond)))
+		}
+	case Binary:
+		var b []byte
+		if b, ok = v.([]byte); ok {
+			buf = appendUint32(buf, uint32(len(b)))
+			buf = append(buf, b...)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("parquet: value %v (%T) for column %s", v, v, c.Name)
+	}
+	pw.values[i] = buf
+	if c.Optional {
+		pw.defined[i] = append(pw.defined[i], true)
+	}
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// flush writes the buffered rows as a row group.
+func (pw *Writer) flush() {
+	if pw.rows == 0 || pw.err != nil {
+		return
+	}
+	group := rowGroup{rows: int64(pw.rows)}
+	for i, c := range pw.columns {
+		var page []byte
+		if c.Optional {
+			levels := rleBools(pw.defined[i])
+			page = appendUint32(page, uint32(len(levels)))
+			page = append(page, levels...)
+		}
+		if c.Type == Boolean {
+			page = append(page, pw.bits[i].bytes...)
+		} else {
+			page = append(page, pw.values[i]...)
+		}
+
+		data := pw.compress(page)
+		h := newThriftWriter()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(pw.rows))
+		h.i32(2, encodingPlain)
+		h.i32(3, encodingRLE)
+		h.i32(4, encodingRLE)
+		h.endStruct()
+		header := h.end()
+
+		chunk := columnChunk{
+			offset:           pw.offset,
+			size:             int64(len(header) + len(data)),
+			numValues:        int64(pw.rows),
+			uncompressedSize: int64(len(header) + len(page)),
+			codec:            pw.codec,
+		}
+		pw.write(header)
+		pw.write(data)
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.uncompressedSize
+
+		pw.values[i] = pw.values[i][:0]
+		pw.defined[i] = pw.defined[i][:0]
+		pw.bits[i] = boolBuf{}
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows = 0
+}
+
+// compress returns the page compressed with the Writer's codec.
+func (pw *Writer) compress(page []byte) []byte {
+	if pw.codec != Gzip || pw.err != nil {
+		return page
+	}
+	pw.gzbuf.Reset()
+	if pw.gz == nil {
+		pw.gz = gzip.NewWriter(&pw.gzbuf)
+	} else {
+		pw.gz.Reset(&pw.gzbuf)
+	}
+	if _, pw.err = pw.gz.Write(page); pw.err == nil {
+		pw.err = pw.gz.Close()
+	}
+	return pw.gzbuf.Bytes()
+}
+
+// rleBools encodes definition levels with the RLE/bit-packing hybrid
+// encoding, as runs of equal values with a bit width of 1.
+func rleBools(vs []bool) []byte {
+	var out []byte
+	var b [binary.MaxVarintLen64]byte
+	for i := 0; i < len(vs); {
+		j := i
+		for j < len(vs) && vs[j] == vs[i] {
+			j++
+		}
+		out = append(out, b[:binary.PutUvarint(b[:], uint64(j-i)<<1)]...)
+		if vs[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// Close writes any buffered rows and the file footer. It doesn't close the
+// underlying writer.
+func (pw *Writer) Close() error {
+	pw.flush()
+	if pw.err != nil {
+		return pw.err
+	}
+
+	var numRows int64
+	for _, g := range pw.rowGroups {
+		numRows += g.rows
+	}
+
+	m := newThriftWriter()
+	m.i32(1, 1) // version
+
+	m.list(2, thriftStruct, len(pw.columns)+1)
+	m.beginElement()
+	m.string(4, "schema")
+	m.i32(5, int32(len(pw.columns)))
+	m.endStruct()
+	for _, c := range pw.columns {
+		m.beginElement()
+		m.i32(1, c.Type.physical())
+		if c.Optional {
+			m.i32(3, 1) // OPTIONAL
+		} else {
+			m.i32(3, 0) // REQUIRED
+		}
+		m.string(4, c.Name)
+		switch c.Type {
+		case String:
+			m.i32(6, convertedUTF8)
+			m.beginStruct(10)
+			m.beginStruct(1) // STRING
+			m.endStruct()
+			m.endStruct()
+		case Timestamp:
+			m.i32(6, convertedTimestampMicros)
+			m.beginStruct(10)
+			m.beginStruct(8) // TIMESTAMP
+			m.bool(1, true)  // isAdjustedToUTC
+			m.beginStruct(2)
+			m.beginStruct(2) // MICROS
+			m.endStruct()
+			m.endStruct()
+			m.endStruct()
+			m.endStruct()
+		}
+		m.endStruct()
+	}
+
+	m.i64(3, numRows)
+
+	m.list(4, thriftStruct, len(pw.rowGroups))
+	for _, g := range pw.rowGroups {
+		m.beginElement()
+		m.list(1, thriftStruct, len(g.columns))
+		for i, chunk := range g.columns {
+			c := pw.columns[i]
+			m.beginElement()
+			m.i64(2, chunk.offset)
+			m.beginStruct(3)
+			m.i32(1, c.Type.physical())
+			m.listI32(2, []int32{encodingPlain, encodingRLE})
+			m.listString(3, []string{c.Name})
+			m.i32(4, int32(chunk.codec))
+			m.i64(5, chunk.numValues)
+			m.i64(6, chunk.uncompressedSize)
+			m.i64(7, chunk.size)
+			m.i64(9, chunk.offset)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.endStruct()
+	}
+
+	m.string(6, "flighttrack")
+	footer := m.end()
+
+	pw.write(footer)
+	pw.write(appendUint32(nil, uint32(len(footer))))
+	pw.write([]byte(magic))
+	return pw.err
+}
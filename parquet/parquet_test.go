@@ -0,0 +1,333 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readAll reads every row of a file back.
+func readAll(t *testing.T, file []byte) ([]Column, [][]interface{}) {
+	r, err := NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]interface{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if r.NumRows() != int64(len(rows)) {
+		t.Errorf("NumRows %d, read %d", r.NumRows(), len(rows))
+	}
+	return r.Columns(), rows
+}
+
+func TestWriter(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "flag", Type: Boolean},
+		{Name: "count", Type: Int32, Optional: true},
+		{Name: "lat", Type: Double, Optional: true},
+		{Name: "callsign", Type: String, Optional: true},
+		{Name: "time", Type: Timestamp},
+		{Name: "raw", Type: Binary, Optional: true},
+	}
+	t0 := time.Date(2019, 3, 9, 12, 0, 0, 123456000, time.UTC)
+	var rows [][]interface{}
+	for i := 0; i < 23; i++ {
+		row := []interface{}{int64(i), i%3 == 0, nil, float64(i) / 4, nil, t0.Add(time.Duration(i) * time.Second), []byte{byte(i), 0x8d}}
+		if i%5 != 0 {
+			row[2] = int32(i * 10)
+		}
+		if i > 10 {
+			row[4] = "ASA" + string(rune('A'+i))
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := buf.Bytes()
+	if string(file[:4]) != magic || string(file[len(file)-4:]) != magic {
+		t.Fatal("missing magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footerStart := len(file) - 8 - footerLen
+	r := &thriftReader{b: file[:len(file)-8], pos: footerStart}
+	meta := r.readStruct()
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.pos != len(file)-8 {
+		t.Fatalf("footer ended at %d, want %d", r.pos, len(file)-8)
+	}
+
+	if meta[3] != int64(len(rows)) {
+		t.Errorf("num_rows %v, want %d", meta[3], len(rows))
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("%d schema elements", len(schema))
+	}
+	for i, c := range columns {
+		el := schema[i+1].(map[int64]interface{})
+		if el[4] != c.Name || el[1] != int64(c.Type.physical()) {
+			t.Errorf("schema element %d is %v", i, el)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 3 {
+		t.Fatalf("%d row groups, want 3", len(groups))
+	}
+	gotColumns, got := readAll(t, file)
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Errorf("read columns %v, want %v", gotColumns, columns)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read back\n%v\nwant\n%v", got, rows)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{{Name: "id", Type: Int64}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]interface{}{nil}); err == nil {
+		t.Error("nil value for required column accepted")
+	}
+	if err := w.Write([]interface{}{"x"}); err == nil {
+		t.Error("string value for int64 column accepted")
+	}
+	if err := w.Write([]interface{}{int64(1), int64(2)}); err == nil {
+		t.Error("row with too many values accepted")
+	}
+}
+
+func TestGzip(t *testing.T) {
+	columns := []Column{{Name: "id", Type: Int64}, {Name: "message", Type: Binary}}
+	var rows [][]interface{}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []interface{}{int64(i), []byte{0x8d, 0x48, 0x40, 0xd6, 0x20, 0x2c}})
+	}
+
+	write := func(codec Codec) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, columns, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetCodec(codec)
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	plain, gzipped := write(Uncompressed), write(Gzip)
+	if len(gzipped) >= len(plain)/2 {
+		t.Errorf("gzipped file is %d bytes, uncompressed %d", len(gzipped), len(plain))
+	}
+	if _, got := readAll(t, gzipped); !reflect.DeepEqual(got, rows) {
+		t.Errorf("gzipped rows read back differently")
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	for _, file := range [][]byte{
+		[]byte("PAR1"),
+		[]byte("not a parquet file at all"),
+		[]byte("PAR1\x00\x00\x00\x00\xff\x00\x00\x00PAR1"),
+	} {
+		if _, err := NewReader(bytes.NewReader(file), int64(len(file))); err == nil {
+			t.Errorf("NewReader(%q) accepted it", file)
+		}
+	}
+}
+
+// golden is the file the Writer should write for goldenRows, assembled by
+// hand from the Parquet format and Thrift compact protocol specifications
+// rather than from the Writer, so that mistakes the Writer and Reader share
+// are caught. It is also in testdata/golden.parquet, to check with other
+// Parquet readers; see testdata/README.
+var golden = concat(
+	[]byte("PAR1"),
+
+	// Column a (offset 4): page header, 17 bytes
+	[]byte{
+		0x15, 0x00, // type: DATA_PAGE
+		0x15, 0x18, // uncompressed_page_size: 12
+		0x15, 0x18, // compressed_page_size: 12
+		0x2c,       // data_page_header:
+		0x15, 0x04, //   num_values: 2
+		0x15, 0x00, //   encoding: PLAIN
+		0x15, 0x06, //   definition_level_encoding: RLE
+		0x15, 0x06, //   repetition_level_encoding: RLE
+		0x00,
+		0x00,
+	},
+	// Page: definition levels, then the one value
+	[]byte{
+		0x04, 0x00, 0x00, 0x00, // levels are 4 bytes
+		0x02, 0x01, // run of 1: defined
+		0x02, 0x00, // run of 1: null
+		0x01, 0x00, 0x00, 0x00, // 1
+	},
+
+	// Column s (offset 33): page header, 17 bytes
+	[]byte{
+		0x15, 0x00, // type: DATA_PAGE
+		0x15, 0x16, // uncompressed_page_size: 11
+		0x15, 0x16, // compressed_page_size: 11
+		0x2c,       // data_page_header:
+		0x15, 0x04, //   num_values: 2
+		0x15, 0x00, //   encoding: PLAIN
+		0x15, 0x06, //   definition_level_encoding: RLE
+		0x15, 0x06, //   repetition_level_encoding: RLE
+		0x00,
+		0x00,
+	},
+	// Page: the values, without definition levels as s is required
+	[]byte{0x01, 0x00, 0x00, 0x00, 'x'},
+	[]byte{0x02, 0x00, 0x00, 0x00, 'y', 'z'},
+
+	// FileMetaData (offset 61)
+	[]byte{
+		0x15, 0x02, // version: 1
+		0x19, 0x3c, // schema: list of 3 structs
+		0x48, 0x06, // [0] name: "schema"
+	},
+	[]byte("schema"),
+	[]byte{
+		0x15, 0x04, //     num_children: 2
+		0x00,
+		0x15, 0x02, // [1] type: INT32
+		0x25, 0x02, //     repetition_type: OPTIONAL
+		0x18, 0x01, 'a', // name: "a"
+		0x00,
+		0x15, 0x0c, // [2] type: BYTE_ARRAY
+		0x25, 0x00, //     repetition_type: REQUIRED
+		0x18, 0x01, 's', // name: "s"
+		0x25, 0x00, //     converted_type: UTF8
+		0x4c,       //     logicalType:
+		0x1c, 0x00, //       STRING
+		0x00,
+		0x00,
+		0x16, 0x04, // num_rows: 2
+		0x19, 0x1c, // row_groups: list of 1 struct
+		0x19, 0x2c, // [0] columns: list of 2 structs
+		0x26, 0x08, //     [0] file_offset: 4
+		0x1c,       //         meta_data:
+		0x15, 0x02, //           type: INT32
+		0x19, 0x25, 0x00, 0x06, // encodings: PLAIN, RLE
+		0x19, 0x18, 0x01, 'a', // path_in_schema: "a"
+		0x15, 0x00, //           codec: UNCOMPRESSED
+		0x16, 0x04, //           num_values: 2
+		0x16, 0x3a, //           total_uncompressed_size: 29
+		0x16, 0x3a, //           total_compressed_size: 29
+		0x26, 0x08, //           data_page_offset: 4
+		0x00,
+		0x00,
+		0x26, 0x42, //     [1] file_offset: 33
+		0x1c,       //         meta_data:
+		0x15, 0x0c, //           type: BYTE_ARRAY
+		0x19, 0x25, 0x00, 0x06, // encodings: PLAIN, RLE
+		0x19, 0x18, 0x01, 's', // path_in_schema: "s"
+		0x15, 0x00, //           codec: UNCOMPRESSED
+		0x16, 0x04, //           num_values: 2
+		0x16, 0x38, //           total_uncompressed_size: 28
+		0x16, 0x38, //           total_compressed_size: 28
+		0x26, 0x42, //           data_page_offset: 33
+		0x00,
+		0x00,
+		0x16, 0x72, //     total_byte_size: 57
+		0x16, 0x04, //     num_rows: 2
+		0x00,
+		0x28, 0x0b, // created_by: "flighttrack"
+	},
+	[]byte("flighttrack"),
+	[]byte{0x00},
+
+	[]byte{0x70, 0x00, 0x00, 0x00}, // footer length: 112
+	[]byte("PAR1"),
+)
+
+var goldenColumns = []Column{
+	{Name: "a", Type: Int32, Optional: true},
+	{Name: "s", Type: String},
+}
+
+var goldenRows = [][]interface{}{
+	{int32(1), "x"},
+	{nil, "yz"},
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestGolden(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, goldenColumns, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range goldenRows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.Bytes()
+	if !bytes.Equal(got, golden) {
+		i := 0
+		for i < len(got) && i < len(golden) && got[i] == golden[i] {
+			i++
+		}
+		t.Errorf("written file differs from golden at byte %d of %d:\n% x\nwant\n% x", i, len(golden), got, golden)
+	}
+
+	file, err := ioutil.ReadFile("testdata/golden.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(file, golden) {
+		t.Error("testdata/golden.parquet differs from golden")
+	}
+}
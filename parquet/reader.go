@@ -0,0 +1,430 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+var errCorrupt = errors.New("parquet: corrupt file")
+
+// thriftReader reads compact protocol structs into maps from field ID to
+// value: int64 for integers, string for binary, []interface{} for lists and
+// map[int64]interface{} for structs. It is only as general as the writer.
+type thriftReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.err != nil || r.pos >= len(r.b) {
+		r.err = errCorrupt
+		return 0
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *thriftReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		r.err = errCorrupt
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) int() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.int()
+	case thriftBinary:
+		n := int(r.varint())
+		if r.err != nil || n < 0 || n > len(r.b)-r.pos {
+			r.err = errCorrupt
+			return ""
+		}
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		if r.err != nil || n < 0 || n > len(r.b)-r.pos {
+			r.err = errCorrupt
+			return nil
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if h&0x0f == thriftTrue {
+				// Booleans in lists are a byte each
+				list[i] = r.byte() == 1
+			} else {
+				list[i] = r.value(h & 0x0f)
+			}
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = errCorrupt
+	return nil
+}
+
+func (r *thriftReader) readStruct() map[int64]interface{} {
+	s := make(map[int64]interface{})
+	var id int64
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		if delta := int64(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = r.int()
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+	return s
+}
+
+// Helpers for picking fields out of decoded structs; missing or mistyped
+// fields read as zero values.
+
+func fieldInt(s map[int64]interface{}, id int64) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func fieldString(s map[int64]interface{}, id int64) string {
+	v, _ := s[id].(string)
+	return v
+}
+
+func fieldStruct(s map[int64]interface{}, id int64) map[int64]interface{} {
+	v, _ := s[id].(map[int64]interface{})
+	return v
+}
+
+func fieldList(s map[int64]interface{}, id int64) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+type chunkLocation struct {
+	offset int64
+	codec  Codec
+}
+
+// Reader reads the files that Writer writes. It isn't a general Parquet
+// reader: it only understands flat schemas of the column Types here, PLAIN
+// encoded, uncompressed or gzipped, with one data page per column chunk.
+// Rows are read a row group at a time.
+type Reader struct {
+	r       io.ReaderAt
+	size    int64
+	columns []Column
+	numRows int64
+	groups  [][]chunkLocation
+
+	// The current row group, a slice of values per column
+	next   int
+	values [][]interface{}
+	row    int
+}
+
+// NewReader reads the footer of the size byte Parquet file in r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("parquet: file too short")
+	}
+	tail := make([]byte, 4+len(magic))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != magic {
+		return nil, fmt.Errorf("parquet: not a Parquet file")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail))
+	if footerLen > size-int64(len(tail)+len(magic)) {
+		return nil, errCorrupt
+	}
+	footer := make([]byte, footerLen)
+	if _, err := r.ReadAt(footer, size-int64(len(tail))-footerLen); err != nil {
+		return nil, err
+	}
+	t := &thriftReader{b: footer}
+	meta := t.readStruct()
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	pr := &Reader{r: r, size: size, numRows: fieldInt(meta, 3)}
+	schema := fieldList(meta, 2)
+	if len(schema) < 2 {
+		return nil, fmt.Errorf("parquet: no columns")
+	}
+	for _, e := range schema[1:] {
+		el, _ := e.(map[int64]interface{})
+		if fieldInt(el, 5) != 0 {
+			return nil, fmt.Errorf("parquet: nested schemas aren't supported")
+		}
+		c := Column{Name: fieldString(el, 4), Optional: fieldInt(el, 3) == 1}
+		if fieldInt(el, 3) == 2 {
+			return nil, fmt.Errorf("parquet: repeated column %s isn't supported", c.Name)
+		}
+		switch fieldInt(el, 1) {
+		case physBoolean:
+			c.Type = Boolean
+		case physInt32:
+			c.Type = Int32
+		case physInt64:
+			c.Type = Int64
+			if _, ok := el[6]; ok && fieldInt(el, 6) == convertedTimestampMicros {
+				c.Type = Timestamp
+			}
+		case physDouble:
+			c.Type = Double
+		case physByteArray:
+			c.Type = Binary
+			if _, ok := el[6]; ok && fieldInt(el, 6) == convertedUTF8 {
+				c.Type = String
+			}
+		default:
+			return nil, fmt.Errorf("parquet: column %s has an unsupported type", c.Name)
+		}
+		pr.columns = append(pr.columns, c)
+	}
+
+	for _, g := range fieldList(meta, 4) {
+		group, _ := g.(map[int64]interface{})
+		chunks := fieldList(group, 1)
+		if len(chunks) != len(pr.columns) {
+			return nil, errCorrupt
+		}
+		var locations []chunkLocation
+		for _, cc := range chunks {
+			chunk, _ := cc.(map[int64]interface{})
+			md := fieldStruct(chunk, 3)
+			locations = append(locations, chunkLocation{fieldInt(md, 9), Codec(fieldInt(md, 4))})
+		}
+		pr.groups = append(pr.groups, locations)
+	}
+	return pr, nil
+}
+
+// Columns returns the file's columns.
+func (pr *Reader) Columns() []Column {
+	return pr.columns
+}
+
+// NumRows returns the number of rows in the file.
+func (pr *Reader) NumRows() int64 {
+	return pr.numRows
+}
+
+// Read returns the next row, with one value per column of the Go type
+// Writer takes, or nil for a missing optional value. It returns io.EOF after
+// the last row.
+func (pr *Reader) Read() ([]interface{}, error) {
+	for pr.values == nil || pr.row >= len(pr.values[0]) {
+		if pr.next >= len(pr.groups) {
+			return nil, io.EOF
+		}
+		if err := pr.readGroup(pr.groups[pr.next]); err != nil {
+			return nil, err
+		}
+		pr.next++
+	}
+	row := make([]interface{}, len(pr.columns))
+	for i := range row {
+		row[i] = pr.values[i][pr.row]
+	}
+	pr.row++
+	return row, nil
+}
+
+func (pr *Reader) readGroup(chunks []chunkLocation) error {
+	pr.values = make([][]interface{}, len(chunks))
+	pr.row = 0
+	for i, chunk := range chunks {
+		values, err := pr.readChunk(pr.columns[i], chunk)
+		if err != nil {
+			return fmt.Errorf("parquet: column %s: %v", pr.columns[i].Name, err)
+		}
+		if i > 0 && len(values) != len(pr.values[0]) {
+			return errCorrupt
+		}
+		pr.values[i] = values
+	}
+	return nil
+}
+
+// maxPageHeader is more than enough for the page headers Writer writes.
+const maxPageHeader = 256
+
+func (pr *Reader) readChunk(c Column, chunk chunkLocation) ([]interface{}, error) {
+	if chunk.offset < 0 || chunk.offset >= pr.size {
+		return nil, errCorrupt
+	}
+	n := pr.size - chunk.offset
+	if n > maxPageHeader {
+		n = maxPageHeader
+	}
+	buf := make([]byte, n)
+	if _, err := pr.r.ReadAt(buf, chunk.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	t := &thriftReader{b: buf}
+	header := t.readStruct()
+	if t.err != nil {
+		return nil, t.err
+	}
+	if fieldInt(header, 1) != 0 {
+		return nil, fmt.Errorf("unsupported page type %d", fieldInt(header, 1))
+	}
+	dataHeader := fieldStruct(header, 5)
+	if fieldInt(dataHeader, 2) != encodingPlain {
+		return nil, fmt.Errorf("unsupported encoding %d", fieldInt(dataHeader, 2))
+	}
+	numValues := int(fieldInt(dataHeader, 1))
+	size := fieldInt(header, 3)
+	start := chunk.offset + int64(t.pos)
+	if numValues < 0 || size < 0 || size > pr.size-start {
+		return nil, errCorrupt
+	}
+	page := make([]byte, size)
+	if _, err := pr.r.ReadAt(page, start); err != nil {
+		return nil, err
+	}
+
+	switch chunk.codec {
+	case Uncompressed:
+	case Gzip:
+		gz, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		if page, err = ioutil.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported codec %d", chunk.codec)
+	}
+	return decodePage(c, page, numValues)
+}
+
+// decodePage decodes a PLAIN encoded data page, after its definition levels
+// if the column is optional.
+func decodePage(c Column, page []byte, numValues int) ([]interface{}, error) {
+	defined := make([]bool, 0, numValues)
+	if c.Optional {
+		if len(page) < 4 {
+			return nil, errCorrupt
+		}
+		n := int(binary.LittleEndian.Uint32(page))
+		if n > len(page)-4 {
+			return nil, errCorrupt
+		}
+		levels := &thriftReader{b: page[4 : 4+n]}
+		for levels.pos < n && levels.err == nil {
+			run := levels.varint()
+			if run&1 != 0 {
+				return nil, fmt.Errorf("bit-packed definition levels aren't supported")
+			}
+			v := levels.byte() == 1
+			for i := uint64(0); i < run>>1 && len(defined) < numValues; i++ {
+				defined = append(defined, v)
+			}
+		}
+		if levels.err != nil || len(defined) != numValues {
+			return nil, errCorrupt
+		}
+		page = page[4+n:]
+	} else {
+		for i := 0; i < numValues; i++ {
+			defined = append(defined, true)
+		}
+	}
+
+	values := make([]interface{}, numValues)
+	bit := 0
+	need := func(n int) bool { return len(page) >= n }
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+		switch c.Type {
+		case Boolean:
+			if !need(bit/8 + 1) {
+				return nil, errCorrupt
+			}
+			values[i] = page[bit/8]&(1<<uint(bit%8)) != 0
+			bit++
+		case Int32:
+			if !need(4) {
+				return nil, errCorrupt
+			}
+			values[i] = int32(binary.LittleEndian.Uint32(page))
+			page = page[4:]
+		case Int64, Timestamp:
+			if !need(8) {
+				return nil, errCorrupt
+			}
+			v := int64(binary.LittleEndian.Uint64(page))
+			if c.Type == Timestamp {
+				values[i] = time.Unix(0, v*int64(time.Microsecond)).UTC()
+			} else {
+				values[i] = v
+			}
+			page = page[8:]
+		case Double:
+			if !need(8) {
+				return nil, errCorrupt
+			}
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+		case String, Binary:
+			if !need(4) {
+				return nil, errCorrupt
+			}
+			n := int(binary.LittleEndian.Uint32(page))
+			if n < 0 || !need(4+n) {
+				return nil, errCorrupt
+			}
+			if c.Type == String {
+				values[i] = string(page[4 : 4+n])
+			} else {
+				b := make([]byte, n)
+				copy(b, page[4:4+n])
+				values[i] = b
+			}
+			page = page[4+n:]
+		}
+	}
+	return values, nil
+}
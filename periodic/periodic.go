@@ -0,0 +1,31 @@
+// Package periodic runs the jobs that web keeps its summary tables up to
+// date with, such as the coverage statistics and flights' airports.
+package periodic
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Run calls job straight away and then every interval until ctx is done.
+// Errors are logged and the job tried again next time. name says what the job
+// does, for the log, e.g. "refresh coverage statistics".
+func Run(ctx context.Context, name string, interval time.Duration, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if err := job(); err != nil {
+			log.Error().Err(err).Msgf("Couldn't %s", name)
+		} else {
+			log.Debug().Dur("elapsed", time.Since(start)).Msgf("Finished: %s", name)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
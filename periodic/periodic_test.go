@@ -0,0 +1,34 @@
+package periodic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, "test", time.Millisecond, func() error {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			// Errors don't stop the job
+			return errors.New("failed")
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+	if calls != 3 {
+		t.Errorf("job was called %d times, want 3", calls)
+	}
+}
@@ -0,0 +1,118 @@
+// Package replay samples flight tracks at regular intervals, so that many
+// flights can be animated together on a map. Positions between two track log
+// fixes are interpolated; there are none across gaps in reception, nor
+// before a flight's first fix or after its last.
+package replay
+
+import (
+	"math"
+	"time"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// Fix is a reported position of a flight.
+type Fix struct {
+	Time        time.Time
+	Latitude    float64
+	Longitude   float64
+	Altitude    int
+	HasAltitude bool
+	Heading     float64 // degrees true
+	HasHeading  bool
+}
+
+// Position is a flight's position in one frame of a replay.
+type Position struct {
+	Frame       int
+	Latitude    float64
+	Longitude   float64
+	Altitude    int
+	HasAltitude bool
+	Heading     float64
+	HasHeading  bool
+}
+
+// Frames returns the number of frames, step apart, from start to end
+// inclusive.
+func Frames(start, end time.Time, step time.Duration) int {
+	if step <= 0 || end.Before(start) {
+		return 0
+	}
+	return int(end.Sub(start)/step) + 1
+}
+
+// Sample returns the position of a flight in each of frames frames, the
+// first at start and the rest step apart, given its fixes in time order.
+// Frames where the flight wasn't being received, because they are before its
+// first fix, after its last, or between two fixes more than maxGap apart, are
+// left out.
+func Sample(fixes []Fix, start time.Time, step time.Duration, frames int, maxGap time.Duration) []Position {
+	var positions []Position
+	j := 0
+	for frame := 0; frame < frames; frame++ {
+		t := start.Add(time.Duration(frame) * step)
+		// Move to the last fix at or before t
+		for j+1 < len(fixes) && !fixes[j+1].Time.After(t) {
+			j++
+		}
+		if j >= len(fixes) || fixes[j].Time.After(t) {
+			continue
+		}
+		a := fixes[j]
+		if a.Time.Equal(t) {
+			// The next fix, if there's one in time, gives the direction
+			b := a
+			if j+1 < len(fixes) && fixes[j+1].Time.Sub(a.Time) <= maxGap {
+				b = fixes[j+1]
+			}
+			positions = append(positions, position(frame, a, b, 0))
+			continue
+		}
+		if j+1 >= len(fixes) {
+			break
+		}
+		b := fixes[j+1]
+		gap := b.Time.Sub(a.Time)
+		if gap > maxGap {
+			continue
+		}
+		positions = append(positions, position(frame, a, b, float64(t.Sub(a.Time))/float64(gap)))
+	}
+	return positions
+}
+
+// position interpolates the fraction f of the way from a to b.
+func position(frame int, a, b Fix, f float64) Position {
+	p := Position{
+		Frame:     frame,
+		Latitude:  a.Latitude + (b.Latitude-a.Latitude)*f,
+		Longitude: a.Longitude + (b.Longitude-a.Longitude)*f,
+	}
+
+	switch {
+	case a.HasAltitude && b.HasAltitude:
+		p.Altitude = a.Altitude + int(math.Round(float64(b.Altitude-a.Altitude)*f))
+		p.HasAltitude = true
+	case a.HasAltitude:
+		p.Altitude, p.HasAltitude = a.Altitude, true
+	case b.HasAltitude:
+		p.Altitude, p.HasAltitude = b.Altitude, true
+	}
+
+	switch {
+	case a.HasHeading && b.HasHeading:
+		// The short way round
+		turn := math.Mod(b.Heading-a.Heading+540, 360) - 180
+		p.Heading = math.Mod(a.Heading+turn*f+360, 360)
+		p.HasHeading = true
+	case a.HasHeading:
+		p.Heading, p.HasHeading = a.Heading, true
+	case b.HasHeading:
+		p.Heading, p.HasHeading = b.Heading, true
+	case a.Latitude != b.Latitude || a.Longitude != b.Longitude:
+		p.Heading = geo.Bearing(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+		p.HasHeading = true
+	}
+	return p
+}
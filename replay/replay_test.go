@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return t0.Add(time.Duration(seconds) * time.Second)
+}
+
+func TestFrames(t *testing.T) {
+	if n := Frames(t0, at(60), 10*time.Second); n != 7 {
+		t.Errorf("Frames for a minute at 10s = %d, want 7", n)
+	}
+	if n := Frames(t0, at(65), 10*time.Second); n != 7 {
+		t.Errorf("Frames for 65s at 10s = %d, want 7", n)
+	}
+	if n := Frames(at(60), t0, 10*time.Second); n != 0 {
+		t.Errorf("Frames backwards = %d, want 0", n)
+	}
+}
+
+func TestSample(t *testing.T) {
+	fixes := []Fix{
+		{Time: at(10), Latitude: 45, Longitude: -122, Altitude: 1000, HasAltitude: true, Heading: 350, HasHeading: true},
+		{Time: at(30), Latitude: 46, Longitude: -121, Altitude: 3000, HasAltitude: true, Heading: 10, HasHeading: true},
+		// A gap in reception
+		{Time: at(300), Latitude: 47, Longitude: -120},
+		{Time: at(310), Latitude: 47, Longitude: -119},
+	}
+	got := Sample(fixes, t0, 10*time.Second, 40, time.Minute)
+
+	frames := make([]int, len(got))
+	for i, p := range got {
+		frames[i] = p.Frame
+	}
+	want := []int{1, 2, 3, 30, 31}
+	if len(frames) != len(want) {
+		t.Fatalf("frames %v, want %v", frames, want)
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Fatalf("frames %v, want %v", frames, want)
+		}
+	}
+
+	mid := got[1]
+	if mid.Latitude != 45.5 || mid.Longitude != -121.5 || mid.Altitude != 2000 {
+		t.Errorf("halfway position %+v", mid)
+	}
+	// 350 to 10 is a turn through north, not the long way round
+	if !mid.HasHeading || math.Abs(mid.Heading) > 1e-9 {
+		t.Errorf("halfway heading %v, want 0", mid.Heading)
+	}
+	// No reported heading: use the direction of travel
+	if p := got[3]; !p.HasHeading || math.Abs(p.Heading-90) > 1 || p.HasAltitude {
+		t.Errorf("position without heading or altitude %+v", p)
+	}
+}
+
+func TestSampleNoFixes(t *testing.T) {
+	if got := Sample(nil, t0, time.Second, 10, time.Minute); len(got) != 0 {
+		t.Errorf("got %d positions from no fixes", len(got))
+	}
+	one := []Fix{{Time: at(5), Latitude: 45, Longitude: -122}}
+	got := Sample(one, t0, time.Second, 10, time.Minute)
+	if len(got) != 1 || got[0].Frame != 5 {
+		t.Errorf("a single fix gave %+v", got)
+	}
+}
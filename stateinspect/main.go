@@ -0,0 +1,158 @@
+package main
+
+// stateinspect prints the tracker and handler state that dbloader saves in
+// the parameters table, so it can be checked without resuming from it. The
+// tracker state is decoded from any format or version dbloader has written.
+//
+// Run with the connection string to Postgres in env variable "DBURL", e.g.
+// $ DBURL="user=flights dbname=flights sslmode=disable" ./stateinspect
+//
+// or read tracker state from a file with -file.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/racingmars/flighttrack/tracker"
+)
+
+var filename = flag.String("file", "", "Read tracker state from `file` instead of the database")
+var dumpJSON = flag.Bool("json", false, "Print the decoded tracker state as JSON in the current version")
+
+func main() {
+	flag.Parse()
+
+	var trackerstate, handlerstate []byte
+	if *filename != "" {
+		data, err := ioutil.ReadFile(*filename)
+		if err != nil {
+			fatal(err)
+		}
+		trackerstate = data
+	} else {
+		db, err := getConnection()
+		if err != nil {
+			fatal(err)
+		}
+		defer db.Close()
+
+		var lastmsgid int64
+		if err = db.Get(&lastmsgid, `SELECT value_int FROM parameters WHERE name='lastmsgid'`); err != nil {
+			fatal(fmt.Errorf("reading lastmsgid: %v", err))
+		}
+		err = db.Get(&trackerstate, `SELECT COALESCE(value_bin, convert_to(value_txt, 'UTF8'))
+			FROM parameters WHERE name='trackerstate'`)
+		if err != nil {
+			fatal(fmt.Errorf("reading trackerstate: %v", err))
+		}
+		if err = db.Get(&handlerstate, `SELECT value_txt FROM parameters WHERE name='handlerstate'`); err != nil {
+			fatal(fmt.Errorf("reading handlerstate: %v", err))
+		}
+		if !*dumpJSON {
+			fmt.Printf("Last raw message ID: %d\n", lastmsgid)
+		}
+	}
+
+	state, format, version, err := tracker.DecodeState(trackerstate)
+	if err != nil {
+		fatal(fmt.Errorf("decoding tracker state: %v", err))
+	}
+
+	if *dumpJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err = e.Encode(state); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("Tracker state: %d bytes, %s format, version %d", len(trackerstate), format, version)
+	if version != tracker.StateVersion {
+		fmt.Printf(" (migrated to version %d)", tracker.StateVersion)
+	}
+	fmt.Printf("\n\n")
+
+	flightIDs := make(map[string]int)
+	if handlerstate != nil {
+		if err = json.Unmarshal(handlerstate, &flightIDs); err != nil {
+			fatal(fmt.Errorf("decoding handler state: %v", err))
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ICAO\tFLIGHT\tCALLSIGN\tFIRST SEEN\tLAST SEEN\tMSGS\tPOSITION\tALT\tFRAMES\tPENDING\n")
+	for _, f := range state.Flights {
+		id := "-"
+		if n, ok := flightIDs[f.IcaoID]; ok {
+			id = fmt.Sprint(n)
+			delete(flightIDs, f.IcaoID)
+		} else if handlerstate == nil {
+			id = ""
+		}
+		callsign := ""
+		if f.Callsign != nil {
+			callsign = *f.Callsign
+		}
+		position := ""
+		if f.Current.PositionValid {
+			position = fmt.Sprintf("%.4f,%.4f", f.Current.Latitude, f.Current.Longitude)
+		}
+		altitude := ""
+		if f.Current.AltitudeValid {
+			altitude = fmt.Sprint(f.Current.Altitude)
+		}
+		frames := ""
+		if f.EvenFrame != nil {
+			frames += "E"
+		}
+		if f.OddFrame != nil {
+			frames += "O"
+		}
+		pending := ""
+		if f.PendingChange {
+			pending = "yes"
+		}
+		if len(f.Window) > 0 {
+			pending = fmt.Sprintf("%d fixes", len(f.Window))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", f.IcaoID, id, callsign,
+			f.FirstSeen.UTC().Format(time.RFC3339), f.LastSeen.UTC().Format(time.RFC3339),
+			f.MessageCount, position, altitude, frames, pending)
+	}
+	w.Flush()
+	fmt.Printf("\n%d flights\n", len(state.Flights))
+
+	if len(flightIDs) > 0 {
+		// These would be orphaned if dbloader resumed from this state
+		var orphans []string
+		for icao := range flightIDs {
+			orphans = append(orphans, icao)
+		}
+		sort.Strings(orphans)
+		fmt.Printf("Handler state has flight IDs for aircraft the tracker doesn't have: %v\n", orphans)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func getConnection() (*sqlx.DB, error) {
+	connStr, ok := os.LookupEnv("DBURL")
+	if !ok {
+		return nil, fmt.Errorf("DBURL environment variable not set")
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	return db, err
+}
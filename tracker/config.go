@@ -0,0 +1,366 @@
+package tracker
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+// Thresholds control when a change in an aircraft's state is significant
+// enough to be reported as a new track log point.
+type Thresholds struct {
+	// ReportMinInterval is the shortest time between unforced reports.
+	ReportMinInterval time.Duration
+
+	// ReportMaxInterval, if non-zero, forces a report at least this often
+	// while the aircraft is moving, even if nothing else has changed
+	// significantly. This is what keeps the track of an aircraft flying
+	// straight and level dense enough to replay and chart; the distance
+	// epsilon alone would only report it every DistanceEpsilonNM. It isn't
+	// used in simplify mode, where a straight track needs no points between
+	// its ends.
+	ReportMaxInterval time.Duration
+
+	HeadingEpsilon    int
+	SpeedEpsilon      int
+	VSEpsilon         int
+	AltitudeEpsilon   int
+	DistanceEpsilonNM float64
+
+	// SimplifyToleranceM is the greatest distance, in meters, a received
+	// position may be from the stored track in simplify mode.
+	SimplifyToleranceM float64
+}
+
+// Overrides replaces some of the default Thresholds for a category of
+// aircraft. Nil fields keep the default value.
+type Overrides struct {
+	ReportMinInterval *Duration `json:"report_min_interval"`
+	ReportMaxInterval *Duration `json:"report_max_interval"`
+	HeadingEpsilon    *int      `json:"heading_epsilon"`
+	SpeedEpsilon      *int      `json:"speed_epsilon"`
+	VSEpsilon         *int      `json:"vs_epsilon"`
+	AltitudeEpsilon   *int      `json:"altitude_epsilon"`
+	DistanceEpsilonNM *float64  `json:"distance_epsilon_nm"`
+
+	SimplifyToleranceM *float64 `json:"simplify_tolerance_m"`
+}
+
+// Config holds the tunable parameters of a Tracker.
+type Config struct {
+	// SweepInterval is how often idle flights are looked for.
+	SweepInterval time.Duration
+
+	// DecayTime is how long an aircraft may go without sending any messages
+	// before its flight is closed.
+	DecayTime time.Duration
+
+	// ForceReporting reports every change, ignoring the thresholds.
+	ForceReporting bool
+
+	// Simplify buffers position fixes and reports the fewest points that
+	// keep the track within SimplifyToleranceM of every fix, instead of
+	// using the epsilons. Velocity and altitude changes between reported
+	// points are not recorded, and ReportMaxInterval is ignored.
+	Simplify bool
+
+	// SimplifyWindow is the most fixes that will be buffered before one is
+	// reported regardless.
+	SimplifyWindow int
+
+	// Thresholds are the reporting thresholds for all aircraft...
+	Thresholds
+
+	// ...except those whose ADS-B category has overrides here.
+	Categories map[decoder.AircraftType]Overrides
+}
+
+// DefaultConfig returns the tracker's standard configuration.
+func DefaultConfig() Config {
+	return Config{
+		SweepInterval:  30 * time.Second,
+		DecayTime:      5 * time.Minute,
+		SimplifyWindow: 200,
+		Thresholds: Thresholds{
+			ReportMinInterval: 5 * time.Second,
+			ReportMaxInterval: 10 * time.Second,
+			HeadingEpsilon:    10,
+			SpeedEpsilon:      10,
+			VSEpsilon:         150,
+			AltitudeEpsilon:   200,
+			DistanceEpsilonNM: 10,
+
+			SimplifyToleranceM: 100,
+		},
+	}
+}
+
+// Validate returns an error if the configuration can't work: the sweep
+// interval and decay time must be positive, and the report intervals,
+// epsilons and simplify settings mustn't be negative, for every category.
+func (c Config) Validate() error {
+	if c.SweepInterval <= 0 {
+		return fmt.Errorf("sweep interval must be positive, not %s", c.SweepInterval)
+	}
+	if c.DecayTime <= 0 {
+		return fmt.Errorf("decay time must be positive, not %s", c.DecayTime)
+	}
+	if c.SimplifyWindow < 0 {
+		return fmt.Errorf("simplify window must not be negative, not %d", c.SimplifyWindow)
+	}
+	if err := c.Thresholds.validate(); err != nil {
+		return err
+	}
+	for category, o := range c.Categories {
+		if err := o.apply(c.Thresholds).validate(); err != nil {
+			return fmt.Errorf("%s: %v", category, err)
+		}
+	}
+	return nil
+}
+
+func (t Thresholds) validate() error {
+	if t.ReportMinInterval < 0 {
+		return fmt.Errorf("minimum report interval must not be negative, not %s", t.ReportMinInterval)
+	}
+	if t.ReportMaxInterval < 0 {
+		return fmt.Errorf("maximum report interval must not be negative, not %s", t.ReportMaxInterval)
+	}
+	if t.HeadingEpsilon < 0 || t.SpeedEpsilon < 0 || t.VSEpsilon < 0 || t.AltitudeEpsilon < 0 ||
+		t.DistanceEpsilonNM < 0 {
+		return fmt.Errorf("epsilons must not be negative")
+	}
+	if t.SimplifyToleranceM < 0 {
+		return fmt.Errorf("simplify tolerance must not be negative, not %g", t.SimplifyToleranceM)
+	}
+	return nil
+}
+
+// apply returns a copy of t with the overrides applied.
+func (o Overrides) apply(t Thresholds) Thresholds {
+	if o.ReportMinInterval != nil {
+		t.ReportMinInterval = o.ReportMinInterval.Duration
+	}
+	if o.ReportMaxInterval != nil {
+		t.ReportMaxInterval = o.ReportMaxInterval.Duration
+	}
+	if o.HeadingEpsilon != nil {
+		t.HeadingEpsilon = *o.HeadingEpsilon
+	}
+	if o.SpeedEpsilon != nil {
+		t.SpeedEpsilon = *o.SpeedEpsilon
+	}
+	if o.VSEpsilon != nil {
+		t.VSEpsilon = *o.VSEpsilon
+	}
+	if o.AltitudeEpsilon != nil {
+		t.AltitudeEpsilon = *o.AltitudeEpsilon
+	}
+	if o.DistanceEpsilonNM != nil {
+		t.DistanceEpsilonNM = *o.DistanceEpsilonNM
+	}
+	if o.SimplifyToleranceM != nil {
+		t.SimplifyToleranceM = *o.SimplifyToleranceM
+	}
+	return t
+}
+
+// Duration is a time.Duration that is written in configuration files as a
+// string such as "30s" or "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// configFile is the JSON configuration file format. Every field is
+// optional. For example:
+//
+//	{
+//	  "decay_time": "10m",
+//	  "report_max_interval": "60s",
+//	  "categories": {
+//	    "rotorcraft": {"heading_epsilon": 5, "distance_epsilon_nm": 1}
+//	  }
+//	}
+type configFile struct {
+	SweepInterval  *Duration `json:"sweep_interval"`
+	DecayTime      *Duration `json:"decay_time"`
+	ForceReporting *bool     `json:"force_reporting"`
+	Simplify       *bool     `json:"simplify"`
+	SimplifyWindow *int      `json:"simplify_window"`
+	Overrides
+	Categories map[string]Overrides `json:"categories"`
+}
+
+// LoadConfig reads a JSON configuration file. Settings missing from the file
+// keep their values from base. The result is validated.
+func LoadConfig(filename string, base Config) (Config, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return base, err
+	}
+	defer f.Close()
+
+	var file configFile
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err = d.Decode(&file); err != nil {
+		return base, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	cfg := base
+	if file.SweepInterval != nil {
+		cfg.SweepInterval = file.SweepInterval.Duration
+	}
+	if file.DecayTime != nil {
+		cfg.DecayTime = file.DecayTime.Duration
+	}
+	if file.ForceReporting != nil {
+		cfg.ForceReporting = *file.ForceReporting
+	}
+	if file.Simplify != nil {
+		cfg.Simplify = *file.Simplify
+	}
+	if file.SimplifyWindow != nil {
+		cfg.SimplifyWindow = *file.SimplifyWindow
+	}
+	cfg.Thresholds = file.Overrides.apply(cfg.Thresholds)
+
+	if len(file.Categories) > 0 {
+		cfg.Categories = make(map[decoder.AircraftType]Overrides)
+		for k, v := range base.Categories {
+			cfg.Categories[k] = v
+		}
+		for name, o := range file.Categories {
+			category, err := decoder.ParseAircraftType(name)
+			if err != nil {
+				return base, fmt.Errorf("%s: %v", filename, err)
+			}
+			cfg.Categories[category] = o
+		}
+	}
+	if err = cfg.Validate(); err != nil {
+		return base, fmt.Errorf("%s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// ConfigFlags are command line flags for the tracker configuration, all
+// named tracker.something so they can't collide with a program's own flags.
+// The -tracker.config flag names a configuration file; the other flags
+// override individual settings from the defaults or that file.
+type ConfigFlags struct {
+	fs    *flag.FlagSet
+	file  string
+	flags Config
+}
+
+// RegisterFlags adds the tracker configuration flags to fs.
+func RegisterFlags(fs *flag.FlagSet) *ConfigFlags {
+	cf := &ConfigFlags{fs: fs, flags: DefaultConfig()}
+	fs.StringVar(&cf.file, "tracker.config", "", "Load tracker thresholds from JSON `file`")
+	for _, f := range configFlags {
+		switch p := f.field(&cf.flags).(type) {
+		case *time.Duration:
+			fs.DurationVar(p, f.name, *p, f.usage)
+		case *int:
+			fs.IntVar(p, f.name, *p, f.usage)
+		case *float64:
+			fs.Float64Var(p, f.name, *p, f.usage)
+		case *bool:
+			fs.BoolVar(p, f.name, *p, f.usage)
+		}
+	}
+	return cf
+}
+
+// Config returns the configuration described by the parsed flags, or an
+// error if it isn't valid.
+func (cf *ConfigFlags) Config() (Config, error) {
+	if cf.file == "" {
+		return cf.flags, cf.flags.Validate()
+	}
+
+	cfg, err := LoadConfig(cf.file, DefaultConfig())
+	if err != nil {
+		return cfg, err
+	}
+
+	// Flags given explicitly on the command line win over the file.
+	byName := make(map[string]configFlag)
+	for _, f := range configFlags {
+		byName[f.name] = f
+	}
+	cf.fs.Visit(func(fl *flag.Flag) {
+		f, ok := byName[fl.Name]
+		if !ok {
+			return
+		}
+		switch p := f.field(&cfg).(type) {
+		case *time.Duration:
+			*p = *f.field(&cf.flags).(*time.Duration)
+		case *int:
+			*p = *f.field(&cf.flags).(*int)
+		case *float64:
+			*p = *f.field(&cf.flags).(*float64)
+		case *bool:
+			*p = *f.field(&cf.flags).(*bool)
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+type configFlag struct {
+	name  string
+	usage string
+	field func(*Config) interface{}
+}
+
+var configFlags = []configFlag{
+	{"tracker.sweep", "How often to look for idle flights",
+		func(c *Config) interface{} { return &c.SweepInterval }},
+	{"tracker.decay", "Close flights after this long without messages",
+		func(c *Config) interface{} { return &c.DecayTime }},
+	{"tracker.forcereport", "Report every change to an aircraft's state, ignoring thresholds",
+		func(c *Config) interface{} { return &c.ForceReporting }},
+	{"tracker.reportmin", "Minimum time between track log points",
+		func(c *Config) interface{} { return &c.ReportMinInterval }},
+	{"tracker.reportmax", "Report moving aircraft at least this often (0 to disable; ignored by -tracker.simplify)",
+		func(c *Config) interface{} { return &c.ReportMaxInterval }},
+	{"tracker.heading", "Heading change (degrees) that triggers a track log point",
+		func(c *Config) interface{} { return &c.HeadingEpsilon }},
+	{"tracker.speed", "Speed change (knots) that triggers a track log point",
+		func(c *Config) interface{} { return &c.SpeedEpsilon }},
+	{"tracker.vs", "Vertical speed change (fpm) that triggers a track log point",
+		func(c *Config) interface{} { return &c.VSEpsilon }},
+	{"tracker.altitude", "Altitude change (feet) that triggers a track log point",
+		func(c *Config) interface{} { return &c.AltitudeEpsilon }},
+	{"tracker.distance", "Distance (nm) that triggers a track log point",
+		func(c *Config) interface{} { return &c.DistanceEpsilonNM }},
+	{"tracker.simplify", "Buffer positions and store a simplified track instead of using change thresholds",
+		func(c *Config) interface{} { return &c.Simplify }},
+	{"tracker.tolerance", "Simplified tracks stay within this many meters of every received position",
+		func(c *Config) interface{} { return &c.SimplifyToleranceM }},
+	{"tracker.window", "Most positions to buffer per flight when simplifying",
+		func(c *Config) interface{} { return &c.SimplifyWindow }},
+}
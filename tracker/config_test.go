@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+func TestConfigFileAndFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackerconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tracker.json")
+	err = ioutil.WriteFile(filename, []byte(`{
+		"decay_time": "10m",
+		"heading_epsilon": 20,
+		"categories": {"rotorcraft": {"heading_epsilon": 5, "report_max_interval": "15s"}}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := RegisterFlags(fs)
+	if err = fs.Parse([]string{"-tracker.config", filename, "-tracker.decay", "2m"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := cf.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DecayTime != 2*time.Minute {
+		t.Errorf("Decay time %s, flag should win over file", cfg.DecayTime)
+	}
+	if cfg.HeadingEpsilon != 20 {
+		t.Errorf("Heading epsilon %d, should be 20 from file", cfg.HeadingEpsilon)
+	}
+	if cfg.SpeedEpsilon != DefaultConfig().SpeedEpsilon {
+		t.Errorf("Speed epsilon %d, should be default", cfg.SpeedEpsilon)
+	}
+
+	tracker := New(new(handler), cfg)
+	heli := tracker.thresholdsFor(&flight{Category: decoder.ACTypeRotocraft})
+	if heli.HeadingEpsilon != 5 || heli.ReportMaxInterval != 15*time.Second || heli.SpeedEpsilon != cfg.SpeedEpsilon {
+		t.Errorf("Unexpected rotorcraft thresholds %+v", heli)
+	}
+	jet := tracker.thresholdsFor(&flight{Category: decoder.ACTypeLarge})
+	if jet.HeadingEpsilon != 20 {
+		t.Errorf("Unexpected default thresholds %+v", jet)
+	}
+}
+
+func TestConfigUnknownCategory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackerconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tracker.json")
+	ioutil.WriteFile(filename, []byte(`{"categories": {"zeppelin": {}}}`), 0644)
+
+	if _, err := LoadConfig(filename, DefaultConfig()); err == nil {
+		t.Errorf("Expected error for unknown category")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-tracker.decay", "0s"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cf.Config(); err == nil {
+		t.Errorf("Expected error for a zero decay time")
+	}
+
+	dir, err := ioutil.TempDir("", "trackerconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tracker.json")
+	for _, file := range []string{
+		`{"sweep_interval": "-1s"}`,
+		`{"distance_epsilon_nm": -1}`,
+		`{"categories": {"rotorcraft": {"report_max_interval": "-5s"}}}`,
+	} {
+		ioutil.WriteFile(filename, []byte(file), 0644)
+		if _, err := LoadConfig(filename, DefaultConfig()); err == nil {
+			t.Errorf("Expected error for %s", file)
+		}
+	}
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Default configuration is invalid: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package tracker
+
+import (
+	"github.com/racingmars/flighttrack/metrics"
+)
+
+var (
+	messagesTracked = metrics.NewCounter("flighttrack_tracker_messages_total",
+		"Messages given to the tracker.")
+	flightsStarted = metrics.NewCounterVec("flighttrack_tracker_flights_started_total",
+		"Flights started, by why: new for an aircraft that wasn't being tracked, otherwise the split reason.",
+		"reason")
+	flightsClosed = metrics.NewCounter("flighttrack_tracker_flights_closed_total",
+		"Flights closed.")
+	trackPoints = metrics.NewCounter("flighttrack_tracker_track_points_total",
+		"Track log points reported.")
+	activeFlights = metrics.NewGauge("flighttrack_tracker_active_flights",
+		"Flights currently being tracked.")
+)
+
+// countFlightStarted records a new flight in the metrics.
+func countFlightStarted(reason SplitReason) {
+	if reason == SplitNone {
+		flightsStarted.With("new").Inc()
+	} else {
+		flightsStarted.With(string(reason)).Inc()
+	}
+}
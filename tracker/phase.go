@@ -0,0 +1,56 @@
+package tracker
+
+import "time"
+
+// Phase is the phase of flight an aircraft was in at a track log point.
+type Phase string
+
+const (
+	PhaseUnknown  Phase = ""
+	PhaseTaxi     Phase = "taxi"
+	PhaseTakeoff  Phase = "takeoff" // the takeoff roll, on the ground
+	PhaseClimb    Phase = "climb"
+	PhaseCruise   Phase = "cruise"
+	PhaseDescent  Phase = "descent"
+	PhaseApproach Phase = "approach"
+	PhaseLanding  Phase = "landing" // touchdown and rollout, on the ground
+)
+
+// Phase classification thresholds
+const taxiSpeedMax = 35
+const landingRollTime = 60 * time.Second
+const levelVSMax = 300
+const approachAltitude = 4000
+
+// classifyPhase determines the phase of flight for the flight's current
+// state. It relies on the ground/air tracking in updateGround, so a landing
+// is only recognized if the aircraft was seen airborne first.
+func classifyPhase(flt *flight, tm time.Time) Phase {
+	cur := flt.Current
+
+	if flt.GroundValid && flt.OnGround {
+		if !flt.LandedAt.IsZero() && tm.Sub(flt.LandedAt) < landingRollTime {
+			return PhaseLanding
+		}
+		if !flt.Flown && cur.SpeedValid && cur.Speed > taxiSpeedMax {
+			return PhaseTakeoff
+		}
+		return PhaseTaxi
+	}
+
+	if !flt.GroundValid || !cur.VSValid {
+		return PhaseUnknown
+	}
+
+	switch {
+	case cur.VS >= levelVSMax:
+		return PhaseClimb
+	case cur.VS <= -levelVSMax:
+		if cur.AltitudeValid && cur.Altitude < approachAltitude {
+			return PhaseApproach
+		}
+		return PhaseDescent
+	default:
+		return PhaseCruise
+	}
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"math"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// In simplify mode, instead of reporting whenever a value changes by more
+// than its epsilon, position fixes are buffered in the flight's Window and
+// reported with an "opening window" line simplification: the track from the
+// last reported point (the anchor) is extended one fix at a time for as long
+// as every buffered fix lies within the tolerance of the straight line from
+// the anchor to the newest fix. When a fix doesn't fit, the previous fix is
+// reported and becomes the new anchor. Like Douglas-Peucker, this guarantees
+// that every received position is within the tolerance of the stored track,
+// but it works incrementally so points are written as the flight progresses.
+
+// simplifyFix handles a new position fix for a flight in simplify mode.
+func (t *Tracker) simplifyFix(icaoID string, flt *flight, th Thresholds) {
+	point := flt.Current
+	point.Phase = classifyPhase(flt, point.Time)
+
+	if !flt.Last.PositionValid {
+		// Nothing to anchor a line to yet
+		flt.Window = nil
+		t.report(icaoID, flt, point.Time, true)
+		return
+	}
+
+	candidate := append(flt.Window, point)
+	full := t.config.SimplifyWindow > 0 && len(candidate) > t.config.SimplifyWindow
+
+	if withinTolerance(flt.Last, candidate, th) {
+		if !full {
+			flt.Window = candidate
+			flt.PendingChange = true
+			return
+		}
+	} else if len(flt.Window) > 0 {
+		// The new fix doesn't fit; the line up to the previous fix did.
+		t.emit(icaoID, flt, flt.Window[len(flt.Window)-1])
+		flt.Window = []TrackLog{point}
+		flt.PendingChange = true
+		return
+	}
+
+	t.emit(icaoID, flt, point)
+	flt.Window = nil
+}
+
+// flushWindow is called before a forced report of the current state. If the
+// line from the anchor to the current state would stray too far from the
+// buffered fixes, the last buffered fix is reported first.
+func (t *Tracker) flushWindow(icaoID string, flt *flight) {
+	if len(flt.Window) == 0 {
+		return
+	}
+	th := t.thresholdsFor(flt)
+	last := flt.Window[len(flt.Window)-1]
+	candidate := flt.Window
+	if flt.Current.PositionValid {
+		candidate = append(candidate, flt.Current)
+	}
+	if !withinTolerance(flt.Last, candidate, th) && last.Time.Before(flt.Current.Time) {
+		t.emit(icaoID, flt, last)
+	}
+	flt.Window = nil
+}
+
+// emit reports a (possibly earlier) point and makes it the new anchor. If
+// the point is the current state, nothing is left to report.
+func (t *Tracker) emit(icaoID string, flt *flight, point TrackLog) {
+	flt.Last = point
+	if point.Time.Equal(flt.Current.Time) {
+		flt.PendingChange = false
+	}
+	t.handlers.AddTrackPoint(icaoID, point)
+	trackPoints.Inc()
+}
+
+// withinTolerance checks that every point but the last is within the
+// tolerance of the line from anchor to the last point, both horizontally
+// and, where altitudes are known, vertically.
+func withinTolerance(anchor TrackLog, points []TrackLog, th Thresholds) bool {
+	end := points[len(points)-1]
+	span := end.Time.Sub(anchor.Time).Seconds()
+
+	for _, p := range points[:len(points)-1] {
+		if crossTrackM(anchor, end, p) > th.SimplifyToleranceM {
+			return false
+		}
+		if anchor.AltitudeValid && end.AltitudeValid && p.AltitudeValid && span > 0 {
+			frac := p.Time.Sub(anchor.Time).Seconds() / span
+			expected := float64(anchor.Altitude) + frac*float64(end.Altitude-anchor.Altitude)
+			if math.Abs(float64(p.Altitude)-expected) > float64(th.AltitudeEpsilon) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// crossTrackM is the distance in meters from p to the segment a-b. The
+// segments involved are short, so a flat projection around a is accurate
+// enough.
+func crossTrackM(a, b, p TrackLog) float64 {
+	scale := geo.EarthRadiusM * math.Pi / 180
+	coslat := math.Cos(a.Latitude * math.Pi / 180)
+	bx, by := (b.Longitude-a.Longitude)*coslat*scale, (b.Latitude-a.Latitude)*scale
+	px, py := (p.Longitude-a.Longitude)*coslat*scale, (p.Latitude-a.Latitude)*scale
+
+	lensq := bx*bx + by*by
+	if lensq == 0 {
+		return math.Hypot(px, py)
+	}
+	u := (px*bx + py*by) / lensq
+	if u < 0 {
+		u = 0
+	} else if u > 1 {
+		u = 1
+	}
+	return math.Hypot(px-u*bx, py-u*by)
+}
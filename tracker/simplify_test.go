@@ -0,0 +1,128 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+type pointHandler struct {
+	recordingHandler
+	points []TrackLog
+}
+
+func (h *pointHandler) AddTrackPoint(icaoID string, trackPoint TrackLog) {
+	h.points = append(h.points, trackPoint)
+}
+
+func TestSimplifyKeepsTrackWithinTolerance(t *testing.T) {
+	h := new(pointHandler)
+	config := DefaultConfig()
+	config.Simplify = true
+	tr := New(h, config)
+	th := tr.config.Thresholds
+
+	// Fly east for 60 fixes, then turn north for 60 fixes, roughly 100m
+	// between fixes with a little wobble.
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	lat, lon := 45.5, -122.9
+	var fixes []TrackLog
+	for i := 0; i < 120; i++ {
+		wobble := 0.0002 * float64(i%3-1)
+		if i < 60 {
+			lon += 0.00128
+			fixes = append(fixes, TrackLog{Time: tm, PositionValid: true, Latitude: lat + wobble, Longitude: lon})
+		} else {
+			lat += 0.0009
+			fixes = append(fixes, TrackLog{Time: tm, PositionValid: true, Latitude: lat, Longitude: lon + wobble})
+		}
+		tm = tm.Add(time.Second)
+	}
+
+	flt := &flight{IcaoID: "abcdef"}
+	tr.flights["abcdef"] = flt
+	for _, fix := range fixes {
+		flt.Current = fix
+		tr.simplifyFix("abcdef", flt, th)
+	}
+	tr.report("abcdef", flt, tm, true)
+
+	if len(h.points) >= len(fixes)/4 {
+		t.Errorf("Simplified to %d points from %d fixes; expected far fewer", len(h.points), len(fixes))
+	}
+
+	for _, fix := range fixes {
+		best := -1.0
+		for i := 1; i < len(h.points); i++ {
+			d := crossTrackM(h.points[i-1], h.points[i], fix)
+			if best < 0 || d < best {
+				best = d
+			}
+		}
+		if best > th.SimplifyToleranceM {
+			t.Errorf("Fix at %s is %.0fm from the simplified track", fix.Time.Format("15:04:05"), best)
+		}
+	}
+}
+
+// TestSimplifyStraightLeg checks that, with the default thresholds, a
+// straight leg is stored as just its two ends.
+func TestSimplifyStraightLeg(t *testing.T) {
+	h := new(pointHandler)
+	config := DefaultConfig()
+	config.Simplify = true
+	tr := New(h, config)
+
+	// Three minutes due east, a fix a second
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	flt := &flight{IcaoID: "abcdef"}
+	tr.flights["abcdef"] = flt
+	var fixes []TrackLog
+	for i := 0; i < 180; i++ {
+		fix := TrackLog{Time: tm, PositionValid: true, Latitude: 45.5, Longitude: -122.9 + 0.001*float64(i)}
+		fixes = append(fixes, fix)
+		flt.Current = fix
+		flt.PendingChange = true
+		tr.simplifyFix("abcdef", flt, tr.config.Thresholds)
+		tm = tm.Add(time.Second)
+	}
+	tr.CloseAllFlights()
+
+	if len(h.points) != 2 {
+		t.Fatalf("Straight leg stored as %d points, want 2", len(h.points))
+	}
+	first, last := fixes[0], fixes[len(fixes)-1]
+	if !h.points[0].Time.Equal(first.Time) || !h.points[1].Time.Equal(last.Time) {
+		t.Errorf("Straight leg stored as points at %s and %s, want its ends at %s and %s",
+			h.points[0].Time.Format("15:04:05"), h.points[1].Time.Format("15:04:05"),
+			first.Time.Format("15:04:05"), last.Time.Format("15:04:05"))
+	}
+}
+
+// TestSimplifyNoDuplicateAtClose checks that closing a flight whose latest
+// fix was just reported doesn't report it again.
+func TestSimplifyNoDuplicateAtClose(t *testing.T) {
+	h := new(pointHandler)
+	config := DefaultConfig()
+	config.Simplify = true
+	config.SimplifyWindow = 5
+	tr := New(h, config)
+
+	// A full window reports the newest fix
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	flt := &flight{IcaoID: "abcdef"}
+	tr.flights["abcdef"] = flt
+	for i := 0; i <= config.SimplifyWindow+1; i++ {
+		flt.Current = TrackLog{Time: tm, PositionValid: true, Latitude: 45.5, Longitude: -122.9 + 0.001*float64(i)}
+		flt.PendingChange = true
+		tr.simplifyFix("abcdef", flt, tr.config.Thresholds)
+		tm = tm.Add(time.Second)
+	}
+	if n := len(h.points); n != 2 || !h.points[1].Time.Equal(flt.Current.Time) {
+		t.Fatalf("%d points reported, want the first and the newest fix", n)
+	}
+	tr.CloseAllFlights()
+
+	if n := len(h.points); n != 2 {
+		t.Errorf("%d points after closing the flight, want 2: the newest fix was reported again", n)
+	}
+}
@@ -0,0 +1,165 @@
+package tracker
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+// rssiSamples is how many recent signal levels are averaged for the RSSI.
+const rssiSamples = 8
+
+// Aircraft is the current state of an aircraft being tracked. It is a copy;
+// it doesn't change as more messages are received.
+type Aircraft struct {
+	IcaoID       string
+	FirstSeen    time.Time // start of the current flight
+	LastSeen     time.Time
+	MessageCount int
+	Callsign     string // empty if not yet identified
+	Category     decoder.AircraftType
+
+	// PositionTime is when the position was last updated; zero if
+	// Current.PositionValid is false.
+	PositionTime time.Time
+
+	GroundValid bool
+	OnGround    bool
+
+	// RSSI is the average strength of the most recent messages in dBFS. It
+	// is only valid if RSSIValid is set, as not every source of messages
+	// records the signal level.
+	RSSIValid bool
+	RSSI      float64
+
+	// Current is the most recent value of every field, whether or not it
+	// has been reported as a track log point yet.
+	Current TrackLog
+}
+
+// EventType says what happened to an aircraft in an Event.
+type EventType int
+
+const (
+	// AircraftUpdated is sent after every message for an aircraft,
+	// including the first.
+	AircraftUpdated EventType = iota
+
+	// AircraftRemoved is sent when the tracker stops tracking an aircraft
+	// because it hasn't been heard from in the decay time.
+	AircraftRemoved
+)
+
+// Event is a change to the state of an aircraft, sent to subscribers.
+type Event struct {
+	Type     EventType
+	Aircraft Aircraft
+}
+
+// Snapshot returns the current state of every aircraft being tracked,
+// ordered by ICAO ID.
+func (t *Tracker) Snapshot() []Aircraft {
+	t.mu.Lock()
+	aircraft := make([]Aircraft, 0, len(t.flights))
+	for _, flt := range t.flights {
+		aircraft = append(aircraft, flt.snapshot())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(aircraft, func(i, j int) bool {
+		return aircraft[i].IcaoID < aircraft[j].IcaoID
+	})
+	return aircraft
+}
+
+// Subscribe returns a channel that receives an Event every time an aircraft
+// changes, and a function that cancels the subscription and closes the
+// channel. Events are dropped rather than holding up the tracker when the
+// channel's buffer is full, so a subscriber that falls behind should call
+// Snapshot to catch up.
+func (t *Tracker) Subscribe(buffer int) (<-chan Event, func()) {
+	c := make(chan Event, buffer)
+
+	t.subMu.Lock()
+	if t.subscribers == nil {
+		t.subscribers = make(map[int]chan Event)
+	}
+	id := t.nextSubscriber
+	t.nextSubscriber++
+	t.subscribers[id] = c
+	t.subMu.Unlock()
+
+	cancel := func() {
+		t.subMu.Lock()
+		defer t.subMu.Unlock()
+		if _, ok := t.subscribers[id]; ok {
+			delete(t.subscribers, id)
+			close(c)
+		}
+	}
+	return c, cancel
+}
+
+func (t *Tracker) publish(eventType EventType, flt *flight) {
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+	if len(t.subscribers) == 0 {
+		return
+	}
+	event := Event{Type: eventType, Aircraft: flt.snapshot()}
+	for _, c := range t.subscribers {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+func (flt *flight) snapshot() Aircraft {
+	a := Aircraft{
+		IcaoID:       flt.IcaoID,
+		FirstSeen:    flt.FirstSeen,
+		LastSeen:     flt.LastSeen,
+		MessageCount: flt.MessageCount,
+		Category:     flt.Category,
+		PositionTime: flt.PositionTime,
+		GroundValid:  flt.GroundValid,
+		OnGround:     flt.OnGround,
+		Current:      flt.Current,
+	}
+	if flt.Callsign != nil {
+		a.Callsign = *flt.Callsign
+	}
+	a.RSSI, a.RSSIValid = flt.rssi()
+	return a
+}
+
+// addSignal records the signal level of a message. Levels are the beast
+// format's 8-bit magnitude; 0 means the level is unknown.
+func (flt *flight) addSignal(level uint8) {
+	if level == 0 {
+		return
+	}
+	if len(flt.Signals) < rssiSamples {
+		flt.Signals = append(flt.Signals, level)
+		return
+	}
+	copy(flt.Signals, flt.Signals[1:])
+	flt.Signals[rssiSamples-1] = level
+}
+
+// rssi averages the power of the recent signal levels, in dBFS.
+func (flt *flight) rssi() (float64, bool) {
+	if len(flt.Signals) == 0 {
+		return 0, false
+	}
+	var power float64
+	for _, level := range flt.Signals {
+		mag := float64(level) / 255
+		power += mag * mag
+	}
+	power /= float64(len(flt.Signals))
+	return 10 * math.Log10(power), true
+}
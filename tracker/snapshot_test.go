@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+func TestSnapshot(t *testing.T) {
+	tracker := New(new(recordingHandler), DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.MessageWithSignal("abcdef", tm, 255, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tracker.MessageWithSignal("abcdef", tm.Add(time.Second), 255, &decoder.AdsbVelocity{Speed: 250, SpeedType: decoder.SpeedGS})
+	tracker.Message("123456", tm, nil)
+
+	snap := tracker.Snapshot()
+	if len(snap) != 2 || snap[0].IcaoID != "123456" || snap[1].IcaoID != "abcdef" {
+		t.Fatalf("Snapshot returned %v", snap)
+	}
+	a := snap[1]
+	if a.Callsign != "ASA123" || a.MessageCount != 2 || !a.LastSeen.Equal(tm.Add(time.Second)) {
+		t.Errorf("Wrong aircraft state %+v", a)
+	}
+	if !a.Current.SpeedValid || a.Current.Speed != 250 {
+		t.Errorf("Speed %d, should be 250", a.Current.Speed)
+	}
+	if !a.RSSIValid || math.Abs(a.RSSI) > 1e-9 {
+		t.Errorf("RSSI %f/%v, should be 0 dBFS", a.RSSI, a.RSSIValid)
+	}
+	if snap[0].RSSIValid {
+		t.Errorf("RSSI should be unknown without signal levels")
+	}
+
+	// Later messages don't change the snapshot we already have
+	tracker.Message("abcdef", tm.Add(2*time.Second), &decoder.AdsbVelocity{Speed: 300, SpeedType: decoder.SpeedGS})
+	if a.Current.Speed != 250 || a.MessageCount != 2 {
+		t.Errorf("Snapshot changed after a new message")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tracker := New(new(recordingHandler), DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	events, cancel := tracker.Subscribe(10)
+	tracker.Message("abcdef", tm, nil)
+	tracker.CloseAllFlights()
+
+	e := <-events
+	if e.Type != AircraftUpdated || e.Aircraft.IcaoID != "abcdef" {
+		t.Errorf("First event %+v, should be an update", e)
+	}
+	e = <-events
+	if e.Type != AircraftRemoved || e.Aircraft.IcaoID != "abcdef" {
+		t.Errorf("Second event %+v, should be a removal", e)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Channel should be closed after cancel")
+	}
+	cancel()
+	// Messages after cancelling shouldn't block or panic
+	tracker.Message("abcdef", tm, nil)
+}
+
+func TestConcurrentSnapshot(t *testing.T) {
+	tracker := New(new(recordingHandler), DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	events, cancel := tracker.Subscribe(1)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			tracker.Message("abcdef", tm.Add(time.Duration(i)*time.Second),
+				&decoder.AdsbVelocity{Speed: i % 300, SpeedType: decoder.SpeedGS})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		tracker.Snapshot()
+		tracker.GetState()
+		select {
+		case <-events:
+		default:
+		}
+	}
+	wg.Wait()
+
+	if snap := tracker.Snapshot(); len(snap) != 1 || snap[0].MessageCount != 1000 {
+		t.Errorf("Snapshot after concurrent updates %+v", snap)
+	}
+}
@@ -0,0 +1,338 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/rs/zerolog/log"
+)
+
+// Saved tracker state lets a tracker carry on where a previous one stopped.
+// The state is written in a versioned format that is independent of the
+// tracker's private flight struct, so the tracker can change without
+// breaking resumption from state saved by an older version.
+//
+// Version 1 was the flight map marshaled directly to JSON, with no version
+// number. Version 2 wraps an explicit list of FlightState in a State, and
+// may be encoded as JSON or, more compactly, with gob behind a short header.
+// Every older version is migrated to the current one when it is decoded.
+
+// StateVersion is the version of the state written by this tracker.
+const StateVersion = 2
+
+// StateFormat is an encoding for tracker state.
+type StateFormat int
+
+const (
+	StateJSON StateFormat = iota
+	StateBinary
+)
+
+func (f StateFormat) String() string {
+	switch f {
+	case StateJSON:
+		return "json"
+	case StateBinary:
+		return "binary"
+	}
+	return fmt.Sprintf("StateFormat(%d)", int(f))
+}
+
+// ParseStateFormat returns the format with the given name, as returned by
+// String.
+func ParseStateFormat(name string) (StateFormat, error) {
+	switch name {
+	case "json":
+		return StateJSON, nil
+	case "binary":
+		return StateBinary, nil
+	}
+	return StateJSON, fmt.Errorf("unknown state format %q (want json or binary)", name)
+}
+
+// binaryStateMagic starts binary state, followed by a one byte version.
+const binaryStateMagic = "FTST"
+
+// State is the saved state of a tracker.
+type State struct {
+	Version int           `json:"version"`
+	Flights []FlightState `json:"flights"`
+}
+
+// FlightState is the saved state of one flight.
+type FlightState struct {
+	IcaoID        string               `json:"icao"`
+	FirstSeen     time.Time            `json:"first_seen"`
+	LastSeen      time.Time            `json:"last_seen"`
+	MessageCount  int                  `json:"messages"`
+	Callsign      *string              `json:"callsign,omitempty"`
+	Category      decoder.AircraftType `json:"category"`
+	Last          TrackLog             `json:"last"`
+	Current       TrackLog             `json:"current"`
+	EvenFrame     *CPRFrame            `json:"even,omitempty"`
+	OddFrame      *CPRFrame            `json:"odd,omitempty"`
+	PendingChange bool                 `json:"pending,omitempty"`
+	GroundValid   bool                 `json:"ground_valid,omitempty"`
+	OnGround      bool                 `json:"on_ground,omitempty"`
+	Flown         bool                 `json:"flown,omitempty"`
+	LandedAt      time.Time            `json:"landed_at"`
+	SurfaceMsgs   bool                 `json:"surface,omitempty"`
+	Window        []TrackLog           `json:"window,omitempty"`
+	PositionTime  time.Time            `json:"position_time"`
+	Signals       []uint8              `json:"signals,omitempty"`
+}
+
+// CPRFrame is a saved airborne position message, waiting to be paired with
+// one of the other frame type.
+type CPRFrame struct {
+	Timestamp time.Time `json:"time"`
+	TC        int       `json:"tc"`
+	SS        int       `json:"ss"`
+	Altitude  int       `json:"alt"`
+	Frame     int       `json:"frame"`
+	LatCPR    int       `json:"lat"`
+	LonCPR    int       `json:"lon"`
+}
+
+// GetState returns the tracker's state as JSON, or nil if it couldn't be
+// encoded.
+func (t *Tracker) GetState() []byte {
+	data, err := t.EncodeState(StateJSON)
+	if err != nil {
+		log.Error().Err(err).Msgf("Couldn't encode tracker state")
+		return nil
+	}
+	return data
+}
+
+// EncodeState returns the tracker's state in the given format, suitable for
+// NewWithState.
+func (t *Tracker) EncodeState(format StateFormat) ([]byte, error) {
+	t.mu.Lock()
+	state := State{Version: StateVersion, Flights: make([]FlightState, 0, len(t.flights))}
+	for _, flt := range t.flights {
+		state.Flights = append(state.Flights, flt.state())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(state.Flights, func(i, j int) bool {
+		return state.Flights[i].IcaoID < state.Flights[j].IcaoID
+	})
+	return state.Encode(format)
+}
+
+// Encode returns the state in the given format.
+func (s *State) Encode(format StateFormat) ([]byte, error) {
+	switch format {
+	case StateJSON:
+		return json.Marshal(s)
+	case StateBinary:
+		var buf bytes.Buffer
+		buf.WriteString(binaryStateMagic)
+		buf.WriteByte(byte(s.Version))
+		if err := gob.NewEncoder(&buf).Encode(s.Flights); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown state format %v", format)
+}
+
+// DecodeState decodes state in any format written by this or an older
+// tracker, migrating it to the current version. It also returns the format
+// and the version the state was written in.
+func DecodeState(data []byte) (state *State, format StateFormat, version int, err error) {
+	if bytes.HasPrefix(data, []byte(binaryStateMagic)) {
+		format = StateBinary
+		if len(data) < len(binaryStateMagic)+1 {
+			return nil, format, 0, errors.New("truncated tracker state")
+		}
+		version = int(data[len(binaryStateMagic)])
+		if version != StateVersion {
+			return nil, format, version, fmt.Errorf("unsupported binary tracker state version %d", version)
+		}
+		state = &State{Version: version}
+		r := bytes.NewReader(data[len(binaryStateMagic)+1:])
+		if err = gob.NewDecoder(r).Decode(&state.Flights); err != nil {
+			return nil, format, version, err
+		}
+		return state, format, version, nil
+	}
+
+	// Only JSON can be trimmed; any byte may be part of binary state.
+	data = bytes.TrimSpace(data)
+	format = StateJSON
+	var header struct {
+		Version *int `json:"version"`
+	}
+	if err = json.Unmarshal(data, &header); err != nil {
+		return nil, format, 0, err
+	}
+	if header.Version == nil {
+		// A version 1 flight map, keyed by ICAO ID, has no version.
+		state, err = migrateStateV1(data)
+		return state, format, 1, err
+	}
+
+	version = *header.Version
+	switch version {
+	case StateVersion:
+		state = new(State)
+		err = json.Unmarshal(data, state)
+	default:
+		err = fmt.Errorf("unsupported tracker state version %d", version)
+	}
+	if err != nil {
+		return nil, format, version, err
+	}
+	return state, format, version, nil
+}
+
+// flightV1 is the flight struct as it was marshaled in version 1 state.
+type flightV1 struct {
+	IcaoID        string
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	MessageCount  int
+	Callsign      *string
+	Category      decoder.AircraftType
+	Last          TrackLog
+	Current       TrackLog
+	EvenFrame     *decoder.AdsbPosition
+	OddFrame      *decoder.AdsbPosition
+	PendingChange bool
+	GroundValid   bool
+	OnGround      bool
+	Flown         bool
+	LandedAt      time.Time
+	SurfaceMsgs   bool
+	Window        []TrackLog
+	PositionTime  time.Time
+	Signals       []uint8
+}
+
+func migrateStateV1(data []byte) (*State, error) {
+	var flights map[string]*flightV1
+	if err := json.Unmarshal(data, &flights); err != nil {
+		return nil, fmt.Errorf("version 1 tracker state: %v", err)
+	}
+
+	state := &State{Version: StateVersion, Flights: make([]FlightState, 0, len(flights))}
+	for id, f := range flights {
+		if f == nil {
+			continue
+		}
+		if f.IcaoID == "" {
+			f.IcaoID = id
+		}
+		state.Flights = append(state.Flights, FlightState{
+			IcaoID:        f.IcaoID,
+			FirstSeen:     f.FirstSeen,
+			LastSeen:      f.LastSeen,
+			MessageCount:  f.MessageCount,
+			Callsign:      f.Callsign,
+			Category:      f.Category,
+			Last:          f.Last,
+			Current:       f.Current,
+			EvenFrame:     frameState(f.EvenFrame),
+			OddFrame:      frameState(f.OddFrame),
+			PendingChange: f.PendingChange,
+			GroundValid:   f.GroundValid,
+			OnGround:      f.OnGround,
+			Flown:         f.Flown,
+			LandedAt:      f.LandedAt,
+			SurfaceMsgs:   f.SurfaceMsgs,
+			Window:        f.Window,
+			PositionTime:  f.PositionTime,
+			Signals:       f.Signals,
+		})
+	}
+	sort.Slice(state.Flights, func(i, j int) bool {
+		return state.Flights[i].IcaoID < state.Flights[j].IcaoID
+	})
+	return state, nil
+}
+
+func (flt *flight) state() FlightState {
+	return FlightState{
+		IcaoID:        flt.IcaoID,
+		FirstSeen:     flt.FirstSeen,
+		LastSeen:      flt.LastSeen,
+		MessageCount:  flt.MessageCount,
+		Callsign:      flt.Callsign,
+		Category:      flt.Category,
+		Last:          flt.Last,
+		Current:       flt.Current,
+		EvenFrame:     frameState(flt.EvenFrame),
+		OddFrame:      frameState(flt.OddFrame),
+		PendingChange: flt.PendingChange,
+		GroundValid:   flt.GroundValid,
+		OnGround:      flt.OnGround,
+		Flown:         flt.Flown,
+		LandedAt:      flt.LandedAt,
+		SurfaceMsgs:   flt.SurfaceMsgs,
+		Window:        flt.Window,
+		PositionTime:  flt.PositionTime,
+		Signals:       flt.Signals,
+	}
+}
+
+func (fs FlightState) flight() *flight {
+	return &flight{
+		IcaoID:        fs.IcaoID,
+		FirstSeen:     fs.FirstSeen,
+		LastSeen:      fs.LastSeen,
+		MessageCount:  fs.MessageCount,
+		Callsign:      fs.Callsign,
+		Category:      fs.Category,
+		Last:          fs.Last,
+		Current:       fs.Current,
+		EvenFrame:     fs.EvenFrame.position(),
+		OddFrame:      fs.OddFrame.position(),
+		PendingChange: fs.PendingChange,
+		GroundValid:   fs.GroundValid,
+		OnGround:      fs.OnGround,
+		Flown:         fs.Flown,
+		LandedAt:      fs.LandedAt,
+		SurfaceMsgs:   fs.SurfaceMsgs,
+		Window:        fs.Window,
+		PositionTime:  fs.PositionTime,
+		Signals:       fs.Signals,
+	}
+}
+
+func frameState(p *decoder.AdsbPosition) *CPRFrame {
+	if p == nil {
+		return nil
+	}
+	return &CPRFrame{
+		Timestamp: p.Timestamp,
+		TC:        p.TC,
+		SS:        p.SS,
+		Altitude:  p.Altitude,
+		Frame:     p.Frame,
+		LatCPR:    p.LatCPR,
+		LonCPR:    p.LonCPR,
+	}
+}
+
+func (f *CPRFrame) position() *decoder.AdsbPosition {
+	if f == nil {
+		return nil
+	}
+	return &decoder.AdsbPosition{
+		Timestamp: f.Timestamp,
+		TC:        f.TC,
+		SS:        f.SS,
+		Altitude:  f.Altitude,
+		Frame:     f.Frame,
+		LatCPR:    f.LatCPR,
+		LonCPR:    f.LonCPR,
+	}
+}
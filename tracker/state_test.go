@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+func trackerWithFlights() *Tracker {
+	tracker := New(new(recordingHandler), DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	tracker.MessageWithSignal("abcdef", tm, 120, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tracker.Message("abcdef", tm.Add(time.Second), &decoder.AdsbPosition{Timestamp: tm, Altitude: 3000, Frame: 0, LatCPR: 93000, LonCPR: 51372})
+	fly(tracker, tm.Add(2*time.Second), time.Minute, 180)
+	tracker.Message("123456", tm, nil)
+	return tracker
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	tracker := trackerWithFlights()
+	for _, format := range []StateFormat{StateJSON, StateBinary} {
+		data, err := tracker.EncodeState(format)
+		if err != nil {
+			t.Fatalf("Encoding %v: %v", format, err)
+		}
+		resumed, err := NewWithState(new(recordingHandler), DefaultConfig(), data)
+		if err != nil {
+			t.Fatalf("Resuming from %v: %v", format, err)
+		}
+		if !reflect.DeepEqual(resumed.Snapshot(), tracker.Snapshot()) {
+			t.Errorf("Resumed %v state differs:\n%+v\n%+v", format, resumed.Snapshot(), tracker.Snapshot())
+		}
+		if !reflect.DeepEqual(resumed.flights["abcdef"].EvenFrame, tracker.flights["abcdef"].EvenFrame) {
+			t.Errorf("CPR frame lost in %v state", format)
+		}
+		_, gotFormat, version, _ := DecodeState(data)
+		if gotFormat != format || version != StateVersion {
+			t.Errorf("Decoded as %v version %d, should be %v version %d", gotFormat, version, format, StateVersion)
+		}
+	}
+}
+
+func TestStateMigrateV1(t *testing.T) {
+	tracker := trackerWithFlights()
+
+	// Version 1 state was the flight map marshaled directly
+	v1, err := json.Marshal(tracker.flights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, format, version, err := DecodeState(v1)
+	if err != nil {
+		t.Fatalf("Decoding version 1 state: %v", err)
+	}
+	if format != StateJSON || version != 1 || state.Version != StateVersion {
+		t.Errorf("Decoded as %v version %d into version %d", format, version, state.Version)
+	}
+
+	resumed, err := NewWithState(new(recordingHandler), DefaultConfig(), v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed.Snapshot(), tracker.Snapshot()) {
+		t.Errorf("Migrated state differs:\n%+v\n%+v", resumed.Snapshot(), tracker.Snapshot())
+	}
+}
+
+func TestStateUnknownVersion(t *testing.T) {
+	if _, _, _, err := DecodeState([]byte(`{"version": 99, "flights": []}`)); err == nil {
+		t.Errorf("Decoded a future version")
+	}
+	if _, _, _, err := DecodeState([]byte("FTST\x63")); err == nil {
+		t.Errorf("Decoded a future binary version")
+	}
+}
+
+func TestStateWhitespace(t *testing.T) {
+	data := fmt.Sprintf("\n {\"version\": %d, \"flights\": []}\n", StateVersion)
+	if _, format, _, err := DecodeState([]byte(data)); err != nil || format != StateJSON {
+		t.Errorf("JSON state with surrounding whitespace: %v, %v", format, err)
+	}
+	// A binary version byte of 10 is a newline, which mustn't be trimmed
+	if _, _, version, _ := DecodeState([]byte("FTST\n")); version != 10 {
+		t.Errorf("Binary state version %d, want 10", version)
+	}
+}
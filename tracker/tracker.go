@@ -1,37 +1,75 @@
 package tracker
 
 import (
-	"encoding/json"
 	"math"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/geo"
 	"github.com/rs/zerolog/log"
 )
 
-const sweepInterval = 30 * time.Second
-const decayTime = 5 * time.Minute
-const reportMinInterval = 5 * time.Second
-
-const headingEpsilon = 10
-const speedEpsilon = 10
-const vsEpsilon = 150
-const altitudeEpsilon = 200
-const distanceEpsilonNM = 10
+// Ground/air detection thresholds. Aircraft without an air/ground switch
+// report airborne positions while taxiing, so a slow aircraft is considered
+// to be on the ground, and a fast one airborne, regardless of message type.
+const groundSpeedMax = 40
+const airSpeedMin = 60
+
+// A landing only ends a flight if the aircraft stays on the ground for a
+// while; touch-and-goes and full-stop taxi-backs in the pattern are one
+// flight.
+const minGroundTime = 2 * time.Minute
+const groundStopTime = 10 * time.Minute
+
+// SplitReason records why a new flight was started for an aircraft we were
+// already tracking.
+type SplitReason string
+
+const (
+	// SplitNone is a flight for an aircraft we weren't already tracking.
+	SplitNone SplitReason = ""
+
+	// SplitGap is a flight started after no messages were received for the
+	// aircraft for longer than the decay time.
+	SplitGap SplitReason = "gap"
+
+	// SplitLanding is a flight started after the aircraft landed and stayed
+	// on the ground.
+	SplitLanding SplitReason = "landing"
+
+	// SplitTakeoff is a flight started when the aircraft took off again
+	// after landing.
+	SplitTakeoff SplitReason = "takeoff"
+
+	// SplitCallsign is a flight started when the aircraft changed callsign
+	// on the ground after landing.
+	SplitCallsign SplitReason = "callsign"
+)
 
 type FlightHandler interface {
-	NewFlight(icaoID string, firstSeen time.Time)
+	NewFlight(icaoID string, firstSeen time.Time, reason SplitReason)
 	CloseFlight(icaoID string, lastSeen time.Time, messages int)
 	SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool)
 	AddTrackPoint(icaoID string, trackPoint TrackLog)
 }
 
+// Tracker follows aircraft from their messages. It is safe for concurrent
+// use, but messages must be supplied in time order, and FlightHandler
+// methods are called with the tracker locked so they must not call back
+// into the tracker.
 type Tracker struct {
-	ForceReporting bool
-	flights        map[string]*flight
-	handlers       FlightHandler
-	nextSweep      time.Time
+	mu         sync.Mutex
+	config     Config
+	thresholds map[decoder.AircraftType]Thresholds
+	flights    map[string]*flight
+	handlers   FlightHandler
+	nextSweep  time.Time
+
+	subMu          sync.Mutex
+	subscribers    map[int]chan Event
+	nextSubscriber int
 }
 
 type flight struct {
@@ -46,6 +84,14 @@ type flight struct {
 	EvenFrame     *decoder.AdsbPosition
 	OddFrame      *decoder.AdsbPosition
 	PendingChange bool
+	GroundValid   bool
+	OnGround      bool
+	Flown         bool       // has been airborne during this flight
+	LandedAt      time.Time  // zero unless on the ground after being airborne
+	SurfaceMsgs   bool       // most recent position was a surface position
+	Window        []TrackLog // buffered fixes in simplify mode
+	PositionTime  time.Time  // time of the most recent position fix
+	Signals       []uint8    // recent signal levels, oldest first
 }
 
 type TrackLog struct {
@@ -68,38 +114,89 @@ type TrackLog struct {
 	IdentityValid bool
 	Callsign      string
 	Category      decoder.AircraftType
+	Phase         Phase
 }
 
-func New(handler FlightHandler, forceReporting bool) *Tracker {
+func New(handler FlightHandler, config Config) *Tracker {
 	t := new(Tracker)
 	t.flights = make(map[string]*flight)
 	t.handlers = handler
-	t.ForceReporting = forceReporting
+	t.setConfig(config)
 	return t
 }
 
-func NewWithState(handler FlightHandler, forceReporting bool, trackerstate []byte) (*Tracker, error) {
+// NewWithState creates a tracker that carries on from state returned by
+// GetState or EncodeState, in any format or version.
+func NewWithState(handler FlightHandler, config Config, trackerstate []byte) (*Tracker, error) {
 	t := new(Tracker)
-	var flights map[string]*flight
-	err := json.Unmarshal(trackerstate, &flights)
+	state, _, _, err := DecodeState(trackerstate)
 	if err != nil {
 		return nil, err
 	}
-	t.flights = flights
+	t.flights = make(map[string]*flight)
+	for _, fs := range state.Flights {
+		t.flights[fs.IcaoID] = fs.flight()
+	}
 	t.handlers = handler
-	t.ForceReporting = forceReporting
+	t.setConfig(config)
+	activeFlights.Set(float64(len(t.flights)))
 	return t, nil
 }
 
+func (t *Tracker) setConfig(config Config) {
+	t.config = config
+	t.thresholds = make(map[decoder.AircraftType]Thresholds)
+	for category, overrides := range config.Categories {
+		t.thresholds[category] = overrides.apply(config.Thresholds)
+	}
+}
+
+// thresholdsFor returns the reporting thresholds for the flight's category.
+func (t *Tracker) thresholdsFor(flt *flight) Thresholds {
+	if th, ok := t.thresholds[flt.Category]; ok {
+		return th
+	}
+	return t.config.Thresholds
+}
+
+// dueForReport is true if the aircraft is moving and hasn't been reported
+// for longer than the maximum report interval.
+func (t *Tracker) dueForReport(flt *flight, th Thresholds) bool {
+	if th.ReportMaxInterval == 0 || flt.Last.Time.IsZero() {
+		return false
+	}
+	moving := flt.Current.SpeedValid && flt.Current.Speed > 0
+	return moving && flt.Current.Time.Sub(flt.Last.Time) >= th.ReportMaxInterval
+}
+
+// Message updates the aircraft's state with a decoded message received at
+// tm. The signal level of the message is unknown.
 func (t *Tracker) Message(icaoID string, tm time.Time, msg interface{}) {
+	t.MessageWithSignal(icaoID, tm, 0, msg)
+}
+
+// MessageWithSignal is Message for a message whose signal level is known, as
+// the 8-bit magnitude from the beast format.
+func (t *Tracker) MessageWithSignal(icaoID string, tm time.Time, signal uint8, msg interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messagesTracked.Inc()
 	flt, ok := t.flights[icaoID]
 	if !ok {
 		flt = &flight{IcaoID: icaoID, FirstSeen: tm}
 		t.flights[icaoID] = flt
-		t.handlers.NewFlight(icaoID, tm)
+		t.handlers.NewFlight(icaoID, tm, SplitNone)
+		countFlightStarted(SplitNone)
+	} else if tm.Sub(flt.LastSeen) > t.config.DecayTime {
+		// The sweep hasn't caught up with this one yet
+		flt = t.split(icaoID, flt, tm, SplitGap)
+	} else if reason := t.checkSplit(flt, tm, msg); reason != SplitNone {
+		flt = t.split(icaoID, flt, tm, reason)
 	}
 	flt.LastSeen = tm
 	flt.MessageCount++
+	flt.addSignal(signal)
 
 	if msg != nil {
 		switch v := msg.(type) {
@@ -109,20 +206,181 @@ func (t *Tracker) Message(icaoID string, tm time.Time, msg interface{}) {
 			t.handleAdsbVelocity(icaoID, flt, tm, v)
 		case *decoder.AdsbPosition:
 			t.handleAdsbPosition(icaoID, flt, tm, v)
+		case *decoder.AdsbSurfacePosition:
+			t.handleAdsbSurfacePosition(icaoID, flt, tm, v)
 		}
 	}
 
+	t.updateGround(icaoID, flt, tm, msg)
+	t.publish(AircraftUpdated, flt)
+
 	t.sweepIfNeeded(tm)
+	activeFlights.Set(float64(len(t.flights)))
+}
+
+// checkSplit decides whether msg belongs to a new flight rather than the
+// aircraft's current one.
+func (t *Tracker) checkSplit(flt *flight, tm time.Time, msg interface{}) SplitReason {
+	if flt.LandedAt.IsZero() {
+		return SplitNone
+	}
+
+	if ident, ok := msg.(*decoder.AdsbIdentification); ok && flt.Callsign != nil &&
+		*flt.Callsign != ident.Callsign && !strings.Contains(ident.Callsign, "#") {
+		return SplitCallsign
+	}
+
+	onGround := tm.Sub(flt.LandedAt)
+	if groundState(flt, msg) == groundAir && onGround >= minGroundTime {
+		return SplitTakeoff
+	}
+	if onGround >= groundStopTime {
+		return SplitLanding
+	}
+	return SplitNone
+}
+
+// split closes the aircraft's current flight and starts a new one. The new
+// flight keeps the aircraft's current state, so positions and identity carry
+// over without waiting for new messages.
+func (t *Tracker) split(icaoID string, flt *flight, tm time.Time, reason SplitReason) *flight {
+	if flt.PendingChange {
+		t.report(icaoID, flt, flt.LastSeen, true)
+	}
+	t.handlers.CloseFlight(icaoID, flt.LastSeen, flt.MessageCount)
+	flightsClosed.Inc()
+
+	newflt := &flight{
+		IcaoID:       icaoID,
+		FirstSeen:    tm,
+		Category:     flt.Category,
+		Current:      flt.Current,
+		EvenFrame:    flt.EvenFrame,
+		OddFrame:     flt.OddFrame,
+		GroundValid:  flt.GroundValid,
+		OnGround:     flt.OnGround,
+		SurfaceMsgs:  flt.SurfaceMsgs,
+		PositionTime: flt.PositionTime,
+		Signals:      flt.Signals,
+	}
+	if reason == SplitGap {
+		// After a long gap, nothing we knew is current anymore.
+		newflt = &flight{IcaoID: icaoID, FirstSeen: tm}
+	}
+	t.flights[icaoID] = newflt
+	t.handlers.NewFlight(icaoID, tm, reason)
+	countFlightStarted(reason)
+
+	if reason != SplitGap && reason != SplitCallsign && flt.Callsign != nil {
+		newflt.Callsign = flt.Callsign
+		t.handlers.SetIdentity(icaoID, *newflt.Callsign, newflt.Category, false)
+	}
+	if newflt.Current.Time.IsZero() {
+		return newflt
+	}
+	newflt.Current.Time = tm
+	t.report(icaoID, newflt, tm, true)
+	return newflt
+}
+
+type groundStatus int
+
+const (
+	groundUnknown groundStatus = iota
+	groundOnGround
+	groundAir
+)
+
+// groundState estimates whether the aircraft is on the ground, in light of
+// msg. Surface position messages are authoritative; otherwise, speed is used
+// for aircraft that can't hover.
+func groundState(flt *flight, msg interface{}) groundStatus {
+	if _, ok := msg.(*decoder.AdsbSurfacePosition); ok {
+		return groundOnGround
+	}
+
+	surface := flt.SurfaceMsgs
+	if _, ok := msg.(*decoder.AdsbPosition); ok {
+		surface = false
+	}
+	if surface {
+		// Still sending surface positions, even if fast on the takeoff roll
+		return groundOnGround
+	}
+
+	speedValid := flt.Current.SpeedValid && flt.Current.SpeedType == decoder.SpeedGS
+	speed := flt.Current.Speed
+	if v, ok := msg.(*decoder.AdsbVelocity); ok && v.SpeedType == decoder.SpeedGS {
+		speedValid = true
+		speed = v.Speed
+	}
+
+	canHover := flt.Category == decoder.ACTypeRotocraft || flt.Category == decoder.ACTypeLighterThanAir
+	if speedValid && speed <= groundSpeedMax && !canHover {
+		return groundOnGround
+	}
+	if speedValid && speed >= airSpeedMin {
+		return groundAir
+	}
+	if _, ok := msg.(*decoder.AdsbPosition); ok && (canHover || !speedValid) {
+		// The transponder says it's airborne and we have no reason to doubt
+		return groundAir
+	}
+	return groundUnknown
+}
+
+// updateGround tracks ground/air transitions for the flight. A transition
+// forces a track log report so the takeoff and landing points are recorded.
+func (t *Tracker) updateGround(icaoID string, flt *flight, tm time.Time, msg interface{}) {
+	switch msg.(type) {
+	case *decoder.AdsbSurfacePosition:
+		flt.SurfaceMsgs = true
+	case *decoder.AdsbPosition:
+		flt.SurfaceMsgs = false
+	}
+
+	state := groundState(flt, msg)
+	if state == groundUnknown {
+		return
+	}
+	onGround := state == groundOnGround
+
+	if flt.GroundValid && flt.OnGround == onGround {
+		return
+	}
+	changed := flt.GroundValid
+
+	flt.GroundValid = true
+	flt.OnGround = onGround
+	if onGround {
+		if flt.Flown {
+			flt.LandedAt = tm
+		}
+	} else {
+		flt.Flown = true
+		flt.LandedAt = time.Time{}
+	}
+
+	if changed && !flt.Current.Time.IsZero() {
+		flt.Current.Time = tm
+		t.report(icaoID, flt, tm, true)
+	}
 }
 
 func (t *Tracker) CloseAllFlights() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	for id := range t.flights {
 		if t.flights[id].PendingChange {
 			t.report(id, t.flights[id], t.flights[id].LastSeen, true)
 		}
 		t.handlers.CloseFlight(id, t.flights[id].LastSeen, t.flights[id].MessageCount)
+		flightsClosed.Inc()
+		t.publish(AircraftRemoved, t.flights[id])
 		delete(t.flights, id)
 	}
+	activeFlights.Set(0)
 }
 
 func (t *Tracker) handleAdsbIdentification(icaoID string, flt *flight, tm time.Time, msg *decoder.AdsbIdentification) {
@@ -172,6 +430,7 @@ func (t *Tracker) handleAdsbIdentification(icaoID string, flt *flight, tm time.T
 }
 
 func (t *Tracker) handleAdsbVelocity(icaoID string, flt *flight, tm time.Time, msg *decoder.AdsbVelocity) {
+	th := t.thresholdsFor(flt)
 	reportable := false
 	flt.Current.Time = tm
 
@@ -193,7 +452,7 @@ func (t *Tracker) handleAdsbVelocity(icaoID string, flt *flight, tm time.Time, m
 			newHeading = newHeading - 360
 		}
 		difference := int(math.Abs((float64(newHeading - oldHeading))))
-		if difference > headingEpsilon {
+		if difference > th.HeadingEpsilon {
 			reportable = true
 		}
 		if difference > 0 {
@@ -212,7 +471,7 @@ func (t *Tracker) handleAdsbVelocity(icaoID string, flt *flight, tm time.Time, m
 		flt.Current.Speed = msg.Speed
 		flt.Current.SpeedType = msg.SpeedType
 		difference := int(math.Abs((float64(flt.Current.Speed - flt.Last.Speed))))
-		if difference > speedEpsilon {
+		if difference > th.SpeedEpsilon {
 			reportable = true
 		}
 		if difference > 0 {
@@ -233,7 +492,7 @@ func (t *Tracker) handleAdsbVelocity(icaoID string, flt *flight, tm time.Time, m
 	} else if msg.VerticalRateAvailable {
 		flt.Current.VS = vs
 		difference := int(math.Abs((float64(flt.Current.VS - flt.Last.VS))))
-		if difference > vsEpsilon {
+		if difference > th.VSEpsilon {
 			reportable = true
 		}
 		if difference > 0 {
@@ -241,13 +500,42 @@ func (t *Tracker) handleAdsbVelocity(icaoID string, flt *flight, tm time.Time, m
 		}
 	}
 
-	if reportable {
+	if t.config.Simplify && flt.Current.PositionValid {
+		// Velocity changes are picked up by the next simplified position.
+		return
+	}
+	if reportable || t.dueForReport(flt, th) {
 		t.report(icaoID, flt, tm, false)
 	}
 }
 
+func (t *Tracker) handleAdsbSurfacePosition(icaoID string, flt *flight, tm time.Time, msg *decoder.AdsbSurfacePosition) {
+	flt.Current.Time = tm
+
+	if msg.TrackValid {
+		if !flt.Current.HeadingValid || flt.Current.Heading != msg.Track {
+			flt.PendingChange = true
+		}
+		flt.Current.HeadingValid = true
+		flt.Current.Heading = msg.Track
+	}
+	if msg.MovementValid {
+		speed := int(math.Round(msg.Speed))
+		if !flt.Current.SpeedValid || flt.Current.Speed != speed {
+			flt.PendingChange = true
+		}
+		flt.Current.SpeedValid = true
+		flt.Current.Speed = speed
+		flt.Current.SpeedType = decoder.SpeedGS
+	}
+	// Surface positions need a nearby reference position to decode; we
+	// only use the ground status and movement.
+}
+
 func (t *Tracker) handleAdsbPosition(icaoID string, flt *flight, tm time.Time, msg *decoder.AdsbPosition) {
+	th := t.thresholdsFor(flt)
 	reportable := false
+	newFix := false
 	flt.Current.Time = tm
 
 	if !flt.Current.AltitudeValid {
@@ -257,7 +545,7 @@ func (t *Tracker) handleAdsbPosition(icaoID string, flt *flight, tm time.Time, m
 	}
 	flt.Current.Altitude = msg.Altitude
 	difference := int(math.Abs((float64(flt.Current.Altitude - flt.Last.Altitude))))
-	if difference > altitudeEpsilon {
+	if difference > th.AltitudeEpsilon {
 		reportable = true
 	}
 	if difference > 0 {
@@ -276,6 +564,8 @@ func (t *Tracker) handleAdsbPosition(icaoID string, flt *flight, tm time.Time, m
 		}
 		if timediff < 5*time.Second {
 			if lat, lon, good := decoder.CalcPosition(*flt.OddFrame, *flt.EvenFrame); good {
+				newFix = true
+				flt.PositionTime = tm
 				flt.Current.PositionValid = true
 				flt.Current.Longitude = lon
 				flt.Current.Latitude = lat
@@ -286,7 +576,7 @@ func (t *Tracker) handleAdsbPosition(icaoID string, flt *flight, tm time.Time, m
 					reportable = true
 					flt.PendingChange = true
 				} else {
-					if math.Abs(distanceNM(lat, flt.Last.Latitude, lon, flt.Last.Longitude)) >= distanceEpsilonNM {
+					if geo.Distance(lat, lon, flt.Last.Latitude, flt.Last.Longitude) >= th.DistanceEpsilonNM {
 						reportable = true
 					}
 				}
@@ -294,20 +584,31 @@ func (t *Tracker) handleAdsbPosition(icaoID string, flt *flight, tm time.Time, m
 		}
 	}
 
-	if reportable {
+	if t.config.Simplify && flt.Current.PositionValid {
+		if newFix {
+			t.simplifyFix(icaoID, flt, th)
+		}
+		return
+	}
+	if reportable || t.dueForReport(flt, th) {
 		t.report(icaoID, flt, tm, false)
 	}
 }
 
 func (t *Tracker) report(icaoID string, flt *flight, tm time.Time, force bool) {
-	if !force && !t.ForceReporting && flt.Last.Time.Add(reportMinInterval).After(flt.Current.Time) {
+	if !force && !t.config.ForceReporting && flt.Last.Time.Add(t.thresholdsFor(flt).ReportMinInterval).After(flt.Current.Time) {
 		// We've too recently sent a previous position report.
 		return
 	}
+	if t.config.Simplify {
+		t.flushWindow(icaoID, flt)
+	}
+	flt.Current.Phase = classifyPhase(flt, flt.Current.Time)
 	flt.Last = flt.Current
 	//flt.Last.Time = tm
 	flt.PendingChange = false
 	t.handlers.AddTrackPoint(icaoID, flt.Last)
+	trackPoints.Inc()
 }
 
 func (t *Tracker) sweepIfNeeded(tm time.Time) {
@@ -317,36 +618,19 @@ func (t *Tracker) sweepIfNeeded(tm time.Time) {
 }
 
 func (t *Tracker) sweep(tm time.Time) {
-	cutoff := tm.Add(-decayTime)
+	cutoff := tm.Add(-t.config.DecayTime)
 	for id := range t.flights {
 		if t.flights[id].LastSeen.Before(cutoff) {
 			// it's been too long since we've seen this flight
+			if len(t.flights[id].Window) > 0 {
+				// Don't lose the end of a simplified track
+				t.report(id, t.flights[id], t.flights[id].LastSeen, true)
+			}
 			t.handlers.CloseFlight(id, t.flights[id].LastSeen, t.flights[id].MessageCount)
+			flightsClosed.Inc()
+			t.publish(AircraftRemoved, t.flights[id])
 			delete(t.flights, id)
 		}
 	}
-	t.nextSweep = tm.Add(sweepInterval)
-}
-
-// Haversine distance between two GPS coordinates
-// https://janakiev.com/blog/gps-points-distance-python/
-func distanceNM(lat1, lon1, lat2, lon2 float64) float64 {
-	const r float64 = 6372800 // Earth radius in meters
-	phi1 := lat1 * (math.Pi / 180)
-	phi2 := lat2 * (math.Pi / 180)
-	dphi := (lat2 - lat1) * (math.Pi / 180)
-	dlambda := (lon2 - lon1) * (math.Pi / 180)
-
-	a := math.Pow(math.Sin(dphi/2.0), 2) + math.Cos(phi1)*math.Cos(phi2)*math.Pow(math.Sin(dlambda/2), 2)
-	meters := 2 * r * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return meters / 1852
-}
-
-func (t *Tracker) GetState() []byte {
-	data, err := json.Marshal(t.flights)
-	if err != nil {
-		log.Error().Err(err).Msgf("Couldn't marshal flights array")
-		return nil
-	}
-	return data
+	t.nextSweep = tm.Add(t.config.SweepInterval)
 }
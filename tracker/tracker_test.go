@@ -3,6 +3,7 @@ package tracker
 import (
 	"encoding/hex"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -12,7 +13,7 @@ import (
 type handler struct {
 }
 
-func (h *handler) NewFlight(icaoID string, firstSeen time.Time)                                    {}
+func (h *handler) NewFlight(icaoID string, firstSeen time.Time, reason SplitReason)                {}
 func (h *handler) CloseFlight(icaoID string, lastSeen time.Time, messages int)                     {}
 func (h *handler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {}
 func (h *handler) AddTrackPoint(icaoID string, trackPoint TrackLog) {
@@ -45,7 +46,9 @@ func (h *handler) AddTrackPoint(icaoID string, trackPoint TrackLog) {
 
 func TestPosition(t *testing.T) {
 	h := new(handler)
-	tracker := New(h, true)
+	config := DefaultConfig()
+	config.ForceReporting = true
+	tracker := New(h, config)
 
 	msgEven, _ := hex.DecodeString("8D75804B580FF2CF7E9BA6F701D0")
 	msgOdd, _ := hex.DecodeString("8D75804B580FF6B283EB7A157117")
@@ -55,9 +58,272 @@ func TestPosition(t *testing.T) {
 	tracker.Message(icao, time.Now(), decoded)
 }
 
-func TestDistance(t *testing.T) {
-	distance := distanceNM(51.5073219, -0.1276474, 52.5170365, 13.3888599)
-	if !(distance > 502 && distance < 503) {
-		t.Errorf("Distance was %f, should be 502.55nm", distance)
+type flightEvent struct {
+	reason   SplitReason
+	closed   bool
+	callsign string // set for SetIdentity
+}
+
+type recordingHandler struct {
+	events []flightEvent
+}
+
+func (h *recordingHandler) NewFlight(icaoID string, firstSeen time.Time, reason SplitReason) {
+	h.events = append(h.events, flightEvent{reason: reason})
+}
+func (h *recordingHandler) CloseFlight(icaoID string, lastSeen time.Time, messages int) {
+	h.events = append(h.events, flightEvent{closed: true})
+}
+func (h *recordingHandler) SetIdentity(icaoID, callsign string, category decoder.AircraftType, change bool) {
+	h.events = append(h.events, flightEvent{callsign: callsign})
+}
+func (h *recordingHandler) AddTrackPoint(icaoID string, trackPoint TrackLog) {}
+
+func (h *recordingHandler) reasons() []SplitReason {
+	var reasons []SplitReason
+	for _, e := range h.events {
+		if !e.closed && e.callsign == "" {
+			reasons = append(reasons, e.reason)
+		}
+	}
+	return reasons
+}
+
+// callsigns returns the first callsign given to each flight, or "" for a
+// flight that was never given one.
+func (h *recordingHandler) callsigns() []string {
+	var callsigns []string
+	for _, e := range h.events {
+		switch {
+		case e.closed:
+		case e.callsign != "":
+			if last := len(callsigns) - 1; last >= 0 && callsigns[last] == "" {
+				callsigns[last] = e.callsign
+			}
+		default:
+			callsigns = append(callsigns, "")
+		}
+	}
+	return callsigns
+}
+
+// checkSplits checks the split reasons and callsigns of the flights
+// recorded by h.
+func checkSplits(t *testing.T, h *recordingHandler, reasons []SplitReason, callsigns []string) {
+	t.Helper()
+	if !reflect.DeepEqual(h.reasons(), reasons) {
+		t.Errorf("Split reasons %v, should be %v", h.reasons(), reasons)
+	}
+	if !reflect.DeepEqual(h.callsigns(), callsigns) {
+		t.Errorf("Flight callsigns %q, should be %q", h.callsigns(), callsigns)
+	}
+}
+
+// fly feeds the tracker one velocity message per second for the duration.
+func fly(tracker *Tracker, tm time.Time, duration time.Duration, speed int) time.Time {
+	end := tm.Add(duration)
+	for ; tm.Before(end); tm = tm.Add(time.Second) {
+		tracker.Message("abcdef", tm, &decoder.AdsbVelocity{Speed: speed, SpeedType: decoder.SpeedGS})
+	}
+	return tm
+}
+
+func TestSplitOnTurnaround(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Touch and go: not long enough on the ground to split
+	tm = fly(tracker, tm, 30*time.Second, 30)
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Land, taxi in, new callsign for the next leg
+	tm = fly(tracker, tm, 3*time.Minute, 15)
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA456", Type: decoder.ACTypeLarge})
+	tm = fly(tracker, tm, 3*time.Minute, 15)
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Land and sit on the ground with the transponder on
+	tm = fly(tracker, tm, 15*time.Minute, 0)
+	// Then a long silence
+	tracker.Message("abcdef", tm.Add(time.Hour), nil)
+
+	expected := []SplitReason{SplitNone, SplitCallsign, SplitLanding, SplitGap}
+	if !reflect.DeepEqual(h.reasons(), expected) {
+		t.Errorf("Split reasons %v, should be %v", h.reasons(), expected)
+	}
+}
+
+func TestNoSplitForHelicopter(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "LIFE1", Type: decoder.ACTypeRotocraft})
+	tm = fly(tracker, tm, 10*time.Minute, 100)
+	// Slow flight while hovering isn't a landing
+	tm = fly(tracker, tm, 15*time.Minute, 5)
+	fly(tracker, tm, 10*time.Minute, 100)
+
+	expected := []SplitReason{SplitNone}
+	if !reflect.DeepEqual(h.reasons(), expected) {
+		t.Errorf("Split reasons %v, should be %v", h.reasons(), expected)
+	}
+}
+
+func TestSplitOnTakeoff(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "N123AB", Type: decoder.ACTypeLight})
+	tm = fly(tracker, tm, 10*time.Minute, 110)
+	// A quick stop, same callsign, then off again
+	tm = fly(tracker, tm, 5*time.Minute, 10)
+	fly(tracker, tm, 10*time.Minute, 110)
+
+	// The new flight is still N123AB
+	checkSplits(t, h, []SplitReason{SplitNone, SplitTakeoff}, []string{"N123AB", "N123AB"})
+}
+
+func TestSplitOnLanding(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Land and stay on the ground with the transponder on
+	fly(tracker, tm, 15*time.Minute, 0)
+
+	// The callsign carries over until the aircraft sends a new one
+	checkSplits(t, h, []SplitReason{SplitNone, SplitLanding}, []string{"ASA123", "ASA123"})
+}
+
+func TestSplitOnCallsign(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Land and taxi in, then set up the next leg
+	tm = fly(tracker, tm, 3*time.Minute, 15)
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA456", Type: decoder.ACTypeLarge})
+	fly(tracker, tm, time.Minute, 15)
+
+	// The new flight has the new callsign, not the old one
+	checkSplits(t, h, []SplitReason{SplitNone, SplitCallsign}, []string{"ASA123", "ASA456"})
+}
+
+func TestSplitOnGap(t *testing.T) {
+	h := new(recordingHandler)
+	tracker := New(h, DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tracker.Message("abcdef", tm, &decoder.AdsbIdentification{Callsign: "ASA123", Type: decoder.ACTypeLarge})
+	tm = fly(tracker, tm, 10*time.Minute, 250)
+	// Out of range for longer than the decay time, then heard again
+	fly(tracker, tm.Add(DefaultConfig().DecayTime+time.Minute), time.Minute, 250)
+
+	// Nothing carries over a gap, so the new flight has no callsign yet
+	checkSplits(t, h, []SplitReason{SplitNone, SplitGap}, []string{"ASA123", ""})
+}
+
+func TestClassifyPhase(t *testing.T) {
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		flt      flight
+		expected Phase
+	}{
+		{"taxi", flight{GroundValid: true, OnGround: true,
+			Current: TrackLog{SpeedValid: true, Speed: 15}}, PhaseTaxi},
+		{"takeoff roll", flight{GroundValid: true, OnGround: true,
+			Current: TrackLog{SpeedValid: true, Speed: 80}}, PhaseTakeoff},
+		{"rollout", flight{GroundValid: true, OnGround: true, Flown: true, LandedAt: tm.Add(-10 * time.Second),
+			Current: TrackLog{SpeedValid: true, Speed: 80}}, PhaseLanding},
+		{"taxi in", flight{GroundValid: true, OnGround: true, Flown: true, LandedAt: tm.Add(-5 * time.Minute),
+			Current: TrackLog{SpeedValid: true, Speed: 15}}, PhaseTaxi},
+		{"climb", flight{GroundValid: true,
+			Current: TrackLog{VSValid: true, VS: 1500, AltitudeValid: true, Altitude: 3000}}, PhaseClimb},
+		{"cruise", flight{GroundValid: true,
+			Current: TrackLog{VSValid: true, VS: 64, AltitudeValid: true, Altitude: 35000}}, PhaseCruise},
+		{"descent", flight{GroundValid: true,
+			Current: TrackLog{VSValid: true, VS: -1500, AltitudeValid: true, Altitude: 20000}}, PhaseDescent},
+		{"approach", flight{GroundValid: true,
+			Current: TrackLog{VSValid: true, VS: -700, AltitudeValid: true, Altitude: 2000}}, PhaseApproach},
+		{"no vertical rate", flight{GroundValid: true}, PhaseUnknown},
+	}
+
+	for _, test := range tests {
+		if phase := classifyPhase(&test.flt, tm); phase != test.expected {
+			t.Errorf("%s: phase %q, should be %q", test.name, phase, test.expected)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	started, gaps := flightsStarted.With("new"), flightsStarted.With(string(SplitGap))
+	started0, gaps0, closed0 := started.Value(), gaps.Value(), flightsClosed.Value()
+
+	tracker := New(new(recordingHandler), DefaultConfig())
+	tm := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	tracker.Message("abcdef", tm, nil)
+	tracker.Message("123456", tm, nil)
+	if n := activeFlights.Value(); n != 2 {
+		t.Errorf("%v active flights, want 2", n)
+	}
+	tracker.Message("abcdef", tm.Add(time.Hour), nil)
+	tracker.CloseAllFlights()
+
+	if n := started.Value() - started0; n != 2 {
+		t.Errorf("counted %d new flights, want 2", n)
+	}
+	if n := gaps.Value() - gaps0; n != 1 {
+		t.Errorf("counted %d gap splits, want 1", n)
+	}
+	if n := flightsClosed.Value() - closed0; n != 3 {
+		t.Errorf("counted %d closed flights, want 3", n)
+	}
+	if n := activeFlights.Value(); n != 0 {
+		t.Errorf("%v active flights after closing all, want 0", n)
+	}
+}
+
+// TestReportInterval pins how often an aircraft flying steadily is reported,
+// when nothing changes by more than an epsilon between messages.
+func TestReportInterval(t *testing.T) {
+	h := new(pointHandler)
+	config := DefaultConfig()
+	tracker := New(h, config)
+
+	msgEven, _ := hex.DecodeString("8D75804B580FF2CF7E9BA6F701D0")
+	msgOdd, _ := hex.DecodeString("8D75804B580FF6B283EB7A157117")
+	start := time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Minute)
+	for tm := start; tm.Before(end); tm = tm.Add(time.Second) {
+		tracker.Message("75804b", tm, &decoder.AdsbVelocity{Speed: 110, SpeedType: decoder.SpeedGS})
+		for _, msg := range [][]byte{msgEven, msgOdd} {
+			icao, decoded := decoder.DecodeMessage(msg, tm)
+			tracker.Message(icao, tm, decoded)
+		}
+	}
+
+	var positions []TrackLog
+	for _, p := range h.points {
+		if p.PositionValid {
+			positions = append(positions, p)
+		}
+	}
+	if len(positions) < 10 {
+		t.Fatalf("%d positions reported in 2 minutes, want one every %s", len(positions), config.ReportMaxInterval)
+	}
+	for i := 1; i < len(positions); i++ {
+		gap := positions[i].Time.Sub(positions[i-1].Time)
+		if gap < config.ReportMinInterval || gap > config.ReportMaxInterval {
+			t.Errorf("%s between positions at %s, want %s to %s", gap, positions[i].Time.Format("15:04:05"),
+				config.ReportMinInterval, config.ReportMaxInterval)
+		}
 	}
 }
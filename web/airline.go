@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+var airlineValidator = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func getAirlinesHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		airlines, err := dao.GetAirlines()
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		vals := map[string]interface{}{
+			"Title":    "Airlines",
+			"section":  "airlines",
+			"Airlines": airlines,
+		}
+		return c.Render(http.StatusOK, "airlines.html", vals)
+	}
+}
+
+func getAirlineHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		icao := strings.ToUpper(c.Param("icao"))
+		if !airlineValidator.MatchString(icao) {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid airline code (must be three letters)")
+		}
+
+		activity, err := dao.GetAirlineActivity(icao)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown airline")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		fleet, err := dao.GetAirlineFleet(icao)
+		if err != nil {
+			return err
+		}
+		types, err := dao.GetAirlineTypes(icao)
+		if err != nil {
+			return err
+		}
+		hours, err := dao.GetAirlineHours(icao)
+		if err != nil {
+			return err
+		}
+		weekdays, err := dao.GetAirlineWeekdays(icao)
+		if err != nil {
+			return err
+		}
+
+		page, _ := strconv.Atoi(c.QueryParam("page"))
+		if page < 1 {
+			page = 1
+		}
+		offset := (page - 1) * searchPageSize
+		flights, total, err := dao.SearchFlights(data.FlightQuery{Airline: icao}, searchPageSize, offset)
+		if err != nil {
+			return err
+		}
+		for i := range flights {
+			flights[i].Icon, flights[i].IconX, flights[i].IconY = iconFor(flights[i].TypeCode, flights[i].Category)
+		}
+
+		vals := map[string]interface{}{
+			"Title":    activity.Name,
+			"section":  "airlines",
+			"Airline":  activity,
+			"Fleet":    fleet,
+			"Types":    types,
+			"Hours":    histogram(hours, hourKeys, func(h int) string { return fmt.Sprintf("%02d", h) }),
+			"Weekdays": histogram(weekdays, weekdayKeys, func(d int) string { return time.Weekday(d % 7).String()[:3] }),
+			"Flights":  flights,
+			"Total":    total,
+			"From":     offset + 1,
+			"To":       offset + len(flights),
+		}
+		if page > 1 {
+			vals["PrevURL"] = fmt.Sprintf("/airline/%s?page=%d", icao, page-1)
+		}
+		if offset+len(flights) < total {
+			vals["NextURL"] = fmt.Sprintf("/airline/%s?page=%d", icao, page+1)
+		}
+		return c.Render(http.StatusOK, "airline.html", vals)
+	}
+}
+
+var hourKeys = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}
+
+// ISO days of the week, Monday first
+var weekdayKeys = []int{1, 2, 3, 4, 5, 6, 7}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/geo"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// The airport index lists airports with movements in the last
+// airportIndexDays days. Airport pages chart movements per day for
+// airportDailyDays days and show the runways in use over the last
+// airportRunwayDays days.
+const airportIndexDays = 30
+const airportDailyDays = 30
+const airportRunwayDays = 7
+
+// runwayPeriodGap is the longest time without movements that a runway period
+// carries on through, so that quiet nights don't split it.
+const runwayPeriodGap = 3 * time.Hour
+
+var airportCodeValidator = regexp.MustCompile(`^[A-Z0-9-]{2,10}$`)
+
+// airportSummary is an airport for the airport index, with its distance from
+// the receiver.
+type airportSummary struct {
+	data.AirportActivity
+	Distance float64
+}
+
+// runwayCount is the number of movements on a runway end in a runwayPeriod.
+type runwayCount struct {
+	Runway     string
+	Departures int
+	Arrivals   int
+}
+
+// runwayPeriod is a stretch of time in which the airport used the same
+// runway directions.
+type runwayPeriod struct {
+	Start, End time.Time
+	Runways    []runwayCount
+}
+
+func getAirportsHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		since := time.Now().UTC().AddDate(0, 0, -airportIndexDays)
+		airports, err := dao.GetAirports(since)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		summaries := make([]airportSummary, len(airports))
+		for i, a := range airports {
+			summaries[i].AirportActivity = a
+			summaries[i].Distance = geo.Distance(*receiverLat, *receiverLon, a.Latitude, a.Longitude)
+		}
+		vals := map[string]interface{}{
+			"Title":    "Airports",
+			"section":  "airports",
+			"Airports": summaries,
+			"Days":     airportIndexDays,
+		}
+		return c.Render(http.StatusOK, "airports.html", vals)
+	}
+}
+
+func getAirportHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		code := strings.ToUpper(c.Param("icao"))
+		if !airportCodeValidator.MatchString(code) {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid airport code")
+		}
+
+		airport, err := dao.GetAirport(code)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown airport")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		if airport.Ident != code {
+			// Found by IATA code
+			return c.Redirect(http.StatusMovedPermanently, "/airport/"+airport.Ident)
+		}
+
+		day := time.Now()
+		if date := c.QueryParam("date"); date != "" {
+			day, err = time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid date format (must be YYYY-MM-DD)")
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+		start := day.UTC()
+		end := day.AddDate(0, 0, 1).UTC()
+
+		departures, err := dao.GetAirportDepartures(airport.Ident, start, end)
+		if err != nil {
+			return err
+		}
+		arrivals, err := dao.GetAirportArrivals(airport.Ident, start, end)
+		if err != nil {
+			return err
+		}
+		runways, err := dao.GetAirportRunways(airport.Ident)
+		if err != nil {
+			return err
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(airportDailyDays - 1))
+		daily, err := dao.GetAirportDailyMovements(airport.Ident, since)
+		if err != nil {
+			return err
+		}
+		days := make([]int, airportDailyDays)
+		for i := range days {
+			days[i] = i
+		}
+
+		uses, err := dao.GetAirportRunwayUse(airport.Ident, time.Now().UTC().AddDate(0, 0, -airportRunwayDays))
+		if err != nil {
+			return err
+		}
+
+		vals := map[string]interface{}{
+			"Title":         airport.Ident + " " + airport.Name,
+			"section":       "airports",
+			"Airport":       airport,
+			"Distance":      geo.Distance(*receiverLat, *receiverLon, airport.Latitude, airport.Longitude),
+			"Runways":       runways,
+			"Date":          day.Format("2006-01-02"),
+			"PrevDate":      day.AddDate(0, 0, -1).Format("2006-01-02"),
+			"NextDate":      day.AddDate(0, 0, 1).Format("2006-01-02"),
+			"Departures":    departures,
+			"Arrivals":      arrivals,
+			"Daily":         histogram(daily, days, func(d int) string { return since.AddDate(0, 0, d).Format("01-02") }),
+			"DailyDays":     airportDailyDays,
+			"RunwayPeriods": runwayPeriods(uses),
+			"RunwayDays":    airportRunwayDays,
+		}
+		return c.Render(http.StatusOK, "airport.html", vals)
+	}
+}
+
+// runwayPeriods groups hourly runway use, in time order, into periods in
+// which the same runway directions were used, latest first. Parallel
+// runways (16L and 16R) are the same direction.
+func runwayPeriods(uses []data.RunwayUse) []runwayPeriod {
+	var periods []runwayPeriod
+	var directions string
+	counts := make(map[string]*runwayCount)
+
+	finish := func() {
+		p := &periods[len(periods)-1]
+		for _, c := range counts {
+			p.Runways = append(p.Runways, *c)
+		}
+		sort.Slice(p.Runways, func(i, j int) bool { return p.Runways[i].Runway < p.Runways[j].Runway })
+		counts = make(map[string]*runwayCount)
+	}
+
+	for i := 0; i < len(uses); {
+		hour := uses[i].Hour
+		j := i
+		for j < len(uses) && uses[j].Hour.Equal(hour) {
+			j++
+		}
+		hourDirections := runwayDirections(uses[i:j])
+
+		if len(periods) == 0 || hourDirections != directions ||
+			hour.Sub(periods[len(periods)-1].End) > runwayPeriodGap {
+			if len(periods) > 0 {
+				finish()
+			}
+			periods = append(periods, runwayPeriod{Start: hour})
+			directions = hourDirections
+		}
+		periods[len(periods)-1].End = hour.Add(time.Hour)
+		for _, u := range uses[i:j] {
+			c, ok := counts[u.Runway]
+			if !ok {
+				c = &runwayCount{Runway: u.Runway}
+				counts[u.Runway] = c
+			}
+			c.Departures += u.Departures
+			c.Arrivals += u.Arrivals
+		}
+		i = j
+	}
+	if len(periods) > 0 {
+		finish()
+	}
+
+	for i, j := 0, len(periods)-1; i < j; i, j = i+1, j-1 {
+		periods[i], periods[j] = periods[j], periods[i]
+	}
+	return periods
+}
+
+// runwayDirections returns the runway directions used in an hour as a
+// string to compare hours by, e.g. "16,21".
+func runwayDirections(uses []data.RunwayUse) string {
+	seen := make(map[string]bool)
+	var directions []string
+	for _, u := range uses {
+		d := runwayDirection(u.Runway)
+		if !seen[d] {
+			seen[d] = true
+			directions = append(directions, d)
+		}
+	}
+	sort.Strings(directions)
+	return strings.Join(directions, ",")
+}
+
+// runwayDirection returns a runway end's ident without the letter that
+// tells parallel runways apart, e.g. 16 for 16L.
+func runwayDirection(ident string) string {
+	n := len(ident)
+	if n >= 2 && strings.ContainsRune("LCR", rune(ident[n-1])) && ident[n-2] >= '0' && ident[n-2] <= '9' {
+		return ident[:n-1]
+	}
+	return ident
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// The JSON API under /api/v1. Every route is described by an apiRoute, which
+// is used both to register the handler and to generate the OpenAPI document
+// at /api/v1/openapi.json, so the two can't disagree.
+
+const apiPrefix = "/api/v1"
+
+// Page sizes for paginated results
+const apiDefaultLimit = 50
+const apiMaxLimit = 500
+
+type apiParam struct {
+	Name        string
+	In          string // "path" or "query"
+	Type        string // OpenAPI type: "string", "integer", ...
+	Format      string // OpenAPI format, e.g. "date"
+	Description string
+	Required    bool
+}
+
+type apiRoute struct {
+	Path        string // echo path, e.g. /flights/:id
+	Summary     string
+	Params      []apiParam
+	Response    interface{} // a value of the response type, or of each item for paginated routes
+	Paginated   bool
+	Handler     echo.HandlerFunc
+	NotFoundDoc string // when the route returns 404, if it can
+}
+
+var apiPageParams = []apiParam{
+	{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("Results per page (default %d, at most %d)", apiDefaultLimit, apiMaxLimit)},
+	{Name: "offset", In: "query", Type: "integer", Description: "Number of results to skip"},
+}
+
+type apiFlight struct {
+	ID           int                 `json:"id"`
+	Icao         string              `json:"icao"`
+	Callsign     *string             `json:"callsign"`
+	FirstSeen    time.Time           `json:"first_seen"`
+	LastSeen     *time.Time          `json:"last_seen"`
+	Messages     *int64              `json:"messages"`
+	Category     *int64              `json:"category"`
+	CategoryName *string             `json:"category_name"`
+	SplitReason  *string             `json:"split_reason"`
+	Departure    *apiAirportMovement `json:"departure"`
+	Arrival      *apiAirportMovement `json:"arrival"`
+	Registration *string             `json:"registration"`
+	Owner        *string             `json:"owner"`
+	Airline      *string             `json:"airline"`
+	TypeCode     *string             `json:"type_code"`
+	Manufacturer *string             `json:"manufacturer"`
+	Model        *string             `json:"model"`
+	Year         *int64              `json:"year"`
+}
+
+// apiAirportMovement is the airport, and if it could be told the runway, that
+// a flight departed from or arrived at.
+type apiAirportMovement struct {
+	Airport string  `json:"airport"`
+	Name    *string `json:"name"`
+	Runway  *string `json:"runway"`
+}
+
+type apiTrackPoint struct {
+	Time      time.Time `json:"time"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Heading   *int64    `json:"heading"`
+	Speed     *int64    `json:"speed"`
+	Altitude  *int64    `json:"altitude"`
+	VS        *int64    `json:"vs"`
+	Callsign  *string   `json:"callsign"`
+	Phase     *string   `json:"phase"`
+}
+
+type apiFlightDetail struct {
+	apiFlight
+	Track []apiTrackPoint `json:"track"`
+}
+
+type apiRegistration struct {
+	Registration string  `json:"registration"`
+	TypeCode     *string `json:"type_code"`
+	Manufacturer *string `json:"manufacturer"`
+	Model        *string `json:"model"`
+	Year         *int64  `json:"year"`
+	Owner        *string `json:"owner"`
+	City         *string `json:"city"`
+	State        *string `json:"state"`
+	Country      *string `json:"country"`
+	Source       string  `json:"source"`
+}
+
+type apiAircraft struct {
+	Icao         string           `json:"icao"`
+	Registration *apiRegistration `json:"registration"`
+	Flights      int              `json:"flights"`
+}
+
+type apiTableStats struct {
+	Table      string `json:"table"`
+	TableSize  string `json:"table_size"`
+	IndexSize  string `json:"index_size"`
+	TotalSize  string `json:"total_size"`
+	ApproxRows int64  `json:"approx_rows"`
+}
+
+type apiPagination struct {
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Total  int     `json:"total"`
+	Next   *string `json:"next"` // URL of the next page, if there is one
+}
+
+type apiPage struct {
+	Data       interface{}   `json:"data"`
+	Pagination apiPagination `json:"pagination"`
+}
+
+type apiErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullInt(i sql.NullInt64) *int64 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int64
+}
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+func nullTime(t pq.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func newAPIFlight(f data.Flight) apiFlight {
+	a := apiFlight{
+		ID:           f.ID,
+		Icao:         f.Icao,
+		Callsign:     nullString(f.Callsign),
+		FirstSeen:    f.FirstSeen,
+		LastSeen:     nullTime(f.LastSeen),
+		Messages:     nullInt(f.MsgCount),
+		Category:     nullInt(f.Category),
+		SplitReason:  nullString(f.SplitReason),
+		Registration: nullString(f.Registration),
+		Owner:        nullString(f.Owner),
+		Airline:      nullString(f.Airline),
+		TypeCode:     nullString(f.TypeCode),
+		Manufacturer: nullString(f.Mfg),
+		Model:        nullString(f.Model),
+		Year:         nullInt(f.MfgYear),
+	}
+	if f.Category.Valid {
+		name := decoder.AircraftType(f.Category.Int64).String()
+		a.CategoryName = &name
+	}
+	if f.Departure.Valid {
+		a.Departure = &apiAirportMovement{f.Departure.String, nullString(f.DepartureName), nullString(f.DepartureRunway)}
+	}
+	if f.Arrival.Valid {
+		a.Arrival = &apiAirportMovement{f.Arrival.String, nullString(f.ArrivalName), nullString(f.ArrivalRunway)}
+	}
+	return a
+}
+
+func newAPITrackPoint(t data.TrackLog) apiTrackPoint {
+	return apiTrackPoint{
+		Time:      t.Time,
+		Latitude:  nullFloat(t.Latitude),
+		Longitude: nullFloat(t.Longitude),
+		Heading:   nullInt(t.Heading),
+		Speed:     nullInt(t.Speed),
+		Altitude:  nullInt(t.Altitude),
+		VS:        nullInt(t.Vs),
+		Callsign:  nullString(t.Callsign),
+		Phase:     nullString(t.Phase),
+	}
+}
+
+func apiFlights(flights []data.Flight) []apiFlight {
+	result := make([]apiFlight, len(flights))
+	for i, f := range flights {
+		result[i] = newAPIFlight(f)
+	}
+	return result
+}
+
+// apiBadRequest is an error for a bad parameter.
+func apiBadRequest(format string, args ...interface{}) error {
+	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(format, args...))
+}
+
+// pageParams reads the limit and offset query parameters.
+func pageParams(c echo.Context) (limit, offset int, err error) {
+	limit = apiDefaultLimit
+	if s := c.QueryParam("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > apiMaxLimit {
+			return 0, 0, apiBadRequest("limit must be a number from 1 to %d", apiMaxLimit)
+		}
+	}
+	if s := c.QueryParam("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, apiBadRequest("offset must be a number 0 or greater")
+		}
+	}
+	return limit, offset, nil
+}
+
+// page sends a page of results, with a link to the next page.
+func page(c echo.Context, items interface{}, limit, offset, total int) error {
+	p := apiPagination{Limit: limit, Offset: offset, Total: total}
+	if offset+limit < total {
+		next := *c.Request().URL
+		q := next.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(offset+limit))
+		next.RawQuery = q.Encode()
+		s := next.RequestURI()
+		p.Next = &s
+	}
+	return c.JSON(http.StatusOK, apiPage{Data: items, Pagination: p})
+}
+
+// apiErrorHandler sends errors from API routes as an apiError.
+func apiErrorHandler(err error, c echo.Context) {
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	if he, ok := err.(*echo.HTTPError); ok {
+		status = he.Code
+		message = fmt.Sprint(he.Message)
+	} else {
+		c.Logger().Error(err)
+	}
+	if c.Response().Committed {
+		return
+	}
+	if err := c.JSON(status, apiError{Error: apiErrorDetail{Status: status, Message: message}}); err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+func isAPIRequest(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().URL.Path, apiPrefix+"/")
+}
+
+func parseAPIDate(c echo.Context, name string) (time.Time, error) {
+	s := c.QueryParam(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, apiBadRequest("%s must be a date (YYYY-MM-DD) or RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+func apiRoutes(dao *data.DAO) []apiRoute {
+	return []apiRoute{
+		{
+			Path:    "/flights",
+			Summary: "Search flights, newest first",
+			Params: append([]apiParam{
+				{Name: "start", In: "query", Type: "string", Format: "date", Description: "Flights first seen on or after this date (YYYY-MM-DD, local time) or RFC 3339 time"},
+				{Name: "end", In: "query", Type: "string", Format: "date", Description: "Flights first seen before this date or time"},
+				{Name: "callsign", In: "query", Type: "string", Description: "Callsign; * matches any characters"},
+				{Name: "icao", In: "query", Type: "string", Description: "ICAO transponder ID (six hex digits)"},
+				{Name: "registration", In: "query", Type: "string", Description: "Aircraft registration"},
+				{Name: "type", In: "query", Type: "string", Description: "ICAO aircraft type designator, e.g. B738"},
+				{Name: "airline", In: "query", Type: "string", Description: "ICAO airline designator, e.g. ASA, matched against the start of the callsign"},
+				{Name: "owner", In: "query", Type: "string", Description: "Any part of the registered owner's name"},
+			}, apiPageParams...),
+			Response:  apiFlight{},
+			Paginated: true,
+			Handler:   getAPIFlightsHandler(dao),
+		},
+		{
+			Path:        "/flights/:id",
+			Summary:     "A flight and its track log",
+			Params:      []apiParam{{Name: "id", In: "path", Type: "integer", Required: true}},
+			Response:    apiFlightDetail{},
+			Handler:     getAPIFlightHandler(dao),
+			NotFoundDoc: "No such flight",
+		},
+		{
+			Path:        "/aircraft/:icao",
+			Summary:     "An airframe's registration and number of flights",
+			Params:      []apiParam{{Name: "icao", In: "path", Type: "string", Required: true, Description: "ICAO transponder ID"}},
+			Response:    apiAircraft{},
+			Handler:     getAPIAircraftHandler(dao),
+			NotFoundDoc: "Neither a registration nor any flights for the aircraft",
+		},
+		{
+			Path:    "/aircraft/:icao/flights",
+			Summary: "An airframe's flights, newest first",
+			Params: append([]apiParam{
+				{Name: "icao", In: "path", Type: "string", Required: true, Description: "ICAO transponder ID"},
+			}, apiPageParams...),
+			Response:  apiFlight{},
+			Paginated: true,
+			Handler:   getAPIAircraftFlightsHandler(dao),
+		},
+		{
+			Path:        "/replay",
+			Summary:     "Interpolated positions of every flight in a window of time, for animation",
+			Params:      replayParams,
+			Response:    apiReplay{},
+			Handler:     getAPIReplayHandler(dao),
+			NotFoundDoc: "No such flight",
+		},
+		{
+			Path:     "/zones",
+			Summary:  "Geofence zones, with the number of entries and exits recorded",
+			Response: []apiZone{},
+			Handler:  getAPIZonesHandler(dao),
+		},
+		{
+			Path:     "/stats/tables",
+			Summary:  "Database table sizes",
+			Response: []apiTableStats{},
+			Handler:  getAPITableStatsHandler(dao),
+		},
+	}
+}
+
+// registerAPI adds the API routes and the OpenAPI document describing them.
+func registerAPI(e *echo.Echo, dao *data.DAO) {
+	routes := apiRoutes(dao)
+	g := e.Group(apiPrefix)
+	for _, r := range routes {
+		g.GET(r.Path, r.Handler)
+	}
+	doc := openAPIDocument(routes)
+	g.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, doc)
+	})
+	g.Any("/*", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "No such API endpoint")
+	})
+}
+
+func getAPIFlightsHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, offset, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		var q data.FlightQuery
+		if q.Start, err = parseAPIDate(c, "start"); err != nil {
+			return err
+		}
+		if q.End, err = parseAPIDate(c, "end"); err != nil {
+			return err
+		}
+		q.Callsign = c.QueryParam("callsign")
+		q.Icao = c.QueryParam("icao")
+		if q.Icao != "" && !icaoValidator.MatchString(strings.ToLower(q.Icao)) {
+			return apiBadRequest("icao must be six hex digits")
+		}
+		q.Registration = c.QueryParam("registration")
+		q.TypeCode = c.QueryParam("type")
+		q.Airline = c.QueryParam("airline")
+		q.Owner = c.QueryParam("owner")
+
+		flights, total, err := dao.SearchFlights(q, limit, offset)
+		if err != nil {
+			return err
+		}
+		return page(c, apiFlights(flights), limit, offset, total)
+	}
+}
+
+func getAPIFlightHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return apiBadRequest("flight id must be a number")
+		}
+		flight, err := dao.GetFlight(id)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "No such flight")
+		}
+		if err != nil {
+			return err
+		}
+		tracklog, err := dao.GetTrackLog(id)
+		if err != nil {
+			return err
+		}
+
+		detail := apiFlightDetail{apiFlight: newAPIFlight(flight), Track: make([]apiTrackPoint, len(tracklog))}
+		for i, t := range tracklog {
+			detail.Track[i] = newAPITrackPoint(t)
+		}
+		return c.JSON(http.StatusOK, detail)
+	}
+}
+
+func apiIcaoParam(c echo.Context) (string, error) {
+	icao := strings.ToLower(c.Param("icao"))
+	if !icaoValidator.MatchString(icao) {
+		return "", apiBadRequest("icao must be six hex digits")
+	}
+	return icao, nil
+}
+
+func getAPIAircraftHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		icao, err := apiIcaoParam(c)
+		if err != nil {
+			return err
+		}
+		aircraft := apiAircraft{Icao: icao}
+
+		reg, err := dao.GetRegistration(icao)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			aircraft.Registration = &apiRegistration{
+				Registration: reg.Registration.String,
+				TypeCode:     nullString(reg.Typecode),
+				Manufacturer: nullString(reg.Mfg),
+				Model:        nullString(reg.Model),
+				Year:         nullInt(reg.Year),
+				Owner:        nullString(reg.Owner),
+				City:         nullString(reg.City),
+				State:        nullString(reg.State),
+				Country:      nullString(reg.Country),
+				Source:       reg.Source,
+			}
+		}
+
+		_, aircraft.Flights, err = dao.SearchFlights(data.FlightQuery{Icao: icao}, 0, 0)
+		if err != nil {
+			return err
+		}
+		if aircraft.Registration == nil && aircraft.Flights == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown aircraft")
+		}
+		return c.JSON(http.StatusOK, aircraft)
+	}
+}
+
+func getAPIAircraftFlightsHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		icao, err := apiIcaoParam(c)
+		if err != nil {
+			return err
+		}
+		limit, offset, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		flights, total, err := dao.SearchFlights(data.FlightQuery{Icao: icao}, limit, offset)
+		if err != nil {
+			return err
+		}
+		return page(c, apiFlights(flights), limit, offset, total)
+	}
+}
+
+func getAPITableStatsHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tables, err := dao.GetTableSizes()
+		if err != nil {
+			return err
+		}
+		stats := make([]apiTableStats, len(tables))
+		for i, t := range tables {
+			stats[i] = apiTableStats{
+				Table:      t.TableName,
+				TableSize:  t.SizeTable,
+				IndexSize:  t.SizeIndex,
+				TotalSize:  t.SizeTotal,
+				ApproxRows: t.ApproxRows,
+			}
+		}
+		return c.JSON(http.StatusOK, stats)
+	}
+}
+
+// apiPathToOpenAPI converts an echo path to OpenAPI's template form:
+// /flights/:id becomes /flights/{id}.
+func apiPathToOpenAPI(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
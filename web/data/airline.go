@@ -0,0 +1,146 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Flights belong to an airline when their callsign starts with its ICAO
+// designator, as in the airline join of baseFlightQuery. US military
+// airframes (ae....) are excluded, since their callsigns often collide with
+// airline designators.
+const airlineFlights = `substring(f.callsign from 1 for 3) = $1 AND f.icao NOT LIKE 'ae%'`
+
+type Airline struct {
+	Icao     string         `db:"icao"`
+	Name     string         `db:"name"`
+	Callsign sql.NullString `db:"callsign"`
+	Country  sql.NullString `db:"country"`
+}
+
+// AirlineActivity is an airline with a summary of its flights.
+type AirlineActivity struct {
+	Airline
+	Flights   int         `db:"flights"`
+	Airframes int         `db:"airframes"`
+	FirstSeen pq.NullTime `db:"first_seen"`
+	LastSeen  pq.NullTime `db:"last_seen"`
+}
+
+// FleetAircraft is an airframe seen flying for an operator, or one of a
+// type.
+type FleetAircraft struct {
+	Icao         string         `db:"icao"`
+	Registration sql.NullString `db:"registration"`
+	TypeCode     sql.NullString `db:"typecode"`
+	Mfg          sql.NullString `db:"mfg"`
+	Model        sql.NullString `db:"model"`
+	Owner        sql.NullString `db:"owner"`
+	Flights      int            `db:"flights"`
+	FirstSeen    time.Time      `db:"first_seen"`
+	LastSeen     time.Time      `db:"last_seen"`
+}
+
+// TypeCount is the number of flights and airframes of one aircraft type.
+type TypeCount struct {
+	TypeCode  sql.NullString `db:"typecode"`
+	Flights   int            `db:"flights"`
+	Airframes int            `db:"airframes"`
+}
+
+// HistogramBucket is the number of flights with a value of Key, such as an
+// hour of the day.
+type HistogramBucket struct {
+	Key     int `db:"key"`
+	Flights int `db:"flights"`
+}
+
+func (d *DAO) GetAirline(icao string) (Airline, error) {
+	airline := Airline{}
+	err := d.db.Get(&airline, `SELECT icao, name, callsign, country FROM airline WHERE icao = $1`, icao)
+	return airline, err
+}
+
+// GetAirlines returns every airline that has been seen, busiest first.
+func (d *DAO) GetAirlines() ([]AirlineActivity, error) {
+	airlines := make([]AirlineActivity, 0)
+	err := d.db.Select(&airlines,
+		`SELECT a.icao, a.name, a.callsign, a.country, count(*) AS flights,
+		        count(DISTINCT f.icao) AS airframes, min(f.first_seen) AS first_seen,
+		        max(coalesce(f.last_seen, f.first_seen)) AS last_seen
+		 FROM flight f
+		 JOIN airline a ON a.icao = substring(f.callsign from 1 for 3)
+		 WHERE f.icao NOT LIKE 'ae%'
+		 GROUP BY a.icao, a.name, a.callsign, a.country
+		 ORDER BY flights DESC, a.icao`)
+	return airlines, err
+}
+
+// GetAirlineActivity summarizes the airline's flights.
+func (d *DAO) GetAirlineActivity(icao string) (AirlineActivity, error) {
+	activity := AirlineActivity{}
+	err := d.db.Get(&activity,
+		`SELECT a.icao, a.name, a.callsign, a.country, count(f.id) AS flights,
+		        count(DISTINCT f.icao) AS airframes, min(f.first_seen) AS first_seen,
+		        max(coalesce(f.last_seen, f.first_seen)) AS last_seen
+		 FROM airline a
+		 LEFT OUTER JOIN flight f ON `+airlineFlights+`
+		 WHERE a.icao = $1
+		 GROUP BY a.icao, a.name, a.callsign, a.country`, icao)
+	return activity, err
+}
+
+// GetAirlineFleet returns the airframes seen flying for the airline, busiest
+// first.
+func (d *DAO) GetAirlineFleet(icao string) ([]FleetAircraft, error) {
+	fleet := make([]FleetAircraft, 0)
+	err := d.db.Select(&fleet,
+		`SELECT f.icao, r.registration, r.typecode, r.mfg, r.model, count(*) AS flights,
+		        min(f.first_seen) AS first_seen, max(coalesce(f.last_seen, f.first_seen)) AS last_seen
+		 FROM flight f
+		 LEFT OUTER JOIN registration r ON r.icao = f.icao
+		 WHERE `+airlineFlights+`
+		 GROUP BY f.icao, r.registration, r.typecode, r.mfg, r.model
+		 ORDER BY flights DESC, f.icao`, icao)
+	return fleet, err
+}
+
+// GetAirlineTypes returns the aircraft types the airline has flown, busiest
+// first.
+func (d *DAO) GetAirlineTypes(icao string) ([]TypeCount, error) {
+	types := make([]TypeCount, 0)
+	err := d.db.Select(&types,
+		`SELECT r.typecode, count(*) AS flights, count(DISTINCT f.icao) AS airframes
+		 FROM flight f
+		 LEFT OUTER JOIN registration r ON r.icao = f.icao
+		 WHERE `+airlineFlights+`
+		 GROUP BY r.typecode
+		 ORDER BY flights DESC, r.typecode`, icao)
+	return types, err
+}
+
+// GetAirlineHours counts the airline's flights by the UTC hour they were
+// first seen.
+func (d *DAO) GetAirlineHours(icao string) ([]HistogramBucket, error) {
+	hours := make([]HistogramBucket, 0)
+	err := d.db.Select(&hours,
+		`SELECT extract(hour FROM f.first_seen)::int AS key, count(*) AS flights
+		 FROM flight f
+		 WHERE `+airlineFlights+`
+		 GROUP BY key ORDER BY key`, icao)
+	return hours, err
+}
+
+// GetAirlineWeekdays counts the airline's flights by the UTC day of the week
+// they were first seen, from 1 (Monday) to 7 (Sunday).
+func (d *DAO) GetAirlineWeekdays(icao string) ([]HistogramBucket, error) {
+	days := make([]HistogramBucket, 0)
+	err := d.db.Select(&days,
+		`SELECT extract(isodow FROM f.first_seen)::int AS key, count(*) AS flights
+		 FROM flight f
+		 WHERE `+airlineFlights+`
+		 GROUP BY key ORDER BY key`, icao)
+	return days, err
+}
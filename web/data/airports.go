@@ -0,0 +1,138 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Airport struct {
+	Ident        string         `db:"ident"`
+	Type         string         `db:"type"`
+	Name         string         `db:"name"`
+	Latitude     float64        `db:"latitude"`
+	Longitude    float64        `db:"longitude"`
+	Elevation    sql.NullInt64  `db:"elevation"`
+	Country      sql.NullString `db:"country"`
+	Region       sql.NullString `db:"region"`
+	Municipality sql.NullString `db:"municipality"`
+	IATA         sql.NullString `db:"iata"`
+}
+
+// AirportActivity is an airport with the number of flights that departed
+// from and arrived at it.
+type AirportActivity struct {
+	Airport
+	Departures int `db:"departures"`
+	Arrivals   int `db:"arrivals"`
+}
+
+// RunwayEnd is one end of a runway. The heading is true.
+type RunwayEnd struct {
+	Ident   string          `db:"ident"`
+	Heading sql.NullFloat64 `db:"heading"`
+	Length  sql.NullInt64   `db:"length"`
+	Surface sql.NullString  `db:"surface"`
+}
+
+// RunwayUse is the number of departures from and arrivals on a runway end in
+// an hour (UTC).
+type RunwayUse struct {
+	Hour       time.Time `db:"hour"`
+	Runway     string    `db:"runway"`
+	Departures int       `db:"departures"`
+	Arrivals   int       `db:"arrivals"`
+}
+
+const airportColumns = `a.ident, a.type, a.name, a.latitude, a.longitude, a.elevation,
+	a.country, a.region, a.municipality, a.iata`
+
+// GetAirport returns the airport with the ident, or failing that the IATA
+// code, given. It returns sql.ErrNoRows if there is none.
+func (d *DAO) GetAirport(code string) (Airport, error) {
+	airport := Airport{}
+	err := d.db.Get(&airport,
+		`SELECT `+airportColumns+` FROM airport a
+		 WHERE a.ident = $1 OR a.iata = $1
+		 ORDER BY a.ident = $1 DESC, a.type LIMIT 1`, code)
+	return airport, err
+}
+
+// GetAirportRunways returns the ends of the airport's runways.
+func (d *DAO) GetAirportRunways(ident string) ([]RunwayEnd, error) {
+	runways := make([]RunwayEnd, 0)
+	err := d.db.Select(&runways,
+		`SELECT ident, heading, length, surface FROM runway
+		 WHERE airport = $1 ORDER BY ident`, ident)
+	return runways, err
+}
+
+// GetAirports returns the airports that flights departed from or arrived at
+// since the given time, busiest first.
+func (d *DAO) GetAirports(since time.Time) ([]AirportActivity, error) {
+	airports := make([]AirportActivity, 0)
+	err := d.db.Select(&airports,
+		`WITH movements AS (
+		   SELECT departure_airport AS ident, 1 AS departures, 0 AS arrivals FROM flight
+		   WHERE departure_airport IS NOT NULL AND first_seen >= $1
+		   UNION ALL
+		   SELECT arrival_airport, 0, 1 FROM flight
+		   WHERE arrival_airport IS NOT NULL AND last_seen >= $1
+		 )
+		 SELECT `+airportColumns+`, sum(m.departures) AS departures, sum(m.arrivals) AS arrivals
+		 FROM movements m
+		 JOIN airport a ON a.ident = m.ident
+		 GROUP BY a.ident
+		 ORDER BY count(*) DESC, a.ident`, since)
+	return airports, err
+}
+
+// GetAirportDepartures returns the flights that departed from the airport
+// between start and end, latest first.
+func (d *DAO) GetAirportDepartures(ident string, start, end time.Time) ([]Flight, error) {
+	flights := make([]Flight, 0)
+	err := d.db.Select(&flights, baseFlightQuery+
+		`WHERE f.departure_airport = $1 AND f.first_seen >= $2 AND f.first_seen < $3
+		 ORDER BY f.first_seen DESC`, ident, start, end)
+	return flights, err
+}
+
+// GetAirportArrivals returns the flights that arrived at the airport between
+// start and end, latest first.
+func (d *DAO) GetAirportArrivals(ident string, start, end time.Time) ([]Flight, error) {
+	flights := make([]Flight, 0)
+	err := d.db.Select(&flights, baseFlightQuery+
+		`WHERE f.arrival_airport = $1 AND f.last_seen >= $2 AND f.last_seen < $3
+		 ORDER BY f.last_seen DESC`, ident, start, end)
+	return flights, err
+}
+
+// GetAirportDailyMovements counts the departures and arrivals at the airport
+// on each UTC day since the start of the day since. Keys are days after
+// since.
+func (d *DAO) GetAirportDailyMovements(ident string, since time.Time) ([]HistogramBucket, error) {
+	days := make([]HistogramBucket, 0)
+	err := d.db.Select(&days,
+		`SELECT (t::date - $2::date) AS key, count(*) AS flights
+		 FROM (SELECT first_seen AS t FROM flight WHERE departure_airport = $1 AND first_seen >= $2::date
+		       UNION ALL
+		       SELECT last_seen FROM flight WHERE arrival_airport = $1 AND last_seen >= $2::date) m
+		 GROUP BY key ORDER BY key`, ident, since)
+	return days, err
+}
+
+// GetAirportRunwayUse counts the departures and arrivals on each runway end
+// of the airport in each hour since the given time, in time order.
+func (d *DAO) GetAirportRunwayUse(ident string, since time.Time) ([]RunwayUse, error) {
+	uses := make([]RunwayUse, 0)
+	err := d.db.Select(&uses,
+		`SELECT date_trunc('hour', t) AS hour, runway,
+		        count(*) FILTER (WHERE departure) AS departures,
+		        count(*) FILTER (WHERE NOT departure) AS arrivals
+		 FROM (SELECT first_seen AS t, departure_runway AS runway, true AS departure FROM flight
+		       WHERE departure_airport = $1 AND departure_runway IS NOT NULL AND first_seen >= $2
+		       UNION ALL
+		       SELECT last_seen, arrival_runway, false FROM flight
+		       WHERE arrival_airport = $1 AND arrival_runway IS NOT NULL AND last_seen >= $2) m
+		 GROUP BY hour, runway ORDER BY hour, runway`, ident, since)
+	return uses, err
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CoverageRange is the farthest position received in a bearing sector and
+// altitude band, as kept by the coverage package.
+type CoverageRange struct {
+	Sector    int       `db:"sector"`
+	Band      int       `db:"band"`
+	RangeNM   float64   `db:"range_nm"`
+	Latitude  float64   `db:"latitude"`
+	Longitude float64   `db:"longitude"`
+	Altitude  int       `db:"altitude"`
+	FlightID  int       `db:"flight_id"`
+	Time      time.Time `db:"time"`
+}
+
+// GetCoverageRanges returns the farthest position in every sector and band
+// that has one.
+func (d *DAO) GetCoverageRanges() ([]CoverageRange, error) {
+	ranges := make([]CoverageRange, 0)
+	err := d.db.Select(&ranges,
+		`SELECT sector, band, range_nm, latitude, longitude, altitude, flight_id, time
+		 FROM coverage_range ORDER BY band, sector`)
+	return ranges, err
+}
+
+// GetCoverageUpdated returns when the coverage statistics were last
+// refreshed.
+func (d *DAO) GetCoverageUpdated() (pq.NullTime, error) {
+	var updated pq.NullTime
+	err := d.db.Get(&updated, `SELECT updated_at FROM coverage_progress`)
+	return updated, err
+}
+
+// GetHourlyMessages counts the messages received in each UTC hour since the
+// start of the hour since. Keys are hours after since.
+func (d *DAO) GetHourlyMessages(since time.Time) ([]HistogramBucket, error) {
+	return d.hourlyCoverage("messages", since)
+}
+
+// GetHourlyAircraft counts the aircraft seen in each UTC hour since the start
+// of the hour since. Keys are hours after since.
+func (d *DAO) GetHourlyAircraft(since time.Time) ([]HistogramBucket, error) {
+	return d.hourlyCoverage("aircraft", since)
+}
+
+func (d *DAO) hourlyCoverage(column string, since time.Time) ([]HistogramBucket, error) {
+	hours := make([]HistogramBucket, 0)
+	err := d.db.Select(&hours,
+		`SELECT (extract(epoch FROM hour - date_trunc('hour', $1::timestamp)) / 3600)::int AS key,
+		        `+column+` AS flights
+		 FROM coverage_hourly
+		 WHERE hour >= date_trunc('hour', $1::timestamp)
+		 ORDER BY key`, since)
+	return hours, err
+}
+
+// GetDailyMessages counts the messages received on each UTC day since the
+// start of the day since. Keys are days after since.
+func (d *DAO) GetDailyMessages(since time.Time) ([]HistogramBucket, error) {
+	days := make([]HistogramBucket, 0)
+	err := d.db.Select(&days,
+		`SELECT (hour::date - $1::date) AS key, sum(messages) AS flights
+		 FROM coverage_hourly
+		 WHERE hour >= $1::date
+		 GROUP BY key ORDER BY key`, since)
+	return days, err
+}
+
+// GetAircraftByHourOfDay returns the average number of aircraft seen in each
+// UTC hour of the day, over the hours since the given time. Keys are hours of
+// the day.
+func (d *DAO) GetAircraftByHourOfDay(since time.Time) ([]HistogramBucket, error) {
+	hours := make([]HistogramBucket, 0)
+	err := d.db.Select(&hours,
+		`SELECT extract(hour FROM hour)::int AS key, round(avg(aircraft))::int AS flights
+		 FROM coverage_hourly
+		 WHERE hour >= $1
+		 GROUP BY key ORDER BY key`, since)
+	return hours, err
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// exportFetchSize is the number of rows fetched from the cursor at a time.
+const exportFetchSize = 10000
+
+// ExportFlights calls fn with each flight matching q, oldest first. Rows are
+// read from a server-side cursor, so any number of flights can be exported
+// without holding them all in memory.
+func (d *DAO) ExportFlights(q FlightQuery, fn func(Flight) error) error {
+	where, args := q.where()
+	return d.cursor(baseFlightQuery+where+`ORDER BY f.first_seen, f.id`, args,
+		func(rows *sqlx.Rows) error {
+			var f Flight
+			if err := rows.StructScan(&f); err != nil {
+				return err
+			}
+			return fn(f)
+		})
+}
+
+// ExportTrackLogs calls fn with each track log entry of the flights matching
+// q, in order of flight and time. Like ExportFlights, it reads from a
+// server-side cursor.
+func (d *DAO) ExportTrackLogs(q FlightQuery, fn func(TrackLog) error) error {
+	where, args := q.where()
+	return d.cursor(
+		`SELECT t.id, t.flight_id, t.time, t.latitude, t.longitude, t.heading, t.speed,
+		        t.altitude, t.vs, t.callsign, t.phase
+		 FROM tracklog t
+		 WHERE t.flight_id IN (SELECT f.id`+flightTables+where+`)
+		 ORDER BY t.flight_id, t.time`, args,
+		func(rows *sqlx.Rows) error {
+			var t TrackLog
+			if err := rows.StructScan(&t); err != nil {
+				return err
+			}
+			return fn(t)
+		})
+}
+
+// cursor runs query in a read-only transaction through a cursor, calling fn
+// for each row.
+func (d *DAO) cursor(query string, args []interface{}, fn func(*sqlx.Rows) error) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SET TRANSACTION READ ONLY`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DECLARE export_cursor NO SCROLL CURSOR FOR `+query, args...); err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM export_cursor`, exportFetchSize)
+	for {
+		rows, err := tx.Queryx(fetch)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			if err = fn(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			break
+		}
+	}
+	return tx.Commit()
+}
@@ -26,27 +26,48 @@ type Flight struct {
 	IconY          int
 	Category       sql.NullInt64 `db:"category"`
 	CategoryString string
+	SplitReason    sql.NullString `db:"split_reason"`
+
+	// Departure and Arrival are the idents of the airports the flight took
+	// off from and landed at, as inferred by the airport package.
+	Departure       sql.NullString `db:"departure_airport"`
+	DepartureName   sql.NullString `db:"departure_name"`
+	DepartureRunway sql.NullString `db:"departure_runway"`
+	Arrival         sql.NullString `db:"arrival_airport"`
+	ArrivalName     sql.NullString `db:"arrival_name"`
+	ArrivalRunway   sql.NullString `db:"arrival_runway"`
 }
 
 type TrackLog struct {
 	ID                           int `db:"id"`
+	FlightID                     int `db:"flight_id"`
 	Time                         time.Time
 	Latitude, Longitude          sql.NullFloat64
 	Heading, Speed, Altitude, Vs sql.NullInt64
 	Callsign                     sql.NullString
+	Phase                        sql.NullString
 }
 
 const baseFlightQuery = `
-	SELECT f.id, f.icao, f.callsign, f.first_seen, f.last_seen, f.msg_count, f.category,
+	SELECT f.id, f.icao, f.callsign, f.first_seen, f.last_seen, f.msg_count, f.category, f.split_reason,
+		   f.departure_airport, dep.name AS departure_name, f.departure_runway,
+		   f.arrival_airport, arr.name AS arrival_name, f.arrival_runway,
 		   r.registration, r.owner, a.name AS airline, r.typecode, r.mfg, r.model,
 		   CASE
 			 WHEN r.year IS NULL THEN null
 			 WHEN r.year < 1850 THEN null
 			 ELSE r.year
 		   END AS year
+	` + flightTables
+
+// flightTables are the flight table and the tables joined to it for the
+// details in Flight.
+const flightTables = `
 	FROM flight f
 	LEFT OUTER JOIN registration r ON f.icao=r.icao
 	LEFT OUTER JOIN airline a ON a.icao=substring(f.callsign from 1 for 3) AND f.icao NOT LIKE 'ae%'
+	LEFT OUTER JOIN airport dep ON dep.ident=f.departure_airport
+	LEFT OUTER JOIN airport arr ON arr.ident=f.arrival_airport
 	`
 
 func (d *DAO) GetFlight(id int) (Flight, error) {
@@ -85,9 +106,81 @@ func (d *DAO) GetFlightsForAirframe(icao string) ([]Flight, error) {
 func (d *DAO) GetTrackLog(flightID int) ([]TrackLog, error) {
 	tracklog := make([]TrackLog, 0)
 	err := d.db.Select(&tracklog,
-		`SELECT id, time, latitude, longitude, heading, speed, altitude, vs, callsign
+		`SELECT id, time, latitude, longitude, heading, speed, altitude, vs, callsign, phase
 	 	 FROM tracklog
 		 WHERE flight_id=$1
 		 ORDER BY time`, flightID)
 	return tracklog, err
 }
+
+// GetTrackLogs returns the track logs of several flights, by flight ID.
+func (d *DAO) GetTrackLogs(flightIDs []int) (map[int][]TrackLog, error) {
+	ids := make([]int64, len(flightIDs))
+	for i, id := range flightIDs {
+		ids[i] = int64(id)
+	}
+	var rows []TrackLog
+	err := d.db.Select(&rows,
+		`SELECT id, flight_id, time, latitude, longitude, heading, speed, altitude, vs, callsign, phase
+		 FROM tracklog
+		 WHERE flight_id = ANY($1)
+		 ORDER BY flight_id, time`, pq.Int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	tracklogs := make(map[int][]TrackLog)
+	for _, t := range rows {
+		tracklogs[t.FlightID] = append(tracklogs[t.FlightID], t)
+	}
+	return tracklogs, nil
+}
+
+// GetFlightsBetween returns the flights that were being received at any time
+// from start to end, in the order they were first seen. Flights still in
+// progress count as being received up to now.
+func (d *DAO) GetFlightsBetween(start, end time.Time) ([]Flight, error) {
+	flights := make([]Flight, 0)
+	err := d.db.Select(&flights,
+		baseFlightQuery+
+			`WHERE f.first_seen < $2
+			   AND f.first_seen >= $1::timestamp - interval '1 day'
+			   AND coalesce(f.last_seen, CURRENT_TIMESTAMP AT TIME ZONE 'UTC') >= $1
+			 ORDER BY f.first_seen, f.id`,
+		start.UTC(), end.UTC())
+	return flights, err
+}
+
+// GetTrackPositionsBetween returns the track log entries with a position of
+// each of the flights from start to end, by flight ID, in time order.
+func (d *DAO) GetTrackPositionsBetween(flightIDs []int, start, end time.Time) (map[int][]TrackLog, error) {
+	ids := make([]int64, len(flightIDs))
+	for i, id := range flightIDs {
+		ids[i] = int64(id)
+	}
+	var rows []TrackLog
+	err := d.db.Select(&rows,
+		`SELECT id, flight_id, time, latitude, longitude, heading, speed, altitude, vs, callsign, phase
+		 FROM tracklog
+		 WHERE flight_id = ANY($1) AND time >= $2 AND time <= $3
+		   AND latitude IS NOT NULL AND longitude IS NOT NULL
+		 ORDER BY flight_id, time`, pq.Int64Array(ids), start.UTC(), end.UTC())
+	if err != nil {
+		return nil, err
+	}
+	tracklogs := make(map[int][]TrackLog)
+	for _, t := range rows {
+		tracklogs[t.FlightID] = append(tracklogs[t.FlightID], t)
+	}
+	return tracklogs, nil
+}
+
+// GetActiveFlightID returns the ID of the aircraft's flight in progress.
+func (d *DAO) GetActiveFlightID(icao string) (int, error) {
+	var id int
+	err := d.db.Get(&id,
+		`SELECT id FROM flight
+		 WHERE icao = $1 AND last_seen IS NULL
+		 ORDER BY first_seen DESC
+		 LIMIT 1`, icao)
+	return id, err
+}
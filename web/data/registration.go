@@ -2,6 +2,8 @@ package data
 
 import (
 	"database/sql"
+
+	"github.com/lib/pq"
 )
 
 type Registration struct {
@@ -46,3 +48,23 @@ func (d *DAO) SearchRegistration(query string) (string, error) {
 	}
 	return icao, nil
 }
+
+// GetTypeCodes returns the type codes of the given aircraft, for those that
+// have one.
+func (d *DAO) GetTypeCodes(icaos []string) (map[string]string, error) {
+	var rows []struct {
+		Icao     string `db:"icao"`
+		Typecode string `db:"typecode"`
+	}
+	err := d.db.Select(&rows,
+		`SELECT icao, typecode FROM registration
+		 WHERE icao = ANY($1) AND typecode IS NOT NULL`, pq.Array(icaos))
+	if err != nil {
+		return nil, err
+	}
+	typecodes := make(map[string]string)
+	for _, r := range rows {
+		typecodes[r.Icao] = r.Typecode
+	}
+	return typecodes, nil
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FlightQuery selects flights. Fields left empty don't restrict the search.
+type FlightQuery struct {
+	Start, End   time.Time // first seen in [Start, End)
+	Callsign     string    // * matches any characters
+	Icao         string
+	Registration string
+	TypeCode     string
+	Airline      string // three-letter ICAO airline designator, the callsign prefix; see airlineFlights
+	Owner        string // any part of the registered owner's name
+}
+
+// where returns the WHERE clause for the query and its arguments.
+func (q FlightQuery) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	// The flight times are UTC timestamps without a time zone
+	if !q.Start.IsZero() {
+		add("f.first_seen >= $%d", q.Start.UTC())
+	}
+	if !q.End.IsZero() {
+		add("f.first_seen < $%d", q.End.UTC())
+	}
+	if q.Callsign != "" {
+		if strings.Contains(q.Callsign, "*") {
+			add("f.callsign LIKE $%d", wildcardPattern(q.Callsign))
+		} else {
+			add("f.callsign = $%d", strings.ToUpper(q.Callsign))
+		}
+	}
+	if q.Icao != "" {
+		add("f.icao = $%d", strings.ToLower(q.Icao))
+	}
+	if q.Registration != "" {
+		add("r.registration = $%d", strings.ToUpper(q.Registration))
+	}
+	if q.TypeCode != "" {
+		add("r.typecode = $%d", strings.ToUpper(q.TypeCode))
+	}
+	if q.Airline != "" {
+		add("substring(f.callsign from 1 for 3) = $%d AND f.icao NOT LIKE 'ae%%'", strings.ToUpper(q.Airline))
+	}
+	if q.Owner != "" {
+		add("r.owner ILIKE $%d", "%"+escapeLike(q.Owner)+"%")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND ") + "\n", args
+}
+
+// wildcardPattern turns a search with * wildcards into a LIKE pattern.
+func wildcardPattern(s string) string {
+	return strings.Replace(escapeLike(strings.ToUpper(s)), "*", "%", -1)
+}
+
+// escapeLike escapes the characters that are special in LIKE patterns.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchFlights returns a page of the flights matching q, newest first, and
+// the total number of matching flights.
+func (d *DAO) SearchFlights(q FlightQuery, limit, offset int) ([]Flight, int, error) {
+	where, args := q.where()
+
+	var total int
+	err := d.db.Get(&total, `SELECT count(*)`+flightTables+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	flights := make([]Flight, 0)
+	if limit == 0 || offset >= total {
+		return flights, total, nil
+	}
+	args = append(args, limit, offset)
+	err = d.db.Select(&flights, baseFlightQuery+where+
+		fmt.Sprintf(`ORDER BY f.first_seen DESC, f.id DESC
+		 LIMIT $%d OFFSET $%d`, len(args)-1, len(args)), args...)
+	return flights, total, err
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TypeActivity is an aircraft type with a summary of its flights. The
+// manufacturer and model are the most common for airframes of the type.
+type TypeActivity struct {
+	TypeCode  string         `db:"typecode"`
+	Mfg       sql.NullString `db:"mfg"`
+	Model     sql.NullString `db:"model"`
+	Flights   int            `db:"flights"`
+	Airframes int            `db:"airframes"`
+	FirstSeen pq.NullTime    `db:"first_seen"`
+	LastSeen  pq.NullTime    `db:"last_seen"`
+}
+
+// TypePerformance summarizes the altitudes (feet) and speeds (knots) flown by
+// an aircraft type, over the flights that reported them.
+type TypePerformance struct {
+	Flights           int             `db:"flights"`
+	MedianMaxAlt      sql.NullFloat64 `db:"median_max_alt"`
+	P90MaxAlt         sql.NullFloat64 `db:"p90_max_alt"`
+	HighestAlt        sql.NullInt64   `db:"highest_alt"`
+	MedianCruiseAlt   sql.NullFloat64 `db:"median_cruise_alt"`
+	MedianCruiseSpeed sql.NullFloat64 `db:"median_cruise_speed"`
+	MedianMaxSpeed    sql.NullFloat64 `db:"median_max_speed"`
+	FastestSpeed      sql.NullInt64   `db:"fastest_speed"`
+	CruiseFlights     int             `db:"cruise_flights"`
+}
+
+const typeActivityColumns = `
+	SELECT r.typecode, mode() WITHIN GROUP (ORDER BY r.mfg) AS mfg,
+	       mode() WITHIN GROUP (ORDER BY r.model) AS model,
+	       count(f.id) AS flights, count(DISTINCT f.icao) AS airframes,
+	       min(f.first_seen) AS first_seen, max(coalesce(f.last_seen, f.first_seen)) AS last_seen
+	`
+
+// GetTypes returns every aircraft type that has been seen, busiest first.
+func (d *DAO) GetTypes() ([]TypeActivity, error) {
+	types := make([]TypeActivity, 0)
+	err := d.db.Select(&types, typeActivityColumns+
+		`FROM flight f
+		 JOIN registration r ON r.icao = f.icao
+		 WHERE r.typecode IS NOT NULL
+		 GROUP BY r.typecode
+		 ORDER BY flights DESC, r.typecode`)
+	return types, err
+}
+
+// GetTypeActivity summarizes the flights of one type. It returns
+// sql.ErrNoRows if no registered aircraft are of the type.
+func (d *DAO) GetTypeActivity(typecode string) (TypeActivity, error) {
+	activity := TypeActivity{}
+	err := d.db.Get(&activity, typeActivityColumns+
+		`FROM registration r
+		 LEFT OUTER JOIN flight f ON f.icao = r.icao
+		 WHERE r.typecode = $1
+		 GROUP BY r.typecode`, typecode)
+	return activity, err
+}
+
+// GetTypeAirframes returns the airframes of the type that have been seen,
+// busiest first.
+func (d *DAO) GetTypeAirframes(typecode string) ([]FleetAircraft, error) {
+	airframes := make([]FleetAircraft, 0)
+	err := d.db.Select(&airframes,
+		`SELECT f.icao, r.registration, r.typecode, r.mfg, r.model, r.owner, count(*) AS flights,
+		        min(f.first_seen) AS first_seen, max(coalesce(f.last_seen, f.first_seen)) AS last_seen
+		 FROM flight f
+		 JOIN registration r ON r.icao = f.icao
+		 WHERE r.typecode = $1
+		 GROUP BY f.icao, r.registration, r.typecode, r.mfg, r.model, r.owner
+		 ORDER BY flights DESC, f.icao`, typecode)
+	return airframes, err
+}
+
+// GetTypeDailyFlights counts the type's flights on each UTC day since the
+// start of the day since. Keys are days after since.
+func (d *DAO) GetTypeDailyFlights(typecode string, since time.Time) ([]HistogramBucket, error) {
+	days := make([]HistogramBucket, 0)
+	err := d.db.Select(&days,
+		`SELECT (f.first_seen::date - $2::date) AS key, count(*) AS flights
+		 FROM flight f
+		 JOIN registration r ON r.icao = f.icao
+		 WHERE r.typecode = $1 AND f.first_seen >= $2::date
+		 GROUP BY key ORDER BY key`, typecode, since)
+	return days, err
+}
+
+// GetTypePerformance summarizes the altitudes and speeds in the track logs of
+// the type's flights first seen since the given time. Each flight counts
+// once: for its highest altitude and speed, and for its median altitude and
+// speed in cruise.
+func (d *DAO) GetTypePerformance(typecode string, since time.Time) (TypePerformance, error) {
+	perf := TypePerformance{}
+	err := d.db.Get(&perf,
+		`WITH per_flight AS (
+		   SELECT t.flight_id, max(t.altitude) AS max_alt, max(t.speed) AS max_speed,
+		          percentile_cont(0.5) WITHIN GROUP (ORDER BY t.altitude) FILTER (WHERE t.phase = 'cruise') AS cruise_alt,
+		          percentile_cont(0.5) WITHIN GROUP (ORDER BY t.speed) FILTER (WHERE t.phase = 'cruise') AS cruise_speed
+		   FROM flight f
+		   JOIN registration r ON r.icao = f.icao
+		   JOIN tracklog t ON t.flight_id = f.id
+		   WHERE r.typecode = $1 AND f.first_seen >= $2
+		   GROUP BY t.flight_id
+		 )
+		 SELECT count(*) AS flights,
+		        percentile_cont(0.5) WITHIN GROUP (ORDER BY max_alt) AS median_max_alt,
+		        percentile_cont(0.9) WITHIN GROUP (ORDER BY max_alt) AS p90_max_alt,
+		        max(max_alt) AS highest_alt,
+		        percentile_cont(0.5) WITHIN GROUP (ORDER BY cruise_alt) AS median_cruise_alt,
+		        percentile_cont(0.5) WITHIN GROUP (ORDER BY cruise_speed) AS median_cruise_speed,
+		        percentile_cont(0.5) WITHIN GROUP (ORDER BY max_speed) AS median_max_speed,
+		        max(max_speed) AS fastest_speed,
+		        count(cruise_alt) AS cruise_flights
+		 FROM per_flight`, typecode, since)
+	return perf, err
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Zone is a geofence: a circle if Polygon is NULL, or a polygon stored as a
+// JSON array of [latitude, longitude] vertices.
+type Zone struct {
+	ID          int             `db:"id"`
+	Name        string          `db:"name"`
+	Description sql.NullString  `db:"description"`
+	Latitude    sql.NullFloat64 `db:"latitude"`
+	Longitude   sql.NullFloat64 `db:"longitude"`
+	Radius      sql.NullFloat64 `db:"radius_nm"`
+	Polygon     sql.NullString  `db:"polygon"`
+	Floor       sql.NullInt64   `db:"floor"`
+	Ceiling     sql.NullInt64   `db:"ceiling"`
+	Events      int             `db:"events"`
+	LastEvent   pq.NullTime     `db:"last_event"`
+}
+
+// ZoneEvent is a flight entering or leaving a zone, with the zone's name and
+// the flight's identity.
+type ZoneEvent struct {
+	ID           int64          `db:"id"`
+	ZoneID       int            `db:"zone_id"`
+	ZoneName     string         `db:"zone_name"`
+	FlightID     int            `db:"flight_id"`
+	Event        string         `db:"event"`
+	Time         time.Time      `db:"time"`
+	Latitude     float64        `db:"latitude"`
+	Longitude    float64        `db:"longitude"`
+	Altitude     sql.NullInt64  `db:"altitude"`
+	Icao         string         `db:"icao"`
+	Callsign     sql.NullString `db:"callsign"`
+	Registration sql.NullString `db:"registration"`
+	TypeCode     sql.NullString `db:"typecode"`
+}
+
+const zoneColumns = `
+	SELECT z.id, z.name, z.description, z.latitude, z.longitude, z.radius_nm, z.polygon::text AS polygon,
+	       z.floor, z.ceiling,
+	       (SELECT count(*) FROM zone_event e WHERE e.zone_id = z.id) AS events,
+	       (SELECT max(e.time) FROM zone_event e WHERE e.zone_id = z.id) AS last_event
+	FROM zone z
+	`
+
+const zoneEventQuery = `
+	SELECT e.id, e.zone_id, z.name AS zone_name, e.flight_id, e.event, e.time,
+	       e.latitude, e.longitude, e.altitude, f.icao, f.callsign, r.registration, r.typecode
+	FROM zone_event e
+	JOIN zone z ON z.id = e.zone_id
+	JOIN flight f ON f.id = e.flight_id
+	LEFT OUTER JOIN registration r ON r.icao = f.icao
+	`
+
+// GetZones returns every zone, by name.
+func (d *DAO) GetZones() ([]Zone, error) {
+	zones := make([]Zone, 0)
+	err := d.db.Select(&zones, zoneColumns+`ORDER BY z.name`)
+	return zones, err
+}
+
+// GetZone returns a zone, or sql.ErrNoRows if there is no such zone.
+func (d *DAO) GetZone(id int) (Zone, error) {
+	zone := Zone{}
+	err := d.db.Get(&zone, zoneColumns+`WHERE z.id = $1`, id)
+	return zone, err
+}
+
+// GetZoneEvents returns the latest limit events, in any zone if zoneID is 0,
+// latest first.
+func (d *DAO) GetZoneEvents(zoneID int, limit int) ([]ZoneEvent, error) {
+	events := make([]ZoneEvent, 0)
+	if zoneID == 0 {
+		err := d.db.Select(&events, zoneEventQuery+
+			`ORDER BY e.time DESC, e.id DESC LIMIT $1`, limit)
+		return events, err
+	}
+	err := d.db.Select(&events, zoneEventQuery+
+		`WHERE e.zone_id = $1
+		 ORDER BY e.time DESC, e.id DESC LIMIT $2`, zoneID, limit)
+	return events, err
+}
+
+// GetFlightZoneEvents returns a flight's zone events in time order.
+func (d *DAO) GetFlightZoneEvents(flightID int) ([]ZoneEvent, error) {
+	events := make([]ZoneEvent, 0)
+	err := d.db.Select(&events, zoneEventQuery+
+		`WHERE e.flight_id = $1
+		 ORDER BY e.time, e.id`, flightID)
+	return events, err
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/tracker"
+)
+
+// In realtime mode, /data/ serves the JSON files that dump1090 writes to its
+// data directory, so front-ends written for dump1090 (its own map, tar1090,
+// and assorted dashboards) can be pointed at flighttrack instead. Only the
+// fields that flighttrack tracks are included.
+
+var receiverLat = flag.Float64("receiverlat", 45.52197, "Receiver latitude, for the maps, coverage statistics and /data/receiver.json")
+var receiverLon = flag.Float64("receiverlon", -122.92629, "Receiver longitude, for the maps, coverage statistics and /data/receiver.json")
+
+// Like dump1090, keep a snapshot of aircraft.json every 30 seconds for the
+// last hour.
+const dump1090HistoryInterval = 30 * time.Second
+const dump1090HistorySize = 120
+
+// dump1090Refresh is how often, in milliseconds, clients are told to reload
+// aircraft.json.
+const dump1090Refresh = 1000
+
+type dump1090Aircraft struct {
+	Hex        string      `json:"hex"`
+	Flight     string      `json:"flight,omitempty"`
+	AltBaro    interface{} `json:"alt_baro,omitempty"` // feet, or "ground"
+	GS         *float64    `json:"gs,omitempty"`
+	IAS        *int        `json:"ias,omitempty"`
+	TAS        *int        `json:"tas,omitempty"`
+	Track      *float64    `json:"track,omitempty"`
+	MagHeading *float64    `json:"mag_heading,omitempty"`
+	BaroRate   *int        `json:"baro_rate,omitempty"`
+	Squawk     string      `json:"squawk,omitempty"`
+	Category   string      `json:"category,omitempty"`
+	Lat        *float64    `json:"lat,omitempty"`
+	Lon        *float64    `json:"lon,omitempty"`
+	SeenPos    *float64    `json:"seen_pos,omitempty"`
+	Messages   int         `json:"messages"`
+	Seen       float64     `json:"seen"`
+	RSSI       *float64    `json:"rssi,omitempty"`
+}
+
+type dump1090Data struct {
+	Now      float64            `json:"now"`
+	Messages uint64             `json:"messages"`
+	Aircraft []dump1090Aircraft `json:"aircraft"`
+}
+
+type dump1090Receiver struct {
+	Version string  `json:"version"`
+	Refresh int     `json:"refresh"`
+	History int     `json:"history"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+// dump1090Server builds dump1090's JSON from the tracker.
+type dump1090Server struct {
+	track    *tracker.Tracker
+	messages func() uint64 // total messages received
+
+	mu      sync.Mutex
+	history [][]byte
+	next    int // index of the next history slot to fill
+}
+
+func newDump1090Server(track *tracker.Tracker, messages func() uint64) *dump1090Server {
+	return &dump1090Server{track: track, messages: messages}
+}
+
+// seconds rounds a duration to tenths of a second, as dump1090 does.
+func seconds(d time.Duration) float64 {
+	return float64(d.Round(100*time.Millisecond)) / float64(time.Second)
+}
+
+func (s *dump1090Server) data() dump1090Data {
+	now := time.Now()
+	snapshot := s.track.Snapshot()
+	d := dump1090Data{
+		Now:      float64(now.Round(100*time.Millisecond).UnixNano()) / 1e9,
+		Messages: s.messages(),
+		Aircraft: make([]dump1090Aircraft, 0, len(snapshot)),
+	}
+	for _, a := range snapshot {
+		d.Aircraft = append(d.Aircraft, newDump1090Aircraft(a, now))
+	}
+	return d
+}
+
+func newDump1090Aircraft(a tracker.Aircraft, now time.Time) dump1090Aircraft {
+	cur := a.Current
+	j := dump1090Aircraft{
+		Hex:      a.IcaoID,
+		Category: a.Category.EmitterCategory(),
+		Messages: a.MessageCount,
+		Seen:     seconds(now.Sub(a.LastSeen)),
+	}
+	if a.Callsign != "" {
+		// dump1090 pads callsigns to eight characters
+		j.Flight = fmt.Sprintf("%-8s", a.Callsign)
+	}
+	if a.GroundValid && a.OnGround {
+		j.AltBaro = "ground"
+	} else if cur.AltitudeValid {
+		j.AltBaro = cur.Altitude
+	}
+	if cur.SpeedValid {
+		switch cur.SpeedType {
+		case decoder.SpeedGS:
+			gs := float64(cur.Speed)
+			j.GS = &gs
+		case decoder.SpeedIAS:
+			j.IAS = &cur.Speed
+		case decoder.SpeedTAS:
+			j.TAS = &cur.Speed
+		}
+	}
+	if cur.HeadingValid {
+		// With ground speed comes the track over the ground; with airspeed,
+		// the heading.
+		heading := float64(cur.Heading)
+		if cur.SpeedValid && cur.SpeedType != decoder.SpeedGS {
+			j.MagHeading = &heading
+		} else {
+			j.Track = &heading
+		}
+	}
+	if cur.VSValid {
+		j.BaroRate = &cur.VS
+	}
+	if cur.SquawkValid {
+		j.Squawk = cur.Squawk
+	}
+	if cur.PositionValid {
+		j.Lat, j.Lon = &cur.Latitude, &cur.Longitude
+		seenPos := seconds(now.Sub(a.PositionTime))
+		j.SeenPos = &seenPos
+	}
+	if a.RSSIValid {
+		rssi := math.Round(a.RSSI*10) / 10
+		j.RSSI = &rssi
+	}
+	return j
+}
+
+// recordHistory saves a snapshot for history_N.json every
+// dump1090HistoryInterval until ctx is canceled.
+func (s *dump1090Server) recordHistory(ctx context.Context) {
+	ticker := time.NewTicker(dump1090HistoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := json.Marshal(s.data())
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		if len(s.history) < dump1090HistorySize {
+			s.history = append(s.history, data)
+		} else {
+			s.history[s.next] = data
+		}
+		s.next = (s.next + 1) % dump1090HistorySize
+		s.mu.Unlock()
+	}
+}
+
+var dump1090HistoryFile = regexp.MustCompile(`^history_([0-9]+)\.json$`)
+
+// getDump1090Handler serves /data/:file.
+func getDump1090Handler(s *dump1090Server) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		file := c.Param("file")
+		switch file {
+		case "aircraft.json":
+			return c.JSON(http.StatusOK, s.data())
+		case "receiver.json":
+			s.mu.Lock()
+			history := len(s.history)
+			s.mu.Unlock()
+			return c.JSON(http.StatusOK, dump1090Receiver{
+				Version: "flighttrack",
+				Refresh: dump1090Refresh,
+				History: history,
+				Lat:     *receiverLat,
+				Lon:     *receiverLon,
+			})
+		}
+
+		if match := dump1090HistoryFile.FindStringSubmatch(file); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			s.mu.Lock()
+			var data []byte
+			if n < len(s.history) {
+				data = s.history[n]
+			}
+			s.mu.Unlock()
+			if data != nil {
+				return c.JSONBlob(http.StatusOK, data)
+			}
+		}
+		return echo.NewHTTPError(http.StatusNotFound, "No such file")
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/export"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// Flight tracks can be downloaded as KML (for Google Earth, with the path
+// extruded down to the ground at its altitude), GeoJSON and GPX, one flight
+// at a time from /flight/:id/export/:format, or all the flights for a range
+// of dates or an airframe from /export/:format.
+
+// maxExportFlights limits the number of flights in one batch export.
+const maxExportFlights = 2000
+
+const feetToMeters = 0.3048
+
+type exportFormat struct {
+	extension   string
+	contentType string
+	write       func(w io.Writer, title string, tracks []exportTrack) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"kml":     {"kml", "application/vnd.google-earth.kml+xml", writeKML},
+	"geojson": {"geojson", "application/geo+json", writeGeoJSON},
+	"gpx":     {"gpx", "application/gpx+xml", writeGPX},
+}
+
+type exportTrack struct {
+	Flight data.Flight
+	Points []exportPoint
+	HasAlt bool // whether the points have altitudes
+}
+
+// exportPoint is a track log entry with a position. Entries without an
+// altitude take the nearest earlier one, or the first one if there's none
+// earlier, so the path doesn't drop to sea level.
+type exportPoint struct {
+	Time     time.Time
+	Lat, Lon float64
+	Altitude float64 // meters
+	Log      data.TrackLog
+}
+
+func newExportTrack(flight data.Flight, tracklog []data.TrackLog) exportTrack {
+	t := exportTrack{Flight: flight}
+	var altitude float64
+	firstAlt := -1 // index of the first point with an altitude
+	for _, l := range tracklog {
+		if l.Altitude.Valid {
+			altitude = float64(l.Altitude.Int64) * feetToMeters
+			if firstAlt < 0 {
+				firstAlt = len(t.Points)
+			}
+		}
+		if !l.Latitude.Valid || !l.Longitude.Valid {
+			continue
+		}
+		t.Points = append(t.Points, exportPoint{
+			Time:     l.Time,
+			Lat:      l.Latitude.Float64,
+			Lon:      l.Longitude.Float64,
+			Altitude: altitude,
+			Log:      l,
+		})
+	}
+	if firstAlt >= 0 && firstAlt < len(t.Points) {
+		t.HasAlt = true
+		for i := 0; i < firstAlt; i++ {
+			t.Points[i].Altitude = t.Points[firstAlt].Altitude
+		}
+	}
+	return t
+}
+
+// name is the callsign if there is one, or else the registration or ICAO ID.
+func (t exportTrack) name() string {
+	switch {
+	case t.Flight.Callsign.Valid:
+		return t.Flight.Callsign.String
+	case t.Flight.Registration.Valid:
+		return t.Flight.Registration.String
+	}
+	return t.Flight.Icao
+}
+
+func (t exportTrack) description() string {
+	f := t.Flight
+	var parts []string
+	if f.Registration.Valid {
+		parts = append(parts, f.Registration.String)
+	}
+	parts = append(parts, f.Icao)
+	if f.TypeCode.Valid {
+		parts = append(parts, f.TypeCode.String)
+	}
+	if f.Airline.Valid {
+		parts = append(parts, f.Airline.String)
+	} else if f.Owner.Valid {
+		parts = append(parts, f.Owner.String)
+	}
+	return fmt.Sprintf("%s; first seen %s UTC", strings.Join(parts, ", "), f.FirstSeen.UTC().Format("2006-01-02 15:04:05"))
+}
+
+type kmlDocument struct {
+	XMLName xml.Name       `xml:"kml"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Name    string         `xml:"Document>name"`
+	Styles  []kmlStyle     `xml:"Document>Style"`
+	Marks   []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlStyle struct {
+	ID        string `xml:"id,attr"`
+	LineColor string `xml:"LineStyle>color"`
+	LineWidth int    `xml:"LineStyle>width"`
+	PolyColor string `xml:"PolyStyle>color"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description"`
+	Begin       string         `xml:"TimeSpan>begin"`
+	End         string         `xml:"TimeSpan>end"`
+	StyleURL    string         `xml:"styleUrl"`
+	LineString  *kmlGeometry   `xml:"LineString,omitempty"`
+	Point       *kmlGeometry   `xml:"Point,omitempty"`
+	Data        []kmlDataValue `xml:"ExtendedData>Data"`
+}
+
+type kmlGeometry struct {
+	Extrude      int    `xml:"extrude,omitempty"`
+	Tessellate   int    `xml:"tessellate,omitempty"`
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlDataValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+func writeKML(w io.Writer, title string, tracks []exportTrack) error {
+	doc := kmlDocument{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Name:  title,
+		// KML colors are aabbggrr
+		Styles: []kmlStyle{{ID: "track", LineColor: "ffcc9933", LineWidth: 3, PolyColor: "4dcc9933"}},
+	}
+	for _, t := range tracks {
+		if len(t.Points) == 0 {
+			continue
+		}
+		mark := kmlPlacemark{
+			Name:        t.name(),
+			Description: t.description(),
+			Begin:       t.Points[0].Time.UTC().Format(time.RFC3339),
+			End:         t.Points[len(t.Points)-1].Time.UTC().Format(time.RFC3339),
+			StyleURL:    "#track",
+			Data: []kmlDataValue{
+				{Name: "flight_id", Value: strconv.Itoa(t.Flight.ID)},
+				{Name: "icao", Value: t.Flight.Icao},
+			},
+		}
+		geometry := &kmlGeometry{AltitudeMode: "clampToGround"}
+		if t.HasAlt {
+			geometry.AltitudeMode = "absolute"
+			geometry.Extrude = 1
+		}
+		coords := make([]string, len(t.Points))
+		for i, p := range t.Points {
+			coords[i] = fmt.Sprintf("%.6f,%.6f,%.0f", p.Lon, p.Lat, p.Altitude)
+		}
+		geometry.Coordinates = strings.Join(coords, " ")
+		if len(t.Points) > 1 {
+			geometry.Tessellate = 1
+			mark.LineString = geometry
+		} else {
+			mark.Point = geometry
+		}
+		doc.Marks = append(doc.Marks, mark)
+	}
+
+	return writeXML(w, doc)
+}
+
+type gpxDocument struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Name    string     `xml:"metadata>name"`
+	Tracks  []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name        string     `xml:"name"`
+	Description string     `xml:"desc"`
+	Points      []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat       float64  `xml:"lat,attr"`
+	Lon       float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele,omitempty"`
+	Time      string   `xml:"time"`
+}
+
+func writeGPX(w io.Writer, title string, tracks []exportTrack) error {
+	doc := gpxDocument{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "flighttrack",
+		Name:    title,
+	}
+	for _, t := range tracks {
+		if len(t.Points) == 0 {
+			continue
+		}
+		trk := gpxTrack{Name: t.name(), Description: t.description()}
+		for _, p := range t.Points {
+			pt := gpxPoint{Lat: p.Lat, Lon: p.Lon, Time: p.Time.UTC().Format(time.RFC3339)}
+			if t.HasAlt {
+				alt := p.Altitude
+				pt.Elevation = &alt
+			}
+			trk.Points = append(trk.Points, pt)
+		}
+		doc.Tracks = append(doc.Tracks, trk)
+	}
+
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         int                    `json:"id"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// geoJSONPointProperties has the track log values for each point of the
+// LineString, in the same order, in the style of togeojson's
+// coordinateProperties. Values are null where they're unknown; altitudes here
+// are in feet, as reported, rather than the meters of the coordinates.
+type geoJSONPointProperties struct {
+	Times     []time.Time `json:"times"`
+	Altitudes []*int64    `json:"altitudes"`
+	Speeds    []*int64    `json:"speeds"`
+	Headings  []*int64    `json:"headings"`
+	VertRates []*int64    `json:"vertical_rates"`
+	Phases    []*string   `json:"phases"`
+	Callsigns []*string   `json:"callsigns"`
+}
+
+func writeGeoJSON(w io.Writer, title string, tracks []exportTrack) error {
+	features := make([]geoJSONFeature, 0, len(tracks))
+	for _, t := range tracks {
+		f := t.Flight
+		properties := map[string]interface{}{
+			"name":         t.name(),
+			"icao":         f.Icao,
+			"callsign":     nullString(f.Callsign),
+			"registration": nullString(f.Registration),
+			"type_code":    nullString(f.TypeCode),
+			"airline":      nullString(f.Airline),
+			"owner":        nullString(f.Owner),
+			"first_seen":   f.FirstSeen,
+			"last_seen":    nullTime(f.LastSeen),
+		}
+
+		var points geoJSONPointProperties
+		coords := make([][]float64, len(t.Points))
+		for i, p := range t.Points {
+			if t.HasAlt {
+				coords[i] = []float64{p.Lon, p.Lat, p.Altitude}
+			} else {
+				coords[i] = []float64{p.Lon, p.Lat}
+			}
+			points.Times = append(points.Times, p.Time)
+			points.Altitudes = append(points.Altitudes, nullInt(p.Log.Altitude))
+			points.Speeds = append(points.Speeds, nullInt(p.Log.Speed))
+			points.Headings = append(points.Headings, nullInt(p.Log.Heading))
+			points.VertRates = append(points.VertRates, nullInt(p.Log.Vs))
+			points.Phases = append(points.Phases, nullString(p.Log.Phase))
+			points.Callsigns = append(points.Callsigns, nullString(p.Log.Callsign))
+		}
+
+		// Flights with no position still appear, with a null geometry
+		var geometry *geoJSONGeometry
+		switch len(coords) {
+		case 0:
+		case 1:
+			geometry = &geoJSONGeometry{Type: "Point", Coordinates: coords[0]}
+		default:
+			geometry = &geoJSONGeometry{Type: "LineString", Coordinates: coords}
+		}
+		if len(coords) > 0 {
+			properties["coordinateProperties"] = points
+		}
+
+		features = append(features, geoJSONFeature{
+			Type:       "Feature",
+			ID:         f.ID,
+			Geometry:   geometry,
+			Properties: properties,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"name":     title,
+		"features": features,
+	})
+}
+
+// sendExport writes tracks as a file download.
+func sendExport(c echo.Context, format exportFormat, filename, title string, tracks []exportTrack) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, format.contentType)
+	w.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format.extension))
+	w.WriteHeader(http.StatusOK)
+	return format.write(w, title, tracks)
+}
+
+func exportFormatParam(c echo.Context) (exportFormat, error) {
+	format, ok := exportFormats[c.Param("format")]
+	if !ok {
+		return format, echo.NewHTTPError(http.StatusNotFound, "Unknown export format (must be kml, geojson or gpx)")
+	}
+	return format, nil
+}
+
+// getFlightExportHandler exports one flight's track.
+func getFlightExportHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		format, err := exportFormatParam(c)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid flight ID")
+		}
+		flight, err := dao.GetFlight(id)
+		if err != nil {
+			return err
+		}
+		tracklog, err := dao.GetTrackLog(id)
+		if err != nil {
+			return err
+		}
+
+		track := newExportTrack(flight, tracklog)
+		return sendExport(c, format, fmt.Sprintf("flight-%d", id), track.name(), []exportTrack{track})
+	}
+}
+
+// exportQuery reads the flights to export from the query parameters: those
+// first seen from the start date to the end date (inclusive; YYYY-MM-DD, or
+// YYYY-MM for a month), of one airframe (icao), or both. It also returns
+// names for the choices, for the file name.
+func exportQuery(c echo.Context) (q data.FlightQuery, names []string, err error) {
+	if icao := strings.ToLower(c.QueryParam("icao")); icao != "" {
+		if !icaoValidator.MatchString(icao) {
+			return q, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid ICAO/transponder ID (must be hex format)")
+		}
+		q.Icao = icao
+		names = append(names, icao)
+	}
+	if start := c.QueryParam("start"); start != "" {
+		end := c.QueryParam("end")
+		if q.Start, q.End, err = export.ParseDates(start, end); err != nil {
+			return q, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if end == "" || end == start {
+			names = append(names, start)
+		} else {
+			names = append(names, start+"-to-"+end)
+		}
+	}
+	if len(names) == 0 {
+		return q, nil, echo.NewHTTPError(http.StatusBadRequest, "Choose flights with a start date or an ICAO ID")
+	}
+	return q, names, nil
+}
+
+// getBatchExportHandler exports the tracks of the flights chosen by
+// exportQuery.
+func getBatchExportHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		format, err := exportFormatParam(c)
+		if err != nil {
+			return err
+		}
+
+		q, names, err := exportQuery(c)
+		if err != nil {
+			return err
+		}
+
+		flights, total, err := dao.SearchFlights(q, maxExportFlights, 0)
+		if err != nil {
+			return err
+		}
+		if total > maxExportFlights {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Too many flights (%d); export at most %d at a time", total, maxExportFlights))
+		}
+
+		ids := make([]int, len(flights))
+		for i, f := range flights {
+			ids[i] = f.ID
+		}
+		tracklogs, err := dao.GetTrackLogs(ids)
+		if err != nil {
+			return err
+		}
+
+		// SearchFlights has the newest first
+		tracks := make([]exportTrack, len(flights))
+		for i, f := range flights {
+			tracks[len(flights)-1-i] = newExportTrack(f, tracklogs[f.ID])
+		}
+		name := "flights-" + strings.Join(names, "-")
+		return sendExport(c, format, name, "Flights "+strings.Join(names, " "), tracks)
+	}
+}
+
+// getTableExportHandler streams the flights chosen by exportQuery, or their
+// track logs, as CSV or Parquet.
+func getTableExportHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		table := c.Param("table")
+		if table != "flights" && table != "tracklog" {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown table (must be flights or tracklog)")
+		}
+		format, err := export.ParseFormat(c.Param("format"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown export format (must be csv or parquet)")
+		}
+		q, names, err := exportQuery(c)
+		if err != nil {
+			return err
+		}
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, format.ContentType())
+		w.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.%s"`,
+			table, strings.Join(names, "-"), format.Extension()))
+		w.WriteHeader(http.StatusOK)
+		if err = export.Write(dao, table, q, format, w); err != nil {
+			// Too late to tell the client; the file is cut short
+			c.Logger().Error(err)
+		}
+		return nil
+	}
+}
@@ -1,5 +1,43 @@
 package main
 
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/racingmars/flighttrack/decoder"
+)
+
+// iconFor picks the icon for an aircraft from its type code, falling back to
+// its ADS-B category, and returns the icon's file name and size.
+func iconFor(typeCode sql.NullString, category sql.NullInt64) (icon string, x, y int) {
+	icon = "unknown.svg"
+	if typeCode.Valid {
+		if name, ok := typeToIcon[typeCode.String]; ok {
+			icon = fmt.Sprintf("%s.svg", name)
+		}
+	}
+
+	// Didn't find a type code match; try against ADS-B identification category
+	if icon == "unknown.svg" && category.Valid {
+		switch decoder.AircraftType(category.Int64) {
+		case decoder.ACTypeLight:
+			icon = "cessna.svg"
+		case decoder.ACTypeSmall:
+			icon = "jet_swept.svg"
+		case decoder.ACTypeLarge:
+			icon = "airliner.svg"
+		case decoder.ACTypeHighVortexLarge:
+			icon = "airliner.svg"
+		case decoder.ACTypeHeavy:
+			icon = "heavy_2e.svg"
+		case decoder.ACTypeRotocraft:
+			icon = "helicopter.svg"
+		}
+	}
+
+	return icon, iconSize[icon][0], iconSize[icon][1]
+}
+
 var typeToIcon = map[string]string{
 	// from dump1090
 	"A318": "airliner",
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/tracker"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// How often the live event stream sends batched updates, a full snapshot in
+// case the client missed something, and a keepalive when nothing is
+// happening.
+const liveUpdateInterval = time.Second
+const liveSnapshotInterval = time.Minute
+const liveKeepaliveInterval = 15 * time.Second
+
+// Registration type codes are looked up again after typecodeTTL, so the
+// icons pick up registrations reloaded by dataloader, and the cache is kept
+// to about typecodeCacheSize aircraft.
+const typecodeTTL = time.Hour
+const typecodeCacheSize = 10000
+
+// liveAircraft is the JSON form of an aircraft's current state. Fields that
+// aren't known yet are left out.
+type liveAircraft struct {
+	Icao     string   `json:"icao"`
+	Callsign string   `json:"callsign,omitempty"`
+	Category int      `json:"category"`
+	Lat      *float64 `json:"lat,omitempty"`
+	Lon      *float64 `json:"lon,omitempty"`
+	Altitude *int     `json:"altitude,omitempty"`
+	Speed    *int     `json:"speed,omitempty"`
+	Heading  *int     `json:"heading,omitempty"`
+	VS       *int     `json:"vs,omitempty"`
+	Squawk   string   `json:"squawk,omitempty"`
+	OnGround *bool    `json:"on_ground,omitempty"`
+	Phase    string   `json:"phase,omitempty"`
+	Icon     string   `json:"icon"`
+	IconX    int      `json:"icon_x"`
+	IconY    int      `json:"icon_y"`
+
+	FirstSeen    time.Time  `json:"first_seen"`
+	LastSeen     time.Time  `json:"last_seen"`
+	PositionTime *time.Time `json:"position_time,omitempty"`
+	Messages     int        `json:"messages"`
+	RSSI         *float64   `json:"rssi,omitempty"`
+}
+
+func newLiveAircraft(a tracker.Aircraft) liveAircraft {
+	cur := a.Current
+	l := liveAircraft{
+		Icao:      a.IcaoID,
+		Callsign:  a.Callsign,
+		Category:  int(a.Category),
+		Phase:     string(cur.Phase),
+		FirstSeen: a.FirstSeen,
+		LastSeen:  a.LastSeen,
+		Messages:  a.MessageCount,
+	}
+	if cur.PositionValid {
+		l.Lat, l.Lon = &cur.Latitude, &cur.Longitude
+		l.PositionTime = &a.PositionTime
+	}
+	if cur.AltitudeValid {
+		l.Altitude = &cur.Altitude
+	}
+	if cur.SpeedValid {
+		l.Speed = &cur.Speed
+	}
+	if cur.HeadingValid {
+		l.Heading = &cur.Heading
+	}
+	if cur.VSValid {
+		l.VS = &cur.VS
+	}
+	if cur.SquawkValid {
+		l.Squawk = cur.Squawk
+	}
+	if a.GroundValid {
+		l.OnGround = &a.OnGround
+	}
+	if a.RSSIValid {
+		l.RSSI = &a.RSSI
+	}
+	return l
+}
+
+// liveServer serves the tracker's current state in realtime mode.
+type liveServer struct {
+	track *tracker.Tracker
+	dao   *data.DAO
+
+	mu        sync.Mutex
+	typecodes map[string]typecodeEntry // registration type codes by ICAO ID
+}
+
+type typecodeEntry struct {
+	typecode sql.NullString
+	expires  time.Time
+}
+
+func newLiveServer(track *tracker.Tracker, dao *data.DAO) *liveServer {
+	return &liveServer{track: track, dao: dao, typecodes: make(map[string]typecodeEntry)}
+}
+
+// aircraft converts tracker state to JSON form, with icons. Type codes that
+// aren't cached are looked up without holding the lock, so a slow query
+// doesn't hold up other clients.
+func (ls *liveServer) aircraft(states []tracker.Aircraft) []liveAircraft {
+	now := time.Now()
+	var unknown []string
+	ls.mu.Lock()
+	for _, a := range states {
+		if e, ok := ls.typecodes[a.IcaoID]; !ok || now.After(e.expires) {
+			unknown = append(unknown, a.IcaoID)
+		}
+	}
+	ls.mu.Unlock()
+
+	var found map[string]string
+	var err error
+	if len(unknown) > 0 {
+		// On error we just go without, or with what we had, until next time
+		found, err = ls.dao.GetTypeCodes(unknown)
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if len(unknown) > 0 && err == nil {
+		for _, icao := range unknown {
+			typecode, ok := found[icao]
+			ls.typecodes[icao] = typecodeEntry{sql.NullString{String: typecode, Valid: ok}, now.Add(typecodeTTL)}
+		}
+		ls.pruneTypecodes(now, states)
+	}
+
+	aircraft := make([]liveAircraft, len(states))
+	for i, a := range states {
+		aircraft[i] = newLiveAircraft(a)
+		category := sql.NullInt64{Int64: int64(a.Category), Valid: a.Category != 0}
+		aircraft[i].Icon, aircraft[i].IconX, aircraft[i].IconY = iconFor(ls.typecodes[a.IcaoID].typecode, category)
+	}
+	return aircraft
+}
+
+// pruneTypecodes keeps the type code cache to typecodeCacheSize, first by
+// dropping expired entries and then any but those of the current aircraft.
+func (ls *liveServer) pruneTypecodes(now time.Time, current []tracker.Aircraft) {
+	if len(ls.typecodes) <= typecodeCacheSize {
+		return
+	}
+	for icao, e := range ls.typecodes {
+		if now.After(e.expires) {
+			delete(ls.typecodes, icao)
+		}
+	}
+	if len(ls.typecodes) <= typecodeCacheSize {
+		return
+	}
+	keep := make(map[string]bool, len(current))
+	for _, a := range current {
+		keep[a.IcaoID] = true
+	}
+	for icao := range ls.typecodes {
+		if len(ls.typecodes) <= typecodeCacheSize {
+			break
+		}
+		if !keep[icao] {
+			delete(ls.typecodes, icao)
+		}
+	}
+}
+
+// getLiveDataHandler returns every aircraft the tracker is following.
+func getLiveDataHandler(ls *liveServer) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"now":      time.Now().UTC(),
+			"aircraft": ls.aircraft(ls.track.Snapshot()),
+		})
+	}
+}
+
+// getLiveEventsHandler streams changes to the tracked aircraft as server-sent
+// events. A "snapshot" event lists every aircraft; "update" events list the
+// aircraft that have changed since the last event, and "remove" events list
+// the ICAO IDs of aircraft that are no longer tracked.
+func getLiveEventsHandler(ls *liveServer) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		events, cancel := ls.track.Subscribe(1000)
+		defer cancel()
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(event string, v interface{}) error {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return err
+			}
+			w.Flush()
+			return nil
+		}
+
+		if err := send("snapshot", ls.aircraft(ls.track.Snapshot())); err != nil {
+			return nil
+		}
+
+		updates := time.NewTicker(liveUpdateInterval)
+		defer updates.Stop()
+		lastSnapshot, lastSent := time.Now(), time.Now()
+		changed := make(map[string]tracker.Aircraft)
+		removed := make(map[string]bool)
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+
+			case e, ok := <-events:
+				if !ok {
+					return nil
+				}
+				if e.Type == tracker.AircraftRemoved {
+					delete(changed, e.Aircraft.IcaoID)
+					removed[e.Aircraft.IcaoID] = true
+				} else {
+					delete(removed, e.Aircraft.IcaoID)
+					changed[e.Aircraft.IcaoID] = e.Aircraft
+				}
+
+			case now := <-updates.C:
+				var err error
+				switch {
+				case now.Sub(lastSnapshot) >= liveSnapshotInterval:
+					// Events are dropped if we fall behind; start over now
+					// and then.
+					err = send("snapshot", ls.aircraft(ls.track.Snapshot()))
+					lastSnapshot, lastSent = now, now
+					changed = make(map[string]tracker.Aircraft)
+					removed = make(map[string]bool)
+				case len(changed) > 0 || len(removed) > 0:
+					if len(removed) > 0 {
+						var icaos []string
+						for icao := range removed {
+							icaos = append(icaos, icao)
+						}
+						err = send("remove", icaos)
+						removed = make(map[string]bool)
+					}
+					if len(changed) > 0 && err == nil {
+						var states []tracker.Aircraft
+						for _, a := range changed {
+							states = append(states, a)
+						}
+						err = send("update", ls.aircraft(states))
+						changed = make(map[string]tracker.Aircraft)
+					}
+					lastSent = now
+				case now.Sub(lastSent) >= liveKeepaliveInterval:
+					_, err = fmt.Fprintf(w, ": keepalive\n\n")
+					w.Flush()
+					lastSent = now
+				}
+				if err != nil {
+					// The client has gone away
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// getLiveFlightHandler redirects to the aircraft's flight in progress, or to
+// the aircraft if the flight hasn't been written to the database yet.
+func getLiveFlightHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		icao := strings.ToLower(c.Param("icao"))
+		if !icaoValidator.MatchString(icao) {
+			return c.String(http.StatusNotAcceptable, "Invalid ICAO/transponder ID (must be hex format)")
+		}
+		id, err := dao.GetActiveFlightID(icao)
+		if err == sql.ErrNoRows {
+			return c.Redirect(http.StatusTemporaryRedirect, "/reg/"+icao)
+		}
+		if err != nil {
+			return err
+		}
+		return c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("/flight/%d", id))
+	}
+}
+
+func getLivePageHandler(available bool) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		vals := map[string]interface{}{
+			"Title":       "Live",
+			"section":     "live",
+			"Available":   available,
+			"ReceiverLat": *receiverLat,
+			"ReceiverLon": *receiverLon,
+		}
+		return c.Render(http.StatusOK, "live.html", vals)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPIDocument describes the API routes as an OpenAPI 3 document. Schemas
+// for the response types are found by reflection, from their JSON tags.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{})
+
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(schemaRef(reflect.TypeOf(apiError{}), schemas)),
+	}
+
+	for _, r := range routes {
+		var params []interface{}
+		for _, p := range r.Params {
+			schema := map[string]interface{}{"type": p.Type}
+			if p.Format != "" {
+				schema["format"] = p.Format
+			}
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.Required || p.In == "path",
+				"schema":   schema,
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+
+		var schema map[string]interface{}
+		if r.Paginated {
+			schema = map[string]interface{}{
+				"type":     "object",
+				"required": []string{"data", "pagination"},
+				"properties": map[string]interface{}{
+					"data": map[string]interface{}{
+						"type":  "array",
+						"items": schemaRef(reflect.TypeOf(r.Response), schemas),
+					},
+					"pagination": schemaRef(reflect.TypeOf(apiPagination{}), schemas),
+				},
+			}
+		} else {
+			schema = schemaRef(reflect.TypeOf(r.Response), schemas)
+		}
+
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content":     jsonContent(schema),
+			},
+			"400":     errorResponse,
+			"default": errorResponse,
+		}
+		if r.NotFoundDoc != "" {
+			responses["404"] = map[string]interface{}{
+				"description": r.NotFoundDoc,
+				"content":     jsonContent(schemaRef(reflect.TypeOf(apiError{}), schemas)),
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":   r.Summary,
+			"responses": responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		paths[apiPathToOpenAPI(r.Path)] = map[string]interface{}{
+			strings.ToLower(http.MethodGet): operation,
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "flighttrack",
+			"version": "1",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaRef returns the schema for t. Structs are added to schemas, named
+// for their type without the "api" prefix, and referred to by $ref.
+func schemaRef(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := schemaRef(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "api")
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // in case of recursion
+			properties := make(map[string]interface{})
+			var required []string
+			addProperties(t, properties, &required, schemas)
+			schema := map[string]interface{}{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			schemas[name] = schema
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// addProperties adds the JSON fields of struct t, including those of
+// embedded structs. Fields that aren't pointers are always present.
+func addProperties(t reflect.Type, properties map[string]interface{}, required *[]string, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			addProperties(f.Type, properties, required, schemas)
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaRef(f.Type, schemas)
+		if f.Type.Kind() != reflect.Ptr && !strings.Contains(tag, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// profilePoint is a track log entry as charted on the flight page. ID matches
+// the track log table's rows, so the chart, table and map can point at the
+// same entry.
+type profilePoint struct {
+	ID        int       `json:"id"`
+	Time      time.Time `json:"time"`
+	Altitude  *int64    `json:"altitude"`
+	Speed     *int64    `json:"speed"`
+	VS        *int64    `json:"vs"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Phase     *string   `json:"phase"`
+}
+
+type profile struct {
+	FlightID int            `json:"flight_id"`
+	Points   []profilePoint `json:"points"`
+}
+
+// getFlightProfileHandler serves a flight's altitude, groundspeed and
+// vertical rate over time, for the charts on the flight page.
+func getFlightProfileHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid flight ID")
+		}
+		if _, err = dao.GetFlight(id); err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "No such flight")
+		} else if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		tracklog, err := dao.GetTrackLog(id)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+
+		p := profile{FlightID: id, Points: make([]profilePoint, len(tracklog))}
+		for i, t := range tracklog {
+			p.Points[i] = profilePoint{
+				ID:        t.ID,
+				Time:      t.Time,
+				Altitude:  nullInt(t.Altitude),
+				Speed:     nullInt(t.Speed),
+				VS:        nullInt(t.Vs),
+				Latitude:  nullFloat(t.Latitude),
+				Longitude: nullFloat(t.Longitude),
+				Phase:     nullString(t.Phase),
+			}
+		}
+		return c.JSON(http.StatusOK, p)
+	}
+}
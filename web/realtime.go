@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+
+	"github.com/racingmars/flighttrack/archive"
+	"github.com/racingmars/flighttrack/beast"
+	"github.com/racingmars/flighttrack/dbhandler"
+	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/fanout"
+	"github.com/racingmars/flighttrack/tracker"
+)
+
+// In realtime mode the web server also does the work of dblogger and
+// dbloader: it reads the beast feed from DUMP1090HOST, logs raw messages,
+// and decodes them into flights as they arrive rather than polling the
+// raw_message table. The database ends up the same as with the separate
+// programs, including the saved tracker state, so it's possible to switch
+// between the two at any time.
+
+var realtime = flag.Bool("realtime", false, "Read the beast feed from DUMP1090HOST and decode it in this process, instead of running dblogger and dbloader")
+var flushInterval = flag.Duration("flush", 5*time.Second, "In realtime mode, how often to commit new flights and save the tracker state")
+var stateformat = flag.String("stateformat", "json", "In realtime mode, save tracker state as `json` or binary")
+var trackerFlags = tracker.RegisterFlags(flag.CommandLine)
+
+// feedRetryDelay is how long to wait before reconnecting to the beast feed.
+const feedRetryDelay = 10 * time.Second
+
+// catchUpLive is the number of messages left to catch up with at which the
+// feed is held up while the last of them are decoded, so that decoding can
+// move on to the feed without missing any.
+const catchUpLive = 10000
+
+type realtimeDecoder struct {
+	db      *sqlx.DB
+	feed    string
+	format  tracker.StateFormat
+	lock    *sql.Conn
+	track   *tracker.Tracker
+	handler *dbhandler.Handler
+	outputs *fanout.Handler
+
+	// mu guards how far the tracker has got through raw_message, and
+	// whether the messages read from the feed are decoded as they arrive
+	// (live) or only logged, for catchUp to find in raw_message.
+	mu     sync.Mutex
+	live   bool
+	lastID int64
+
+	messages uint64 // received since starting; use atomically
+}
+
+// startRealtime resumes from the saved tracker state. Catching up with the
+// raw messages logged since it was saved is left to run, so the web site can
+// be served and the feed logged meanwhile.
+func startRealtime(db *sqlx.DB) (*realtimeDecoder, error) {
+	feed, ok := os.LookupEnv("DUMP1090HOST")
+	if !ok {
+		return nil, fmt.Errorf("DUMP1090HOST environment variable not set")
+	}
+	config, err := trackerFlags.Config()
+	if err != nil {
+		return nil, err
+	}
+	format, err := tracker.ParseStateFormat(*stateformat)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &realtimeDecoder{db: db, feed: feed, format: format}
+	if r.lock, err = dbhandler.Lock(db); err != nil {
+		return nil, err
+	}
+
+	trackerstate, handlerstate, lastID, err := dbhandler.LoadState(db)
+	if err != nil && err != sql.ErrNoRows {
+		r.lock.Close()
+		return nil, err
+	}
+	if lastID > 0 && err == sql.ErrNoRows {
+		r.lock.Close()
+		return nil, fmt.Errorf("last message ID > 0, but unable to load all state rows")
+	}
+
+	// The database handler must see every event, but shouldn't hold up
+	// logging raw messages while it waits on the database.
+	r.outputs = fanout.New()
+	if lastID > 0 {
+		if r.handler, err = dbhandler.NewWithState(db, handlerstate); err == nil {
+			r.outputs.Add("database", r.handler, fanout.Options{QueueSize: 10000, Policy: fanout.Block})
+			r.track, err = tracker.NewWithState(r.outputs, config, trackerstate)
+		}
+		if err != nil {
+			r.lock.Close()
+			return nil, err
+		}
+	} else {
+		log.Warn().Msg("No state information found; decoding ALL messages")
+		r.handler = dbhandler.New(db)
+		r.outputs.Add("database", r.handler, fanout.Options{QueueSize: 10000, Policy: fanout.Block})
+		r.track = tracker.New(r.outputs, config)
+	}
+	r.lastID = lastID
+	return r, nil
+}
+
+// catchUp decodes the raw messages logged since the saved state, which with
+// no saved state is all of them and can take hours, while the feed is logged
+// alongside. Once it has caught up, the feed is decoded live. Progress is
+// logged as it goes, and saved if ctx is canceled part way.
+func (r *realtimeDecoder) catchUp(ctx context.Context) error {
+	log.Info().Msgf("Catching up with raw messages after %d", r.lastID)
+	defer r.save()
+	total := 0
+	for {
+		lastID, n, err := dbhandler.ProcessBacklogContext(ctx, r.db, r.track, r.lastID)
+		r.mu.Lock()
+		r.lastID = lastID
+		r.mu.Unlock()
+		total += n
+		if ctx.Err() != nil {
+			log.Info().Msgf("Stopped catching up at raw message %d after %d messages", lastID, total)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n < catchUpLive {
+			break
+		}
+	}
+
+	// The feed waits while the messages it logged during the last pass are
+	// decoded, then carries on from there.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lastID, n, err := dbhandler.ProcessBacklogContext(ctx, r.db, r.track, r.lastID)
+	r.lastID = lastID
+	total += n
+	if ctx.Err() != nil {
+		log.Info().Msgf("Stopped catching up at raw message %d after %d messages", lastID, total)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.live = true
+	log.Info().Msgf("Realtime decoding caught up at raw message %d after %d messages", lastID, total)
+	return nil
+}
+
+// run logs the beast feed until ctx is canceled, reconnecting if the feed is
+// lost, and decodes the messages logged since the saved state alongside.
+// Once that has caught up, the feed is decoded as well. The tracker state is
+// saved before returning.
+func (r *realtimeDecoder) run(ctx context.Context) {
+	defer r.close()
+
+	feedDone := make(chan struct{})
+	go func() {
+		r.readFeed(ctx)
+		close(feedDone)
+	}()
+
+	for ctx.Err() == nil {
+		err := r.catchUp(ctx)
+		if err == nil {
+			break
+		}
+		log.Error().Err(err).Msg("Couldn't catch up with the logged raw messages")
+		r.wait(ctx, feedRetryDelay)
+	}
+	<-feedDone
+}
+
+// readFeed reads the beast feed until ctx is canceled, reconnecting if the
+// feed is lost.
+func (r *realtimeDecoder) readFeed(ctx context.Context) {
+	partitionMonth := time.Time{}
+	nextFlush := time.Now().Add(*flushInterval)
+
+	for ctx.Err() == nil {
+		conn, err := net.Dial("tcp", r.feed)
+		if err != nil {
+			log.Error().Err(err).Msgf("Couldn't connect to beast feed %s", r.feed)
+			r.wait(ctx, feedRetryDelay)
+			continue
+		}
+		stop := make(chan struct{})
+		go func() {
+			// Reads block, so closing the connection is how we stop
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-stop:
+			}
+		}()
+		log.Info().Msgf("Connected to beast feed %s", r.feed)
+
+		rdr := beast.New(conn)
+		for {
+			// raw_message is partitioned by month; see dblogger
+			if month := archive.MonthStart(time.Now()); !month.Equal(partitionMonth) {
+				if err := archive.EnsurePartitions(r.db, month); err != nil {
+					log.Error().Err(err).Msg("Couldn't create raw_message partitions")
+				} else {
+					partitionMonth = month
+				}
+			}
+
+			msg, offset, err := rdr.Read()
+			if _, ok := err.(beast.UnknownFormatError); ok {
+				log.Warn().Err(err).Msgf("At offset %d", offset)
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error().Err(err).Msgf("Lost beast feed %s", r.feed)
+				}
+				break
+			}
+			r.message(msg)
+
+			if time.Now().After(nextFlush) {
+				r.flush()
+				nextFlush = time.Now().Add(*flushInterval)
+			}
+		}
+		close(stop)
+		conn.Close()
+		r.wait(ctx, feedRetryDelay)
+	}
+}
+
+// message logs one message from the feed, and decodes it once catchUp has
+// caught up.
+func (r *realtimeDecoder) message(msg *beast.Message) {
+	atomic.AddUint64(&r.messages, 1)
+	id, created, err := dbhandler.SaveRawMessage(r.db.DB, msg)
+	if err != nil {
+		// Decoding it anyway would make the flights disagree with what
+		// dbloader would find in raw_message.
+		log.Error().Err(err).Msg("Couldn't save raw message")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.live || id <= r.lastID {
+		// Left for catchUp, or already decoded by it
+		return
+	}
+	r.lastID = id
+
+	icao, decoded := decoder.DecodeMessage(msg.Message, created)
+	if icao != "" && icao != "000000" {
+		r.track.MessageWithSignal(icao, created, msg.SignalLevel, decoded)
+	}
+}
+
+// flush saves, once the feed is being decoded; until then catchUp saves.
+func (r *realtimeDecoder) flush() {
+	r.mu.Lock()
+	live := r.live
+	r.mu.Unlock()
+	if live {
+		r.save()
+	}
+}
+
+// save commits the flights and saves the tracker state, so dbloader or a
+// restarted web can carry on from here. The feed waits meanwhile, so the
+// state matches the last message decoded.
+func (r *realtimeDecoder) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastID == 0 {
+		return
+	}
+	r.outputs.Wait()
+	r.handler.Flush()
+	trackerstate, err := r.track.EncodeState(r.format)
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't encode tracker state")
+		return
+	}
+	r.handler.SaveState(trackerstate, r.format, r.lastID)
+}
+
+// messageCount returns the number of messages received from the feed.
+func (r *realtimeDecoder) messageCount() uint64 {
+	return atomic.LoadUint64(&r.messages)
+}
+
+func (r *realtimeDecoder) close() {
+	r.save()
+	r.outputs.Close()
+	r.handler.Close()
+	r.lock.Close()
+}
+
+func (r *realtimeDecoder) wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/replay"
+	"github.com/racingmars/flighttrack/tracker"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// Limits on the replay API, to keep responses to a few megabytes.
+const (
+	replayMaxWindow = 6 * time.Hour
+	replayMaxFrames = 2000
+	// replayFrames is the number of frames the step is chosen for when the
+	// request doesn't give one.
+	replayFrames = 720
+)
+
+// replayMaxGap is the longest gap between two track log positions that
+// replays fill in. It is the tracker's decay time, set from -tracker.decay in
+// main: a moving aircraft is reported at least every -tracker.reportmax while
+// it is received, but a slow or stationary one may not be reported for much
+// longer, and a flight only ends once nothing has been heard from it for the
+// decay time.
+var replayMaxGap = tracker.DefaultConfig().DecayTime
+
+// replayDuration is a length of replay offered on the replay page.
+type replayDuration struct {
+	Minutes int
+	Label   string
+}
+
+var replayDurations = []replayDuration{
+	{15, "15 minutes"},
+	{30, "30 minutes"},
+	{60, "1 hour"},
+	{120, "2 hours"},
+	{240, "4 hours"},
+	{360, "6 hours"},
+}
+
+type apiReplayPosition struct {
+	Frame     int      `json:"frame"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *int64   `json:"altitude"`
+	Heading   *float64 `json:"heading"`
+}
+
+type apiReplayFlight struct {
+	apiFlight
+	Positions []apiReplayPosition `json:"positions"`
+}
+
+type apiReplay struct {
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Step    float64           `json:"step"` // seconds between frames
+	Frames  int               `json:"frames"`
+	Flights []apiReplayFlight `json:"flights"`
+}
+
+var replayParams = []apiParam{
+	{Name: "start", In: "query", Type: "string", Format: "date-time", Description: "Start of the replay (RFC 3339 time, or YYYY-MM-DD for midnight local time); optional with flight"},
+	{Name: "end", In: "query", Type: "string", Format: "date-time", Description: "End of the replay, at most 6 hours after the start; optional with flight"},
+	{Name: "step", In: "query", Type: "integer", Description: "Seconds between frames (default: the window in about 720 frames)"},
+	{Name: "flight", In: "query", Type: "integer", Description: "Only this flight; start and end default to when it was first and last seen"},
+}
+
+// getAPIReplayHandler serves the positions of every flight being received in
+// a time window, interpolated to regular frames for animation.
+func getAPIReplayHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start, err := parseAPIDate(c, "start")
+		if err != nil {
+			return err
+		}
+		end, err := parseAPIDate(c, "end")
+		if err != nil {
+			return err
+		}
+
+		var flights []data.Flight
+		if s := c.QueryParam("flight"); s != "" {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				return apiBadRequest("flight must be a number")
+			}
+			flight, err := dao.GetFlight(id)
+			if err == sql.ErrNoRows {
+				return echo.NewHTTPError(http.StatusNotFound, "No such flight")
+			}
+			if err != nil {
+				return err
+			}
+			if start.IsZero() {
+				start = flight.FirstSeen
+			}
+			if end.IsZero() {
+				// Up to now for a flight in progress
+				end = time.Now()
+				if flight.LastSeen.Valid {
+					end = flight.LastSeen.Time
+				}
+				if end.Sub(start) > replayMaxWindow {
+					end = start.Add(replayMaxWindow)
+				}
+			}
+			flights = []data.Flight{flight}
+		}
+		if start.IsZero() || end.IsZero() {
+			return apiBadRequest("start and end are required without flight")
+		}
+		if !end.After(start) {
+			return apiBadRequest("end must be after start")
+		}
+		if end.Sub(start) > replayMaxWindow {
+			return apiBadRequest("the replay can be at most %v long", replayMaxWindow)
+		}
+
+		step := time.Duration(math.Ceil(end.Sub(start).Seconds()/replayFrames)) * time.Second
+		if s := c.QueryParam("step"); s != "" {
+			seconds, err := strconv.Atoi(s)
+			if err != nil || seconds < 1 {
+				return apiBadRequest("step must be a whole number of seconds, at least 1")
+			}
+			step = time.Duration(seconds) * time.Second
+		}
+		frames := replay.Frames(start, end, step)
+		if frames > replayMaxFrames {
+			return apiBadRequest("step is too small: the replay would be %d frames, and can be at most %d", frames, replayMaxFrames)
+		}
+
+		if flights == nil {
+			if flights, err = dao.GetFlightsBetween(start, end); err != nil {
+				return err
+			}
+		}
+		ids := make([]int, len(flights))
+		for i, f := range flights {
+			ids[i] = f.ID
+		}
+		// Positions just outside the window are needed to fill in its ends
+		tracks, err := dao.GetTrackPositionsBetween(ids, start.Add(-replayMaxGap), end.Add(replayMaxGap))
+		if err != nil {
+			return err
+		}
+
+		result := apiReplay{
+			Start:   start,
+			End:     end,
+			Step:    step.Seconds(),
+			Frames:  frames,
+			Flights: make([]apiReplayFlight, 0),
+		}
+		for _, f := range flights {
+			positions := replay.Sample(replayFixes(tracks[f.ID]), start, step, frames, replayMaxGap)
+			if len(positions) == 0 {
+				continue
+			}
+			rf := apiReplayFlight{apiFlight: newAPIFlight(f), Positions: make([]apiReplayPosition, len(positions))}
+			for i, p := range positions {
+				rf.Positions[i] = newAPIReplayPosition(p)
+			}
+			result.Flights = append(result.Flights, rf)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+func replayFixes(tracklog []data.TrackLog) []replay.Fix {
+	fixes := make([]replay.Fix, 0, len(tracklog))
+	for _, t := range tracklog {
+		if !t.Latitude.Valid || !t.Longitude.Valid {
+			continue
+		}
+		fixes = append(fixes, replay.Fix{
+			Time:        t.Time,
+			Latitude:    t.Latitude.Float64,
+			Longitude:   t.Longitude.Float64,
+			Altitude:    int(t.Altitude.Int64),
+			HasAltitude: t.Altitude.Valid,
+			Heading:     float64(t.Heading.Int64),
+			HasHeading:  t.Heading.Valid,
+		})
+	}
+	return fixes
+}
+
+func newAPIReplayPosition(p replay.Position) apiReplayPosition {
+	rp := apiReplayPosition{
+		Frame: p.Frame,
+		// A few meters is plenty, and keeps the response small
+		Latitude:  math.Round(p.Latitude*1e5) / 1e5,
+		Longitude: math.Round(p.Longitude*1e5) / 1e5,
+	}
+	if p.HasAltitude {
+		alt := int64(p.Altitude)
+		rp.Altitude = &alt
+	}
+	if p.HasHeading {
+		heading := math.Round(p.Heading)
+		rp.Heading = &heading
+	}
+	return rp
+}
+
+// getReplayHandler is the "replay the sky" page, which animates every flight
+// in a window of time.
+func getReplayHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		duration, _ := strconv.Atoi(c.QueryParam("duration"))
+		valid := false
+		for _, d := range replayDurations {
+			valid = valid || d.Minutes == duration
+		}
+		if !valid {
+			duration = 60
+		}
+
+		vals := map[string]interface{}{
+			"Title":       "Replay",
+			"section":     "replay",
+			"Durations":   replayDurations,
+			"Duration":    duration,
+			"ReceiverLat": *receiverLat,
+			"ReceiverLon": *receiverLon,
+			"error":       false,
+			"errmsg":      "",
+		}
+
+		var start time.Time
+		startParam := c.QueryParam("start")
+		if startParam == "" {
+			start = time.Now().Add(-time.Duration(duration) * time.Minute).Truncate(5 * time.Minute)
+		} else {
+			var err error
+			start, err = time.ParseInLocation("2006-01-02T15:04", startParam, time.Local)
+			if err != nil {
+				vals["error"] = true
+				vals["errmsg"] = "Invalid start time. Must be YYYY-MM-DDTHH:MM."
+				vals["StartInput"] = startParam
+				return c.Render(http.StatusOK, "replay.html", vals)
+			}
+		}
+		end := start.Add(time.Duration(duration) * time.Minute)
+		vals["StartInput"] = start.Format("2006-01-02T15:04")
+		vals["Start"] = start.UTC().Format(time.RFC3339)
+		vals["End"] = end.UTC().Format(time.RFC3339)
+		return c.Render(http.StatusOK, "replay.html", vals)
+	}
+}
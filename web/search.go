@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/export"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// searchPageSize is the number of flights on each page of search results.
+const searchPageSize = 100
+
+// searchFields are the query parameters of the search form.
+var searchFields = []string{"callsign", "registration", "icao", "airline", "type", "owner", "start", "end"}
+
+// getSearchHandler finds flights by any combination of callsign (with *
+// wildcards), registration, ICAO ID, airline, type, owner and dates.
+func getSearchHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		form := make(map[string]string)
+		searched := false
+		for _, name := range searchFields {
+			form[name] = strings.TrimSpace(c.QueryParam(name))
+			searched = searched || form[name] != ""
+		}
+		vals := map[string]interface{}{
+			"Title":    "Flight Search",
+			"section":  "search",
+			"form":     form,
+			"searched": searched,
+			"error":    false,
+			"errmsg":   "",
+		}
+		if !searched {
+			return c.Render(http.StatusOK, "search.html", vals)
+		}
+
+		q := data.FlightQuery{
+			Callsign:     form["callsign"],
+			Registration: form["registration"],
+			Icao:         strings.ToLower(form["icao"]),
+			Airline:      form["airline"],
+			TypeCode:     form["type"],
+			Owner:        form["owner"],
+		}
+		var err error
+		if form["start"] != "" {
+			q.Start, q.End, err = export.ParseDates(form["start"], form["end"])
+		} else if form["end"] != "" {
+			_, q.End, err = export.ParseDates(form["end"], "")
+		}
+		if err != nil {
+			vals["error"] = true
+			vals["errmsg"] = "Invalid date. Must be YYYY-MM-DD or YYYY-MM."
+			return c.Render(http.StatusOK, "search.html", vals)
+		}
+		if q.Icao != "" && !icaoValidator.MatchString(q.Icao) {
+			vals["error"] = true
+			vals["errmsg"] = "Invalid ICAO ID. Must be six hex digits."
+			return c.Render(http.StatusOK, "search.html", vals)
+		}
+
+		page, _ := strconv.Atoi(c.QueryParam("page"))
+		if page < 1 {
+			page = 1
+		}
+		offset := (page - 1) * searchPageSize
+		flights, total, err := dao.SearchFlights(q, searchPageSize, offset)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		for i := range flights {
+			flights[i].Icon, flights[i].IconX, flights[i].IconY = iconFor(flights[i].TypeCode, flights[i].Category)
+		}
+
+		vals["Flights"] = flights
+		vals["Total"] = total
+		vals["From"] = offset + 1
+		vals["To"] = offset + len(flights)
+		if page > 1 {
+			vals["PrevURL"] = searchPageURL(form, page-1)
+		}
+		if offset+len(flights) < total {
+			vals["NextURL"] = searchPageURL(form, page+1)
+		}
+		return c.Render(http.StatusOK, "search.html", vals)
+	}
+}
+
+// searchPageURL links to another page of the same search.
+func searchPageURL(form map[string]string, page int) string {
+	q := make(url.Values)
+	for _, name := range searchFields {
+		if form[name] != "" {
+			q.Set(name, form[name])
+		}
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "/search?" + q.Encode()
+}
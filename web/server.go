@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	gotemplate "text/template"
 	"time"
 
@@ -17,17 +21,42 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
-	"github.com/racingmars/flighttrack/decoder"
+	"github.com/racingmars/flighttrack/airport"
+	"github.com/racingmars/flighttrack/coverage"
+	"github.com/racingmars/flighttrack/metrics"
+	"github.com/racingmars/flighttrack/migrate"
+	"github.com/racingmars/flighttrack/periodic"
 	"github.com/racingmars/flighttrack/web/data"
+	"github.com/racingmars/flighttrack/zone"
 )
 
+var migrateSchema = flag.Bool("migrate", false, "Apply pending database schema migrations before starting")
+var airportInterval = flag.Duration("airports", 10*time.Minute, "How often to infer the departure and arrival airports of finished flights, or never if 0")
+
 func main() {
+	flag.Parse()
+
+	trackerConfig, err := trackerFlags.Config()
+	if err != nil {
+		panic(err)
+	}
+	replayMaxGap = trackerConfig.DecayTime
+
 	db, err := getConnection()
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
+	if *migrateSchema {
+		if err = migrate.Up(db.DB); err != nil {
+			panic(err)
+		}
+	}
+	if err = migrate.Check(db.DB); err != nil {
+		panic(err)
+	}
+
 	dao := data.New(db)
 
 	e := echo.New()
@@ -42,19 +71,98 @@ func main() {
 	e.Renderer = t
 
 	e.Use(middleware.Logger())
-	e.Use(middleware.Gzip())
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		// Compressing the live event stream would hold events back
+		Skipper: func(c echo.Context) bool { return c.Path() == "/live/events" },
+	}))
 
 	e.GET("/", getRedirectHandler(http.StatusTemporaryRedirect, "/flights/today"))
 	e.GET("/flights", getRedirectHandler(http.StatusTemporaryRedirect, "/flights/today"))
 	e.GET("/flights/:when", getFlightsHandler(dao))
 	e.GET("/reg/:icao", getRegistrationHandler(dao))
 	e.GET("/reg", getRegSearchHandler(dao))
+	e.GET("/search", getSearchHandler(dao))
+	e.GET("/airlines", getAirlinesHandler(dao))
+	e.GET("/airline/:icao", getAirlineHandler(dao))
+	e.GET("/types", getTypesHandler(dao))
+	e.GET("/type/:code", getTypeHandler(dao))
+	e.GET("/airports", getAirportsHandler(dao))
+	e.GET("/airport/:icao", getAirportHandler(dao))
+	e.GET("/zones", getZonesHandler(dao))
+	e.GET("/zone/:id", getZoneHandler(dao))
 	e.GET("/flight/:id", getFlightHandler(dao))
+	e.GET("/flight/:id/profile.json", getFlightProfileHandler(dao))
+	e.GET("/flight/:id/export/:format", getFlightExportHandler(dao))
+	e.GET("/export/:format", getBatchExportHandler(dao))
+	e.GET("/export/:table/:format", getTableExportHandler(dao))
+	e.GET("/stats", getStatsHandler(dao))
 	e.GET("/about", getAboutHandler(dao))
+	e.GET("/live", getLivePageHandler(*realtime))
+	e.GET("/replay", getReplayHandler())
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+
+	registerAPI(e, dao)
+	htmlErrorHandler := e.HTTPErrorHandler
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		if isAPIRequest(c) {
+			apiErrorHandler(err, c)
+		} else {
+			htmlErrorHandler(err, c)
+		}
+	}
 
 	e.Static("/static", "static")
 
-	e.Logger.Fatal(e.Start(":1324"))
+	if *coverageInterval > 0 {
+		go periodic.Run(context.Background(), "refresh coverage statistics", *coverageInterval, func() error {
+			return coverage.Refresh(db.DB, *receiverLat, *receiverLon)
+		})
+	}
+	if *airportInterval > 0 {
+		go periodic.Run(context.Background(), "infer flight departures and arrivals", *airportInterval, func() error {
+			return airport.Refresh(db.DB)
+		})
+	}
+	if *zoneInterval > 0 {
+		go periodic.Run(context.Background(), "check track points against zones", *zoneInterval, func() error {
+			return zone.Refresh(db.DB)
+		})
+	}
+
+	if *realtime {
+		rt, err := startRealtime(db)
+		if err != nil {
+			panic(err)
+		}
+		ls := newLiveServer(rt.track, dao)
+		e.GET("/live.json", getLiveDataHandler(ls))
+		e.GET("/live/events", getLiveEventsHandler(ls))
+		e.GET("/live/flight/:icao", getLiveFlightHandler(dao))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		dump1090 := newDump1090Server(rt.track, rt.messageCount)
+		go dump1090.recordHistory(ctx)
+		e.GET("/data/:file", getDump1090Handler(dump1090))
+		done := make(chan struct{})
+		go func() {
+			rt.run(ctx)
+			close(done)
+		}()
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			e.Logger.Info("Received termination signal")
+			cancel()
+			<-done // the tracker state is saved
+			e.Shutdown(context.Background())
+		}()
+	}
+
+	if err = e.Start(":1324"); err != http.ErrServerClosed {
+		e.Logger.Fatal(err)
+	}
 }
 
 type template struct {
@@ -111,33 +219,7 @@ func getFlightsHandler(dao *data.DAO) func(c echo.Context) error {
 		}
 
 		for i := range flights {
-			flights[i].Icon = "unknown.svg"
-			if flights[i].TypeCode.Valid {
-				if icon, ok := typeToIcon[flights[i].TypeCode.String]; ok {
-					flights[i].Icon = fmt.Sprintf("%s.svg", icon)
-				}
-			}
-
-			// Didn't find a type code match; try against ADS-B identification category
-			if flights[i].Icon == "unknown.svg" && flights[i].Category.Valid {
-				switch decoder.AircraftType(flights[i].Category.Int64) {
-				case decoder.ACTypeLight:
-					flights[i].Icon = "cessna.svg"
-				case decoder.ACTypeSmall:
-					flights[i].Icon = "jet_swept.svg"
-				case decoder.ACTypeLarge:
-					flights[i].Icon = "airliner.svg"
-				case decoder.ACTypeHighVortexLarge:
-					flights[i].Icon = "airliner.svg"
-				case decoder.ACTypeHeavy:
-					flights[i].Icon = "heavy_2e.svg"
-				case decoder.ACTypeRotocraft:
-					flights[i].Icon = "helicopter.svg"
-				}
-			}
-
-			flights[i].IconX = iconSize[flights[i].Icon][0]
-			flights[i].IconY = iconSize[flights[i].Icon][1]
+			flights[i].Icon, flights[i].IconX, flights[i].IconY = iconFor(flights[i].TypeCode, flights[i].Category)
 		}
 
 		vals := map[string]interface{}{
@@ -145,6 +227,7 @@ func getFlightsHandler(dao *data.DAO) func(c echo.Context) error {
 			"section":    "flights",
 			"Flights":    flights,
 			"DateString": visibledate,
+			"Active":     when == "active",
 		}
 		return c.Render(http.StatusOK, "flightlist.html", vals)
 	}
@@ -183,6 +266,7 @@ func getRegistrationHandler(dao *data.DAO) func(c echo.Context) error {
 			"section":      "aircraft",
 			"FoundRegInfo": foundRegInfo,
 			"RegInfo":      regInfo,
+			"Icao":         icao,
 			"Flights":      flights,
 		}
 		return c.Render(http.StatusOK, "registration.html", vals)
@@ -228,6 +312,11 @@ func getFlightHandler(dao *data.DAO) func(c echo.Context) error {
 			}
 		}
 
+		zoneEvents, err := dao.GetFlightZoneEvents(id)
+		if err != nil {
+			return err
+		}
+
 		vals := map[string]interface{}{
 			"Title":       "Flight Info",
 			"section":     "flights",
@@ -237,6 +326,9 @@ func getFlightHandler(dao *data.DAO) func(c echo.Context) error {
 			"HasTrack":    hasTrack,
 			"PointLat":    pointLat,
 			"PointLon":    pointLon,
+			"ZoneEvents":  zoneEvents,
+			"ReceiverLat": *receiverLat,
+			"ReceiverLon": *receiverLon,
 		}
 		return c.Render(http.StatusOK, "flightdetail.html", vals)
 	}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/coverage"
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+var coverageInterval = flag.Duration("coverage", 10*time.Minute, "How often to refresh the receiver coverage statistics on /stats, or 0 to leave it to another instance")
+
+// The stats page charts messages and aircraft per hour for statsHours hours,
+// messages per day for statsDays days, and the average aircraft in each hour
+// of the day over statsDays days.
+const (
+	statsHours = 48
+	statsDays  = 30
+)
+
+// bandColors are the colors of the altitude bands on the range map, lowest
+// first.
+var bandColors = []string{"#c0392b", "#e67e22", "#27ae60", "#2980b9"}
+
+// statsBand is the coverage of one altitude band.
+type statsBand struct {
+	Label    string
+	Color    string
+	Outline  []data.CoverageRange // the farthest position in each sector that has one
+	Farthest *data.CoverageRange
+	Bearing  string // of the farthest position's sector
+}
+
+func getStatsHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		ranges, err := dao.GetCoverageRanges()
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		updated, err := dao.GetCoverageUpdated()
+		if err != nil {
+			return err
+		}
+
+		bands := make([]statsBand, len(coverage.Bands))
+		for i := range bands {
+			bands[i].Label = bandLabel(i)
+			bands[i].Color = bandColors[i%len(bandColors)]
+		}
+		for i, r := range ranges {
+			if r.Band < 0 || r.Band >= len(bands) {
+				continue
+			}
+			b := &bands[r.Band]
+			b.Outline = append(b.Outline, r)
+			if b.Farthest == nil || r.RangeNM > b.Farthest.RangeNM {
+				b.Farthest = &ranges[i]
+			}
+		}
+
+		for i := range bands {
+			if f := bands[i].Farthest; f != nil {
+				bands[i].Bearing = fmt.Sprintf("%03d–%03d°", f.Sector*coverage.SectorWidth, (f.Sector+1)*coverage.SectorWidth)
+			}
+		}
+
+		now := time.Now().UTC()
+		hourSince := now.Truncate(time.Hour).Add(-(statsHours - 1) * time.Hour)
+		hourlyMessages, err := dao.GetHourlyMessages(hourSince)
+		if err != nil {
+			return err
+		}
+		hourlyAircraft, err := dao.GetHourlyAircraft(hourSince)
+		if err != nil {
+			return err
+		}
+		hours := make([]int, statsHours)
+		for i := range hours {
+			hours[i] = i
+		}
+		hourLabel := func(h int) string { return hourSince.Add(time.Duration(h) * time.Hour).Format("01-02 15:00") }
+
+		daySince := now.Truncate(24*time.Hour).AddDate(0, 0, -(statsDays - 1))
+		dailyMessages, err := dao.GetDailyMessages(daySince)
+		if err != nil {
+			return err
+		}
+		days := make([]int, statsDays)
+		for i := range days {
+			days[i] = i
+		}
+		byHourOfDay, err := dao.GetAircraftByHourOfDay(daySince)
+		if err != nil {
+			return err
+		}
+
+		vals := map[string]interface{}{
+			"Title":          "Receiver Statistics",
+			"section":        "stats",
+			"ReceiverLat":    *receiverLat,
+			"ReceiverLon":    *receiverLon,
+			"Bands":          bands,
+			"HasRanges":      len(ranges) > 0,
+			"SectorWidth":    coverage.SectorWidth,
+			"Updated":        updated,
+			"Hours":          statsHours,
+			"Days":           statsDays,
+			"HourlyMessages": histogram(hourlyMessages, hours, hourLabel),
+			"HourlyAircraft": histogram(hourlyAircraft, hours, hourLabel),
+			"DailyMessages":  histogram(dailyMessages, days, func(d int) string { return daySince.AddDate(0, 0, d).Format("01-02") }),
+			"HourOfDay":      histogram(byHourOfDay, hourKeys, func(h int) string { return fmt.Sprintf("%02d", h) }),
+		}
+		return c.Render(http.StatusOK, "stats.html", vals)
+	}
+}
+
+// bandLabel describes the altitudes in coverage.Bands[i].
+func bandLabel(i int) string {
+	if i == 0 {
+		return fmt.Sprintf("Below %s ft", thousands(coverage.Bands[1]))
+	}
+	if i == len(coverage.Bands)-1 {
+		return fmt.Sprintf("%s ft and above", thousands(coverage.Bands[i]))
+	}
+	return fmt.Sprintf("%s–%s ft", thousands(coverage.Bands[i]), thousands(coverage.Bands[i+1]))
+}
+
+// thousands formats n with comma separators.
+func thousands(n int) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
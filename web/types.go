@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/web/data"
+)
+
+// Type pages chart flights per day for typeDailyDays days, and summarize
+// altitudes and speeds over typePerformanceDays days, since the track log is
+// too big to go through all of it on each request.
+const typeDailyDays = 30
+const typePerformanceDays = 90
+
+var typeCodeValidator = regexp.MustCompile(`^[A-Z0-9]{1,10}$`)
+
+// typeSummary is a type for the type index, with its icon.
+type typeSummary struct {
+	data.TypeActivity
+	Icon         string
+	IconX, IconY int
+}
+
+func getTypesHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		types, err := dao.GetTypes()
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		summaries := make([]typeSummary, len(types))
+		for i, t := range types {
+			summaries[i].TypeActivity = t
+			summaries[i].Icon, summaries[i].IconX, summaries[i].IconY =
+				iconFor(sql.NullString{String: t.TypeCode, Valid: true}, sql.NullInt64{})
+		}
+		vals := map[string]interface{}{
+			"Title":   "Aircraft Types",
+			"section": "types",
+			"Types":   summaries,
+		}
+		return c.Render(http.StatusOK, "types.html", vals)
+	}
+}
+
+func getTypeHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		code := strings.ToUpper(c.Param("code"))
+		if !typeCodeValidator.MatchString(code) {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid type code")
+		}
+
+		activity, err := dao.GetTypeActivity(code)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "No aircraft of this type are registered")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		airframes, err := dao.GetTypeAirframes(code)
+		if err != nil {
+			return err
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(typeDailyDays - 1))
+		daily, err := dao.GetTypeDailyFlights(code, since)
+		if err != nil {
+			return err
+		}
+		days := make([]int, typeDailyDays)
+		for i := range days {
+			days[i] = i
+		}
+		perf, err := dao.GetTypePerformance(code, today.AddDate(0, 0, -typePerformanceDays))
+		if err != nil {
+			return err
+		}
+
+		icon, iconX, iconY := iconFor(sql.NullString{String: code, Valid: true}, sql.NullInt64{})
+		_, hasIcon := typeToIcon[code]
+
+		vals := map[string]interface{}{
+			"Title":           code,
+			"section":         "types",
+			"Type":            activity,
+			"Airframes":       airframes,
+			"Daily":           histogram(daily, days, func(d int) string { return since.AddDate(0, 0, d).Format("01-02") }),
+			"DailyDays":       typeDailyDays,
+			"Performance":     perf,
+			"PerformanceDays": typePerformanceDays,
+			"Icon":            icon,
+			"IconX":           iconX,
+			"IconY":           iconY,
+			"HasIcon":         hasIcon,
+		}
+		return c.Render(http.StatusOK, "type.html", vals)
+	}
+}
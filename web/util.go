@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"math"
+
+	"github.com/racingmars/flighttrack/web/data"
 )
 
 func PrettyLat(decimal float64) string {
@@ -24,3 +26,31 @@ func prettyDegrees(decimal float64, positive, negative string) string {
 	result := fmt.Sprintf("%s %d° %.3f′", sign, int(degrees), minutes)
 	return result
 }
+
+// histogramBar is one bar of a bar chart drawn with CSS.
+type histogramBar struct {
+	Label   string
+	Flights int
+	Percent int // of the largest bar
+}
+
+// histogram turns counts by key into bars for each of keys, in order,
+// including any with no flights.
+func histogram(buckets []data.HistogramBucket, keys []int, label func(int) string) []histogramBar {
+	counts := make(map[int]int)
+	max := 0
+	for _, b := range buckets {
+		counts[b.Key] = b.Flights
+		if b.Flights > max {
+			max = b.Flights
+		}
+	}
+	bars := make([]histogramBar, len(keys))
+	for i, k := range keys {
+		bars[i] = histogramBar{Label: label(k), Flights: counts[k]}
+		if max > 0 {
+			bars[i].Percent = 100 * counts[k] / max
+		}
+	}
+	return bars
+}
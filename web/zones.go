@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/racingmars/flighttrack/web/data"
+	"github.com/racingmars/flighttrack/zone"
+)
+
+var zoneInterval = flag.Duration("zones", time.Minute, "How often to check new track points against the zones, or never if 0")
+
+// zoneEventsLimit is the number of events listed on the zone pages.
+const zoneEventsLimit = 200
+
+type apiZoneCircle struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	RadiusNM  float64 `json:"radius_nm"`
+}
+
+// apiZone is a zone, with either Circle or Polygon set. Polygon vertices are
+// [latitude, longitude].
+type apiZone struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Description *string        `json:"description"`
+	Floor       *int64         `json:"floor"`
+	Ceiling     *int64         `json:"ceiling"`
+	Circle      *apiZoneCircle `json:"circle"`
+	Polygon     [][2]float64   `json:"polygon"`
+	Events      int            `json:"events"`
+	LastEvent   *time.Time     `json:"last_event"`
+}
+
+func newAPIZone(z data.Zone) apiZone {
+	a := apiZone{
+		ID:          z.ID,
+		Name:        z.Name,
+		Description: nullString(z.Description),
+		Floor:       nullInt(z.Floor),
+		Ceiling:     nullInt(z.Ceiling),
+		Events:      z.Events,
+		LastEvent:   nullTime(z.LastEvent),
+	}
+	if z.Polygon.Valid {
+		// The zone package logs zones with bad polygons; leave them off
+		// the maps here.
+		a.Polygon, _ = zone.ParsePolygon(z.Polygon.String)
+	} else {
+		a.Circle = &apiZoneCircle{z.Latitude.Float64, z.Longitude.Float64, z.Radius.Float64}
+	}
+	return a
+}
+
+func getAPIZonesHandler(dao *data.DAO) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		zones, err := dao.GetZones()
+		if err != nil {
+			return err
+		}
+		result := make([]apiZone, len(zones))
+		for i, z := range zones {
+			result[i] = newAPIZone(z)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+func getZonesHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		zones, err := dao.GetZones()
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		events, err := dao.GetZoneEvents(0, zoneEventsLimit)
+		if err != nil {
+			return err
+		}
+		vals := map[string]interface{}{
+			"Title":       "Zones",
+			"section":     "zones",
+			"Zones":       zones,
+			"Events":      events,
+			"EventsLimit": zoneEventsLimit,
+			"ReceiverLat": *receiverLat,
+			"ReceiverLon": *receiverLon,
+		}
+		return c.Render(http.StatusOK, "zones.html", vals)
+	}
+}
+
+func getZoneHandler(dao *data.DAO) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Invalid zone id")
+		}
+		z, err := dao.GetZone(id)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "No such zone")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		events, err := dao.GetZoneEvents(id, zoneEventsLimit)
+		if err != nil {
+			return err
+		}
+		vals := map[string]interface{}{
+			"Title":       z.Name,
+			"section":     "zones",
+			"Zone":        z,
+			"Events":      events,
+			"EventsLimit": zoneEventsLimit,
+			"ReceiverLat": *receiverLat,
+			"ReceiverLon": *receiverLon,
+		}
+		return c.Render(http.StatusOK, "zone.html", vals)
+	}
+}
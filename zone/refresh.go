@@ -0,0 +1,295 @@
+package zone
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// pointBatch is the number of tracklog rows read in each transaction.
+const pointBatch = 50000
+
+// zoneRow is a zone and how far through the track log it has been checked.
+type zoneRow struct {
+	Zone
+	tracklogID int64
+}
+
+// loadZones reads and locks the zones. Zones whose polygon can't be read are
+// logged and left out.
+func loadZones(tx *sql.Tx) ([]zoneRow, error) {
+	rows, err := tx.Query(`SELECT id, name, latitude, longitude, radius_nm, polygon, floor, ceiling, tracklog_id
+		FROM zone ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var zones []zoneRow
+	for rows.Next() {
+		var z zoneRow
+		var lat, lon, radius sql.NullFloat64
+		var polygon sql.NullString
+		var floor, ceiling sql.NullInt64
+		if err = rows.Scan(&z.ID, &z.Name, &lat, &lon, &radius, &polygon, &floor, &ceiling, &z.tracklogID); err != nil {
+			return nil, err
+		}
+		if polygon.Valid {
+			if z.Polygon, err = ParsePolygon(polygon.String); err != nil {
+				log.Error().Err(err).Msgf("Skipping zone %d (%s) with a bad polygon", z.ID, z.Name)
+				continue
+			}
+		} else {
+			z.Latitude, z.Longitude, z.Radius = lat.Float64, lon.Float64, radius.Float64
+		}
+		if floor.Valid {
+			f := int(floor.Int64)
+			z.Floor = &f
+		}
+		if ceiling.Valid {
+			c := int(ceiling.Int64)
+			z.Ceiling = &c
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+// Refresh checks the track log added since the last refresh against every
+// zone and records the entries and exits. Each batch commits as it goes,
+// along with each zone's progress, and the zones are locked while a batch
+// runs, so two refreshes running at once take turns.
+//
+// Then finished flights whose tracks end inside a zone are given an exit at
+// their last track point.
+func Refresh(db *sql.DB) error {
+	for {
+		n, err := refreshPoints(db)
+		if err != nil {
+			return fmt.Errorf("zone: %v", err)
+		}
+		if n < pointBatch {
+			break
+		}
+	}
+	if err := finishFlights(db); err != nil {
+		return fmt.Errorf("zone: %v", err)
+	}
+	return nil
+}
+
+// refreshPoints checks the next batch of tracklog rows, starting after the
+// zone that is furthest behind, and returns the number of rows read.
+func refreshPoints(db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	zones, err := loadZones(tx)
+	if err != nil {
+		return 0, err
+	}
+	if len(zones) == 0 {
+		return 0, nil
+	}
+	from := zones[0].tracklogID
+	for _, z := range zones {
+		if z.tracklogID < from {
+			from = z.tracklogID
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id, flight_id, time, latitude, longitude, altitude
+		FROM tracklog WHERE id > $1 ORDER BY id LIMIT $2`, from, pointBatch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var points []Point
+	var flightIDs []int64
+	seen := make(map[int]bool)
+	last := from
+	n := 0
+	for rows.Next() {
+		var p Point
+		var lat, lon sql.NullFloat64
+		var alt sql.NullInt64
+		if err = rows.Scan(&p.TrackLogID, &p.FlightID, &p.Time, &lat, &lon, &alt); err != nil {
+			return 0, err
+		}
+		n++
+		last = p.TrackLogID
+		if !lat.Valid || !lon.Valid {
+			continue
+		}
+		p.Latitude, p.Longitude = lat.Float64, lon.Float64
+		p.Altitude, p.AltitudeValid = int(alt.Int64), alt.Valid
+		points = append(points, p)
+		if !seen[p.FlightID] {
+			seen[p.FlightID] = true
+			flightIDs = append(flightIDs, int64(p.FlightID))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Carry on from where each flight was left by the last batch
+	detector := NewDetector()
+	rows, err = tx.Query(`SELECT DISTINCT ON (flight_id, zone_id) flight_id, zone_id, event
+		FROM zone_event WHERE flight_id = ANY($1)
+		ORDER BY flight_id, zone_id, id DESC`, pq.Array(flightIDs))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var flightID, zoneID int
+		var event string
+		if err = rows.Scan(&flightID, &zoneID, &event); err != nil {
+			return 0, err
+		}
+		detector.SetInside(flightID, zoneID, event == Entry)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	stmt, err := prepareInsert(tx)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	events := 0
+	for _, p := range points {
+		for i := range zones {
+			if p.TrackLogID <= zones[i].tracklogID {
+				continue
+			}
+			e, ok := detector.Check(&zones[i].Zone, p)
+			if !ok {
+				continue
+			}
+			if err = insertEvent(stmt, e); err != nil {
+				return 0, err
+			}
+			events++
+		}
+	}
+
+	for _, z := range zones {
+		if z.tracklogID < last {
+			if _, err = tx.Exec(`UPDATE zone SET tracklog_id = $2 WHERE id = $1`, z.ID, last); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	if events > 0 {
+		log.Debug().Msgf("Recorded %d zone events", events)
+	}
+	return n, nil
+}
+
+// finishFlights records an exit at the last track point of each finished
+// flight that is still inside a zone. Only zones that have been checked up to
+// that point count, since the flight may yet leave the others in a later
+// batch of its track log.
+func finishFlights(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the zones, as refreshPoints does, so only one refresh finishes a
+	// flight
+	if _, err = tx.Exec(`SELECT id FROM zone ORDER BY id FOR UPDATE`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT e.flight_id, e.zone_id, t.id, t.time, t.latitude, t.longitude, t.altitude
+		FROM zone_event e
+		JOIN flight f ON f.id = e.flight_id
+		JOIN zone z ON z.id = e.zone_id
+		CROSS JOIN LATERAL (SELECT id, time, latitude, longitude, altitude FROM tracklog
+			WHERE flight_id = e.flight_id AND latitude IS NOT NULL AND longitude IS NOT NULL
+			ORDER BY id DESC LIMIT 1) t
+		WHERE e.event = $1 AND f.last_seen IS NOT NULL AND t.id <= z.tracklog_id
+		AND NOT EXISTS (SELECT 1 FROM zone_event later
+			WHERE later.flight_id = e.flight_id AND later.zone_id = e.zone_id AND later.id > e.id)
+		ORDER BY e.flight_id`, Entry)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	detector := NewDetector()
+	var flights []Point
+	for rows.Next() {
+		var p Point
+		var zoneID int
+		var alt sql.NullInt64
+		if err = rows.Scan(&p.FlightID, &zoneID, &p.TrackLogID, &p.Time, &p.Latitude, &p.Longitude, &alt); err != nil {
+			return err
+		}
+		p.Altitude, p.AltitudeValid = int(alt.Int64), alt.Valid
+		detector.SetInside(p.FlightID, zoneID, true)
+		if len(flights) == 0 || flights[len(flights)-1].FlightID != p.FlightID {
+			flights = append(flights, p)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(flights) == 0 {
+		return nil
+	}
+
+	stmt, err := prepareInsert(tx)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	events := 0
+	for _, last := range flights {
+		for _, e := range detector.Finish(last.FlightID, last) {
+			if err = insertEvent(stmt, e); err != nil {
+				return err
+			}
+			events++
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Debug().Msgf("Recorded %d zone exits of finished flights", events)
+	return nil
+}
+
+func prepareInsert(tx *sql.Tx) (*sql.Stmt, error) {
+	return tx.Prepare(`INSERT INTO zone_event (zone_id, flight_id, event, time, latitude, longitude, altitude, tracklog_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+}
+
+func insertEvent(stmt *sql.Stmt, e Event) error {
+	var alt *int
+	if e.AltitudeValid {
+		alt = &e.Altitude
+	}
+	_, err := stmt.Exec(e.ZoneID, e.FlightID, e.Kind, e.Time, e.Latitude, e.Longitude, alt, e.TrackLogID)
+	return err
+}
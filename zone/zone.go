@@ -0,0 +1,177 @@
+// Package zone finds flights entering and leaving geofence zones: named
+// circles or polygons, optionally between floor and ceiling altitudes, kept
+// in the zone table.
+//
+// A Detector turns a flight's track points into entry and exit events, and
+// gives a finished flight still inside a zone an exit at its last point.
+// Refresh runs the track log added since the last refresh through a
+// Detector and records the events in the zone_event table, and web calls it
+// periodically. Each zone keeps its own place in the track log, so a new
+// zone is checked against the whole track log and the others carry on where
+// they were.
+package zone
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/racingmars/flighttrack/geo"
+)
+
+// Zone is a geofence. It is a circle if Polygon is empty, and a polygon
+// otherwise. A nil Floor or Ceiling leaves the zone open below or above.
+type Zone struct {
+	ID   int
+	Name string
+
+	// Circle
+	Latitude  float64
+	Longitude float64
+	Radius    float64 // nautical miles
+
+	// Polygon, as [latitude, longitude] vertices
+	Polygon [][2]float64
+
+	Floor   *int // feet
+	Ceiling *int // feet
+}
+
+// ParsePolygon reads a polygon stored as a JSON array of [latitude,
+// longitude] vertices.
+func ParsePolygon(s string) ([][2]float64, error) {
+	var polygon [][2]float64
+	if err := json.Unmarshal([]byte(s), &polygon); err != nil {
+		return nil, err
+	}
+	if len(polygon) < 3 {
+		return nil, fmt.Errorf("polygon has %d vertices; need at least 3", len(polygon))
+	}
+	return polygon, nil
+}
+
+// Contains returns whether a position is in the zone. known is false if the
+// zone has a floor or ceiling and the altitude isn't known, in which case
+// inside is meaningless.
+func (z *Zone) Contains(lat, lon float64, alt int, altValid bool) (inside, known bool) {
+	if z.Floor != nil || z.Ceiling != nil {
+		if !altValid {
+			return false, false
+		}
+		if (z.Floor != nil && alt < *z.Floor) || (z.Ceiling != nil && alt > *z.Ceiling) {
+			return false, true
+		}
+	}
+	if len(z.Polygon) == 0 {
+		return geo.Distance(z.Latitude, z.Longitude, lat, lon) <= z.Radius, true
+	}
+	return inPolygon(z.Polygon, lat, lon), true
+}
+
+// inPolygon reports whether a point is inside a polygon, by counting the
+// edges that a line east from it crosses. Latitude and longitude are treated
+// as flat, which is fine for zones of a few miles that don't span the date
+// line.
+func inPolygon(polygon [][2]float64, lat, lon float64) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := range polygon {
+		lati, loni := polygon[i][0], polygon[i][1]
+		latj, lonj := polygon[j][0], polygon[j][1]
+		if (lati > lat) != (latj > lat) &&
+			lon < loni+(lat-lati)/(latj-lati)*(lonj-loni) {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// Event kinds
+const (
+	Entry = "entry"
+	Exit  = "exit"
+)
+
+// Point is a track log position.
+type Point struct {
+	TrackLogID    int64
+	FlightID      int
+	Time          time.Time
+	Latitude      float64
+	Longitude     float64
+	Altitude      int
+	AltitudeValid bool
+}
+
+// Event is a flight entering or leaving a zone at a track point.
+type Event struct {
+	ZoneID int
+	Kind   string // Entry or Exit
+	Point
+}
+
+type flightZone struct {
+	flightID, zoneID int
+}
+
+// Detector remembers which zones each flight is in, to tell when it enters
+// and leaves them.
+type Detector struct {
+	inside map[flightZone]bool
+}
+
+// NewDetector returns a Detector with every flight outside every zone.
+func NewDetector() *Detector {
+	return &Detector{inside: make(map[flightZone]bool)}
+}
+
+// SetInside sets whether a flight is in a zone, e.g. from its last event.
+func (d *Detector) SetInside(flightID, zoneID int, inside bool) {
+	d.inside[flightZone{flightID, zoneID}] = inside
+}
+
+// Check returns the event, if any, of a flight's next track point in a zone.
+// A flight first seen inside a zone enters it at its first point there.
+// Points whose altitude is needed but unknown leave the flight where it was.
+func (d *Detector) Check(z *Zone, p Point) (Event, bool) {
+	inside, known := z.Contains(p.Latitude, p.Longitude, p.Altitude, p.AltitudeValid)
+	if !known {
+		return Event{}, false
+	}
+	key := flightZone{p.FlightID, z.ID}
+	if d.inside[key] == inside {
+		return Event{}, false
+	}
+	d.inside[key] = inside
+	kind := Exit
+	if inside {
+		kind = Entry
+	}
+	return Event{ZoneID: z.ID, Kind: kind, Point: p}, true
+}
+
+// Finish returns an exit, at last, from each zone a finished flight is still
+// in, and forgets the flight. last is the flight's final track point, so a
+// flight whose track ends inside a zone, because it landed there or went out
+// of range, leaves the zone where it was last seen. The exits are in zone ID
+// order.
+func (d *Detector) Finish(flightID int, last Point) []Event {
+	var zoneIDs []int
+	for key, inside := range d.inside {
+		if key.flightID != flightID {
+			continue
+		}
+		if inside {
+			zoneIDs = append(zoneIDs, key.zoneID)
+		}
+		delete(d.inside, key)
+	}
+	sort.Ints(zoneIDs)
+	var events []Event
+	for _, id := range zoneIDs {
+		events = append(events, Event{ZoneID: id, Kind: Exit, Point: last})
+	}
+	return events
+}
@@ -0,0 +1,140 @@
+package zone
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func intp(i int) *int { return &i }
+
+func TestContains(t *testing.T) {
+	circle := &Zone{ID: 1, Name: "stadium", Latitude: 47.5952, Longitude: -122.3316, Radius: 3, Ceiling: intp(3000)}
+	square := &Zone{ID: 2, Name: "neighbourhood", Polygon: [][2]float64{
+		{45.50, -122.95}, {45.50, -122.90}, {45.55, -122.90}, {45.55, -122.95},
+	}}
+	// An L shape, to check a point in the notch is outside
+	ell := &Zone{ID: 3, Name: "ell", Floor: intp(500), Polygon: [][2]float64{
+		{0, 0}, {0, 2}, {1, 2}, {1, 1}, {2, 1}, {2, 0},
+	}}
+
+	tests := []struct {
+		z             *Zone
+		lat, lon      float64
+		alt           int
+		altValid      bool
+		inside, known bool
+	}{
+		{circle, 47.60, -122.33, 2000, true, true, true},
+		{circle, 47.60, -122.33, 3500, true, false, true}, // above the ceiling
+		{circle, 47.60, -122.33, 0, false, false, false},  // altitude needed
+		{circle, 47.70, -122.33, 2000, true, false, true}, // 6 nm north
+		{square, 45.52, -122.92, 0, false, true, true},    // no altitude needed
+		{square, 45.52, -122.89, 0, false, false, true},
+		{square, 45.56, -122.92, 0, false, false, true},
+		{ell, 0.5, 1.5, 1000, true, true, true},
+		{ell, 1.5, 0.5, 1000, true, true, true},
+		{ell, 1.5, 1.5, 1000, true, false, true}, // in the notch
+		{ell, 0.5, 0.5, 100, true, false, true},  // below the floor
+	}
+	for _, tt := range tests {
+		inside, known := tt.z.Contains(tt.lat, tt.lon, tt.alt, tt.altValid)
+		if inside != tt.inside || known != tt.known {
+			t.Errorf("%s.Contains(%v, %v, %d, %v) = %v, %v; want %v, %v", tt.z.Name,
+				tt.lat, tt.lon, tt.alt, tt.altValid, inside, known, tt.inside, tt.known)
+		}
+	}
+}
+
+func TestParsePolygon(t *testing.T) {
+	p, err := ParsePolygon(`[[45.5, -122.95], [45.5, -122.9], [45.55, -122.9]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 3 || p[1] != [2]float64{45.5, -122.9} {
+		t.Errorf("ParsePolygon = %v", p)
+	}
+	if _, err = ParsePolygon(`[[45.5, -122.95], [45.5, -122.9]]`); err == nil {
+		t.Error("ParsePolygon accepted two vertices")
+	}
+	if _, err = ParsePolygon(`{"type": "Polygon"}`); err == nil {
+		t.Error("ParsePolygon accepted an object")
+	}
+}
+
+func TestDetector(t *testing.T) {
+	z := &Zone{ID: 5, Latitude: 0, Longitude: 0, Radius: 10, Ceiling: intp(5000)}
+	start := time.Date(2019, 3, 9, 20, 0, 0, 0, time.UTC)
+	point := func(id int64, flight int, lon float64, alt int, altValid bool) Point {
+		return Point{TrackLogID: id, FlightID: flight, Time: start.Add(time.Duration(id) * time.Second),
+			Latitude: 0, Longitude: lon, Altitude: alt, AltitudeValid: altValid}
+	}
+
+	d := NewDetector()
+	points := []Point{
+		point(1, 1, -0.5, 3000, true), // outside, 30 nm west
+		point(2, 1, -0.1, 3000, true), // enters
+		point(3, 2, 0, 4000, true),    // another flight, first seen inside
+		point(4, 1, 0, 0, false),      // altitude unknown: still inside
+		point(5, 1, 0, 6000, true),    // climbs out through the ceiling
+		point(6, 1, 0.1, 4000, true),  // descends back in
+		point(7, 1, 0.5, 4000, true),  // leaves to the east
+	}
+	var got []string
+	for _, p := range points {
+		if e, ok := d.Check(z, p); ok {
+			if e.ZoneID != 5 || e.TrackLogID != p.TrackLogID {
+				t.Errorf("event %+v for point %d", e, p.TrackLogID)
+			}
+			got = append(got, fmt.Sprintf("%s@%d", e.Kind, p.TrackLogID))
+		}
+	}
+	want := []string{"entry@2", "entry@3", "exit@5", "entry@6", "exit@7"}
+	if len(got) != len(want) {
+		t.Fatalf("events %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("events %v; want %v", got, want)
+			break
+		}
+	}
+
+	// A flight known to be inside from an earlier batch doesn't enter again
+	d = NewDetector()
+	d.SetInside(1, 5, true)
+	if e, ok := d.Check(z, point(8, 1, 0, 3000, true)); ok {
+		t.Errorf("Check after SetInside = %+v; want no event", e)
+	}
+	if e, ok := d.Check(z, point(9, 1, 1, 3000, true)); !ok || e.Kind != Exit {
+		t.Errorf("Check leaving = %+v, %v; want exit", e, ok)
+	}
+}
+
+func TestDetectorFinish(t *testing.T) {
+	start := time.Date(2019, 3, 9, 20, 0, 0, 0, time.UTC)
+	last := Point{TrackLogID: 20, FlightID: 1, Time: start, Latitude: 1, Longitude: 2, Altitude: 500, AltitudeValid: true}
+
+	d := NewDetector()
+	d.SetInside(1, 7, true)
+	d.SetInside(1, 3, true)
+	d.SetInside(1, 4, false)
+	d.SetInside(2, 3, true)
+	events := d.Finish(1, last)
+	if len(events) != 2 {
+		t.Fatalf("Finish = %+v; want exits from zones 3 and 7", events)
+	}
+	for i, zoneID := range []int{3, 7} {
+		if e := events[i]; e.ZoneID != zoneID || e.Kind != Exit || e.Point != last {
+			t.Errorf("event %d = %+v; want exit from zone %d at the last point", i, e, zoneID)
+		}
+	}
+
+	// The flight is forgotten, and others are left alone
+	if events = d.Finish(1, last); len(events) != 0 {
+		t.Errorf("second Finish = %+v; want no events", events)
+	}
+	if events = d.Finish(2, last); len(events) != 1 || events[0].ZoneID != 3 {
+		t.Errorf("Finish of another flight = %+v; want exit from zone 3", events)
+	}
+}